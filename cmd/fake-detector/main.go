@@ -0,0 +1,87 @@
+// Command fake-detector is a stand-in for the Python detection server. It
+// speaks the same /ws protocol as RemoteDetector and answers with scripted,
+// random or motion-based boxes, optionally with injected latency, errors and
+// disconnects. With -unix it also serves the shared-memory transport.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type options struct {
+	Addr string
+	Unix string
+	Mode string
+
+	Script  string
+	Models  []string
+	Classes []string
+
+	Latency time.Duration
+	Jitter  time.Duration
+
+	ErrorRate       float64
+	DisconnectRate  float64
+	DisconnectAfter int
+	ReloadEvery     time.Duration
+
+	Encoding string
+	MaxBatch int
+	Legacy   bool
+}
+
+func main() {
+	var opts options
+	var models, classes string
+
+	flag.StringVar(&opts.Addr, "addr", "localhost:8080", "listen address")
+	flag.StringVar(&opts.Unix, "unix", "", "also serve the shm transport on this Unix socket")
+	flag.StringVar(&opts.Mode, "mode", "random", "result mode: random, scripted or motion")
+	flag.StringVar(&opts.Script, "script", "", "JSON file with an array of result lists, used in scripted mode")
+	flag.StringVar(&models, "models", "fake-small,fake-large", "comma-separated models to advertise")
+	flag.StringVar(&classes, "classes", "person,car,bicycle,dog", "comma-separated classes to advertise")
+	flag.DurationVar(&opts.Latency, "latency", 30*time.Millisecond, "processing time per frame")
+	flag.DurationVar(&opts.Jitter, "jitter", 10*time.Millisecond, "random +/- variation of the latency")
+	flag.Float64Var(&opts.ErrorRate, "error-rate", 0, "probability of answering a frame with an error")
+	flag.Float64Var(&opts.DisconnectRate, "disconnect-rate", 0, "probability of dropping the connection after a frame")
+	flag.IntVar(&opts.DisconnectAfter, "disconnect-after", 0, "drop every connection after this many frames (0 = never)")
+	flag.DurationVar(&opts.ReloadEvery, "reload-every", 0, "simulate a model reload at this interval (0 = never)")
+	flag.StringVar(&opts.Encoding, "encoding", "", "upload encoding to select in the handshake (default: client preference)")
+	flag.IntVar(&opts.MaxBatch, "max-batch", 8, "largest batch accepted (0 disables batching)")
+	flag.BoolVar(&opts.Legacy, "legacy", false, "behave like a server without handshake: bare JSON arrays only")
+	flag.Parse()
+
+	opts.Models = splitList(models)
+	opts.Classes = splitList(classes)
+
+	srv, err := newServer(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if opts.Unix != "" {
+		if err := srv.listenShm(opts.Unix); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving the shm transport on %s", opts.Unix)
+	}
+
+	http.HandleFunc("/ws", srv.handleWS)
+
+	log.Printf("Fake detector (%s mode) listening on ws://%s/ws", opts.Mode, opts.Addr)
+	log.Fatal(http.ListenAndServe(opts.Addr, nil))
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+	processing "vision/processing/detector"
+)
+
+// runFrames sends frames one by one and returns their results by position.
+func runFrames(t *testing.T, det processing.Detector, frames []image.Image) []processing.Result {
+	t.Helper()
+
+	results := make([]processing.Result, len(frames))
+	for i, img := range frames {
+		det.Input() <- processing.Frame{ID: uint64(i), Image: img}
+
+		select {
+		case res := <-det.Output():
+			if res.FrameID != uint64(i) {
+				t.Fatalf("result for frame %d, want %d", res.FrameID, i)
+			}
+			results[i] = res
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no result for frame %d", i)
+		}
+	}
+	return results
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv, err := newServer(options{
+		Mode:      "random",
+		Classes:   []string{"person", "car"},
+		Latency:   2 * time.Millisecond,
+		Jitter:    2 * time.Millisecond,
+		ErrorRate: 0.2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", srv.handleWS)
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+
+	// Distinct frames, then the first ones again as a looping video would.
+	var frames []image.Image
+	for i := range 12 {
+		img := image.NewRGBA(image.Rect(0, 0, 32, 24))
+		img.SetRGBA(i, i, color.RGBA{255, uint8(i), 0, 255})
+		frames = append(frames, img)
+	}
+	frames = append(frames, frames[:4]...)
+
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	remote := processing.NewRemoteDetector(strings.TrimPrefix(hs.URL, "http://"), config.DetectorConfig{})
+	rec, err := processing.NewRecordingDetector(remote, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Start()
+	waitConnected(t, rec)
+	recorded := runFrames(t, rec, frames)
+	rec.Stop()
+
+	var failed int
+	for _, r := range recorded {
+		if r.Detections == nil {
+			failed++
+		}
+	}
+	if failed == 0 || failed == len(recorded) {
+		t.Fatalf("%d of %d frames failed, want some of each", failed, len(recorded))
+	}
+
+	checkRecordingOrder(t, path, len(frames))
+
+	for _, mode := range []config.ReplayMode{config.ReplayBySequence, config.ReplayByHash} {
+		t.Run(string(mode), func(t *testing.T) {
+			replay, err := processing.NewReplayDetector(config.ReplayConfig{Path: path, Mode: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			replay.Start()
+			defer replay.Stop()
+
+			replayed := runFrames(t, replay, frames)
+			for i := range frames {
+				if !sameDetections(replayed[i].Detections, recorded[i].Detections) {
+					t.Errorf("frame %d replayed %+v, recorded %+v", i, replayed[i].Detections, recorded[i].Detections)
+				}
+			}
+		})
+	}
+}
+
+func waitConnected(t testing.TB, det processing.Detector) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for det.Status().State != processing.StateConnected {
+		if time.Now().After(deadline) {
+			t.Fatalf("state is %s", det.Status().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// checkRecordingOrder reads the recording back and checks it holds every
+// frame once, in the order the frames were sent and answered.
+func checkRecordingOrder(t *testing.T, path string, frames int) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	type entry struct {
+		Seq  uint64    `json:"seq"`
+		Time time.Time `json:"time"`
+	}
+	var entries []entry
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) != frames {
+		t.Fatalf("recording holds %d entries, want %d", len(entries), frames)
+	}
+	for i, e := range entries {
+		if e.Seq != uint64(i) {
+			t.Errorf("entry %d has seq %d", i, e.Seq)
+		}
+		if i > 0 && e.Time.Before(entries[i-1].Time) {
+			t.Errorf("entry %d recorded before entry %d", i, i-1)
+		}
+	}
+}
+
+// sameDetections compares results as they come back from JSON, where an
+// empty list and a failed frame must stay apart.
+func sameDetections(a, b []models.DetectionResult) bool {
+	if (a == nil) != (b == nil) {
+		return false
+	}
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
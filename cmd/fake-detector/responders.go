@@ -0,0 +1,64 @@
+package main
+
+import (
+	"image"
+	"math/rand"
+
+	"vision/internal/config"
+	"vision/internal/models"
+	processing "vision/processing/detector"
+)
+
+// newResponder picks what answers frames on a connection. Responders share the
+// FrameAnalyzer interface with the built-in detectors.
+func (s *server) newResponder(c *connection) processing.FrameAnalyzer {
+	switch s.opts.Mode {
+	case "scripted":
+		return &scriptedResponder{script: s.script}
+	case "motion":
+		return processing.NewMotionAnalyzer(config.NewDefaultConfig().Detector.Motion)
+	default:
+		return &randomResponder{rng: c.rng, classes: s.opts.Classes}
+	}
+}
+
+// scriptedResponder cycles through a fixed list of result lists, one per
+// frame, independently for every connection.
+type scriptedResponder struct {
+	script [][]models.DetectionResult
+	next   int
+}
+
+func (r *scriptedResponder) Analyze(image.Image) []models.DetectionResult {
+	results := r.script[r.next%len(r.script)]
+	r.next++
+	return results
+}
+
+type randomResponder struct {
+	rng     *rand.Rand
+	classes []string
+}
+
+func (r *randomResponder) Analyze(image.Image) []models.DetectionResult {
+	n := r.rng.Intn(4)
+	results := make([]models.DetectionResult, 0, n)
+
+	for i := 0; i < n; i++ {
+		y1, x1 := r.rng.Float32()*0.8, r.rng.Float32()*0.8
+		h, w := 0.05+r.rng.Float32()*0.15, 0.05+r.rng.Float32()*0.15
+
+		class := "object"
+		if len(r.classes) > 0 {
+			class = r.classes[r.rng.Intn(len(r.classes))]
+		}
+
+		results = append(results, models.DetectionResult{
+			Class: class,
+			Score: 0.3 + r.rng.Float32()*0.7,
+			Box:   []float32{y1, x1, y1 + h, x1 + w},
+		})
+	}
+
+	return results
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"vision/internal/models"
+	processing "vision/processing/detector"
+
+	"github.com/gorilla/websocket"
+)
+
+// The messages below mirror the client side in processing/detector/protocol.go
+// on purpose: the fake server is written against the wire format, not against
+// the client's types, so it catches protocol drift.
+type envelope struct {
+	Type string `json:"type"`
+}
+
+type helloRequest struct {
+	Encodings []string `json:"encodings"`
+	Quality   int      `json:"quality"`
+	MaxBatch  int      `json:"max_batch"`
+}
+
+type helloResponse struct {
+	Type     string `json:"type"`
+	Encoding string `json:"encoding"`
+	MaxBatch int    `json:"max_batch,omitempty"`
+}
+
+type sessionMessage struct {
+	Model      string   `json:"model"`
+	Classes    []string `json:"classes"`
+	Confidence float32  `json:"confidence"`
+	IoU        float32  `json:"iou"`
+}
+
+type capabilitiesMessage struct {
+	Type    string   `json:"type"`
+	Models  []string `json:"models"`
+	Model   string   `json:"model"`
+	Classes []string `json:"classes"`
+}
+
+type resultsMessage struct {
+	Type    string                   `json:"type"`
+	FrameID uint64                   `json:"frame_id"`
+	Results []models.DetectionResult `json:"results"`
+}
+
+type errorMessage struct {
+	Type    string  `json:"type"`
+	FrameID *uint64 `json:"frame_id,omitempty"`
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+}
+
+type statusMessage struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type batchItem struct {
+	FrameID uint64                   `json:"frame_id"`
+	Results []models.DetectionResult `json:"results"`
+	Error   *errorMessage            `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Type    string      `json:"type"`
+	Results []batchItem `json:"results"`
+}
+
+var (
+	rawFrameMagic   = []byte("RGB8")
+	batchFrameMagic = []byte("BTCH")
+
+	errDisconnect = errors.New("injected disconnect")
+)
+
+type server struct {
+	opts     options
+	script   [][]models.DetectionResult
+	upgrader websocket.Upgrader
+}
+
+func newServer(opts options) (*server, error) {
+	s := &server{opts: opts}
+
+	switch opts.Mode {
+	case "random", "motion":
+	case "scripted":
+		data, err := os.ReadFile(opts.Script)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read script: %w", err)
+		}
+		if err := json.Unmarshal(data, &s.script); err != nil {
+			return nil, fmt.Errorf("failed to parse script: %w", err)
+		}
+		if len(s.script) == 0 {
+			return nil, fmt.Errorf("script %s is empty", opts.Script)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode: %s", opts.Mode)
+	}
+
+	return s, nil
+}
+
+// wire is one client connection as the server sees it: frames arrive as
+// binary messages, everything else is a JSON control message.
+type wire interface {
+	ReadMessage() (binary bool, data []byte, err error)
+	WriteJSON(v any) error
+	Close() error
+}
+
+type wsWire struct {
+	conn *websocket.Conn
+}
+
+func (w wsWire) ReadMessage() (bool, []byte, error) {
+	mt, data, err := w.conn.ReadMessage()
+	return mt == websocket.BinaryMessage, data, err
+}
+
+func (w wsWire) WriteJSON(v any) error {
+	return w.conn.WriteJSON(v)
+}
+
+func (w wsWire) Close() error {
+	return w.conn.Close()
+}
+
+func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+
+	s.serveClient(wsWire{conn: ws}, r.RemoteAddr)
+}
+
+func (s *server) serveClient(w wire, name string) {
+	c := &connection{
+		srv:      s,
+		wire:     w,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		encoding: "jpeg",
+		done:     make(chan struct{}),
+	}
+	c.session.Model = s.defaultModel()
+	c.responder = s.newResponder(c)
+
+	log.Printf("Client %s connected", name)
+	err := c.serve()
+	log.Printf("Client %s disconnected: %v", name, err)
+}
+
+func (s *server) defaultModel() string {
+	if len(s.opts.Models) == 0 {
+		return ""
+	}
+	return s.opts.Models[0]
+}
+
+type connection struct {
+	srv  *server
+	wire wire
+	rng  *rand.Rand
+
+	writeMu sync.Mutex
+	done    chan struct{}
+
+	encoding  string
+	maxBatch  int
+	responder processing.FrameAnalyzer
+
+	// session is also read by simulateReloads.
+	sessionMu sync.Mutex
+	session   sessionMessage
+
+	frames uint64
+
+	reloadMu  sync.Mutex
+	reloading bool
+}
+
+func (c *connection) serve() error {
+	defer c.wire.Close()
+	defer close(c.done)
+
+	if c.srv.opts.ReloadEvery > 0 && !c.srv.opts.Legacy {
+		go c.simulateReloads()
+	}
+
+	for {
+		binary, msg, err := c.wire.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		if !binary {
+			if c.srv.opts.Legacy {
+				continue
+			}
+			if err := c.handleControl(msg); err != nil {
+				log.Println("Control message error:", err)
+			}
+			continue
+		}
+
+		if bytes.HasPrefix(msg, batchFrameMagic) && c.maxBatch > 0 {
+			err = c.handleBatch(msg)
+		} else {
+			err = c.handleFrame(msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *connection) write(msg any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.wire.WriteJSON(msg)
+}
+
+func (c *connection) handleControl(msg []byte) error {
+	var env envelope
+	if err := json.Unmarshal(msg, &env); err != nil {
+		return err
+	}
+
+	switch env.Type {
+	case "hello":
+		var req helloRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			return err
+		}
+		return c.hello(req)
+
+	case "session":
+		var sess sessionMessage
+		if err := json.Unmarshal(msg, &sess); err != nil {
+			return err
+		}
+
+		c.sessionMu.Lock()
+		modelChanged := sess.Model != "" && sess.Model != c.session.Model
+		if sess.Model == "" {
+			sess.Model = c.session.Model
+		}
+		c.session = sess
+		c.sessionMu.Unlock()
+
+		if modelChanged {
+			return c.write(c.capabilities())
+		}
+		return nil
+
+	case "capabilities":
+		return c.write(c.capabilities())
+
+	default:
+		return fmt.Errorf("unknown message type %q", env.Type)
+	}
+}
+
+func (c *connection) hello(req helloRequest) error {
+	resp := helloResponse{Type: "hello", Encoding: "jpeg"}
+
+	if c.srv.opts.Encoding != "" {
+		resp.Encoding = c.srv.opts.Encoding
+	} else if len(req.Encodings) > 0 {
+		resp.Encoding = req.Encodings[0]
+	}
+
+	if req.MaxBatch > 1 && c.srv.opts.MaxBatch > 1 {
+		resp.MaxBatch = min(req.MaxBatch, c.srv.opts.MaxBatch)
+	}
+
+	c.encoding = resp.Encoding
+	c.maxBatch = resp.MaxBatch
+	return c.write(resp)
+}
+
+func (c *connection) capabilities() capabilitiesMessage {
+	return capabilitiesMessage{
+		Type:    "capabilities",
+		Models:  c.srv.opts.Models,
+		Model:   c.currentSession().Model,
+		Classes: c.srv.opts.Classes,
+	}
+}
+
+func (c *connection) handleFrame(data []byte) error {
+	c.waitReady()
+	c.sleep()
+
+	id := c.frames
+	c.frames++
+
+	results, ferr := c.process(data)
+
+	var err error
+	switch {
+	case c.srv.opts.Legacy:
+		if results == nil {
+			results = []models.DetectionResult{}
+		}
+		err = c.write(results)
+	case ferr != nil:
+		ferr.FrameID = &id
+		err = c.write(ferr)
+	default:
+		err = c.write(resultsMessage{Type: "results", FrameID: id, Results: results})
+	}
+
+	if err != nil {
+		return err
+	}
+	return c.maybeDisconnect()
+}
+
+func (c *connection) handleBatch(data []byte) error {
+	frames, err := parseBatch(data)
+	if err != nil {
+		return c.write(errorMessage{Type: "error", Code: "bad_batch", Message: err.Error()})
+	}
+
+	c.waitReady()
+	c.sleep()
+
+	resp := batchResponse{Type: "batch"}
+	for _, f := range frames {
+		results, ferr := c.process(f.data)
+		item := batchItem{FrameID: f.id, Results: results, Error: ferr}
+		if ferr == nil && item.Results == nil {
+			item.Results = []models.DetectionResult{}
+		}
+		resp.Results = append(resp.Results, item)
+		c.frames++
+	}
+
+	if err := c.write(resp); err != nil {
+		return err
+	}
+	return c.maybeDisconnect()
+}
+
+// process decodes one frame and produces its results, or the error message
+// the server would send for it.
+func (c *connection) process(data []byte) ([]models.DetectionResult, *errorMessage) {
+	img, err := decodeFrame(data, c.encoding)
+	if err != nil {
+		return nil, &errorMessage{Type: "error", Code: "decode_failed", Message: err.Error()}
+	}
+
+	if c.rng.Float64() < c.srv.opts.ErrorRate {
+		return nil, &errorMessage{Type: "error", Code: "inference_failed", Message: "injected failure"}
+	}
+
+	return c.filter(c.responder.Analyze(img)), nil
+}
+
+// filter applies the session classes and confidence threshold like the real
+// server does.
+func (c *connection) filter(results []models.DetectionResult) []models.DetectionResult {
+	sess := c.currentSession()
+
+	out := make([]models.DetectionResult, 0, len(results))
+	for _, r := range results {
+		if r.Score < sess.Confidence {
+			continue
+		}
+		if len(sess.Classes) > 0 && !slices.Contains(sess.Classes, r.Class) {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (c *connection) sleep() {
+	d := c.srv.opts.Latency
+	if j := c.srv.opts.Jitter; j > 0 {
+		d += time.Duration(c.rng.Int63n(int64(2*j))) - j
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (c *connection) maybeDisconnect() error {
+	opts := c.srv.opts
+
+	if opts.DisconnectAfter > 0 && c.frames >= uint64(opts.DisconnectAfter) {
+		return errDisconnect
+	}
+	if c.rng.Float64() < opts.DisconnectRate {
+		return errDisconnect
+	}
+	return nil
+}
+
+func (c *connection) waitReady() {
+	for {
+		c.reloadMu.Lock()
+		reloading := c.reloading
+		c.reloadMu.Unlock()
+
+		if !reloading {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (c *connection) simulateReloads() {
+	ticker := time.NewTicker(c.srv.opts.ReloadEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.setReloading(true)
+		if c.write(statusMessage{Type: "status", Status: "reloading", Message: "loading " + c.currentSession().Model}) != nil {
+			return
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(time.Second):
+		}
+
+		c.setReloading(false)
+		if c.write(statusMessage{Type: "status", Status: "ready"}) != nil {
+			return
+		}
+	}
+}
+
+func (c *connection) currentSession() sessionMessage {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	return c.session
+}
+
+func (c *connection) setReloading(v bool) {
+	c.reloadMu.Lock()
+	c.reloading = v
+	c.reloadMu.Unlock()
+}
+
+type batchFrame struct {
+	id   uint64
+	data []byte
+}
+
+func parseBatch(data []byte) ([]batchFrame, error) {
+	r := bytes.NewReader(data[len(batchFrameMagic):])
+
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+
+	frames := make([]batchFrame, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var hdr struct {
+			ID     uint64
+			Length uint32
+		}
+		if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+			return nil, err
+		}
+		if int(hdr.Length) > r.Len() {
+			return nil, fmt.Errorf("frame %d is truncated", hdr.ID)
+		}
+
+		payload := make([]byte, hdr.Length)
+		r.Read(payload)
+		frames = append(frames, batchFrame{id: hdr.ID, data: payload})
+	}
+
+	return frames, nil
+}
+
+func decodeFrame(data []byte, encoding string) (image.Image, error) {
+	if bytes.HasPrefix(data, rawFrameMagic) {
+		return decodeRaw(data)
+	}
+
+	switch encoding {
+	case "png":
+		return png.Decode(bytes.NewReader(data))
+	default:
+		return jpeg.Decode(bytes.NewReader(data))
+	}
+}
+
+func decodeRaw(data []byte) (image.Image, error) {
+	if len(data) < 12 {
+		return nil, errors.New("raw frame header is truncated")
+	}
+
+	w := int(binary.BigEndian.Uint32(data[4:8]))
+	h := int(binary.BigEndian.Uint32(data[8:12]))
+	pix := data[12:]
+
+	if len(pix) != w*h*3 {
+		return nil, fmt.Errorf("raw frame has %d bytes, want %d", len(pix), w*h*3)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		copy(img.Pix[i*4:i*4+3], pix[i*3:i*3+3])
+		img.Pix[i*4+3] = 0xff
+	}
+	return img, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+)
+
+func TestRemoteDetector(t *testing.T) {
+	srv, err := newServer(options{
+		Mode:        "random",
+		Models:      []string{"fake-small", "fake-large"},
+		Classes:     []string{"person", "car"},
+		ReloadEvery: 300 * time.Millisecond,
+		MaxBatch:    4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", srv.handleWS)
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+
+	det := processing.NewRemoteDetector(strings.TrimPrefix(hs.URL, "http://"), config.DetectorConfig{
+		Batch:   config.BatchConfig{Size: 4, MaxWaitMs: 10},
+		Session: config.SessionConfig{Confidence: 0.5, IoU: 0.45},
+	})
+	det.Start()
+	defer det.Stop()
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	const frames = 40
+
+	go func() {
+		for i := range uint64(frames) {
+			if i == frames/2 {
+				det.UpdateSession(config.SessionConfig{Model: "fake-large", Classes: []string{"car"}, Confidence: 0.5})
+			}
+			det.Input() <- processing.Frame{ID: i, Image: img}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	var ids []uint64
+	timeout := time.After(10 * time.Second)
+	for len(ids) < frames {
+		select {
+		case res := <-det.Output():
+			for _, d := range res.Detections {
+				if d.Score < 0.5 {
+					t.Errorf("frame %d: detection below the confidence threshold: %+v", res.FrameID, d)
+				}
+			}
+			ids = append(ids, res.FrameID)
+		case <-timeout:
+			t.Fatalf("got %d of %d results", len(ids), frames)
+		}
+	}
+
+	if !slices.IsSorted(ids) || ids[0] != 0 || ids[len(ids)-1] != frames-1 {
+		t.Fatalf("results out of order or missing: %v", ids)
+	}
+
+	waitModel(t, det, "fake-large")
+
+	// Switch models while idle, so the next reload reads the session with
+	// no frame in between and announces the new model.
+	det.UpdateSession(config.SessionConfig{Model: "fake-small", Confidence: 0.5})
+	waitModel(t, det, "fake-small")
+
+	timeout = time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-det.Errors():
+			var serr *processing.ServerError
+			if errors.As(err, &serr) && serr.Code == processing.ServerReloading && serr.Message == "loading fake-small" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no reload of fake-small announced")
+		}
+	}
+}
+
+func waitModel(t *testing.T, det *processing.RemoteDetector, model string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for det.Capabilities().Model != model {
+		if time.Now().After(deadline) {
+			t.Fatalf("capabilities = %+v, want model %s", det.Capabilities(), model)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
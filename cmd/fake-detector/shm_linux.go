@@ -0,0 +1,229 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenShm serves the shared-memory transport on a Unix socket. Frames are
+// read out of the client's ring and handed to the connection as the same raw
+// RGB8 messages a WebSocket client would send, so the rest of the server does
+// not care which transport a client uses.
+func (s *server) listenShm(path string) error {
+	os.Remove(path)
+
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := l.AcceptUnix()
+			if err != nil {
+				log.Println("Accept error:", err)
+				return
+			}
+			go s.serveClient(newShmWire(conn), "unix:"+path)
+		}
+	}()
+	return nil
+}
+
+type shmFrame struct {
+	FrameID uint64 `json:"frame_id"`
+	Slot    int    `json:"slot"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+type shmMessage struct {
+	Type     string     `json:"type"`
+	Slots    int        `json:"slots"`
+	SlotSize int        `json:"slot_size"`
+	Frames   []shmFrame `json:"frames"`
+	shmFrame
+}
+
+type shmWire struct {
+	conn *net.UnixConn
+
+	buf []byte
+	tmp []byte
+	oob []byte
+	fds []int
+
+	ring     []byte
+	slots    int
+	slotSize int
+
+	writeMu sync.Mutex
+}
+
+func newShmWire(conn *net.UnixConn) *shmWire {
+	return &shmWire{
+		conn: conn,
+		tmp:  make([]byte, 64*1024),
+		oob:  make([]byte, unix.CmsgSpace(4*4)),
+	}
+}
+
+func (w *shmWire) ReadMessage() (bool, []byte, error) {
+	for {
+		line, err := w.readLine()
+		if err != nil {
+			return false, nil, err
+		}
+
+		var msg shmMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return false, line, nil
+		}
+
+		switch msg.Type {
+		case "ring":
+			if err := w.mapRing(msg); err != nil {
+				return false, nil, err
+			}
+		case "ping":
+			if err := w.WriteJSON(envelope{Type: "pong"}); err != nil {
+				return false, nil, err
+			}
+		case "frame":
+			data, err := w.rawFrame(msg.shmFrame)
+			if err != nil {
+				return false, nil, err
+			}
+			return true, data, nil
+		case "batch":
+			data, err := w.batch(msg.Frames)
+			if err != nil {
+				return false, nil, err
+			}
+			return true, data, nil
+		default:
+			return false, line, nil
+		}
+	}
+}
+
+// readLine returns the next newline-terminated message, collecting any file
+// descriptors that arrive alongside.
+func (w *shmWire) readLine() ([]byte, error) {
+	for {
+		if i := bytes.IndexByte(w.buf, '\n'); i >= 0 {
+			line := append([]byte(nil), w.buf[:i]...)
+			w.buf = w.buf[i+1:]
+			return line, nil
+		}
+
+		n, oobn, _, _, err := w.conn.ReadMsgUnix(w.tmp, w.oob)
+		if oobn > 0 {
+			w.collectFDs(w.oob[:oobn])
+		}
+		w.buf = append(w.buf, w.tmp[:n]...)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (w *shmWire) collectFDs(oob []byte) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		fds, err := unix.ParseUnixRights(&m)
+		if err == nil {
+			w.fds = append(w.fds, fds...)
+		}
+	}
+}
+
+func (w *shmWire) mapRing(msg shmMessage) error {
+	if len(w.fds) == 0 {
+		return errors.New("ring message without a file descriptor")
+	}
+	fd := w.fds[0]
+	w.fds = w.fds[1:]
+	defer unix.Close(fd)
+
+	ring, err := unix.Mmap(fd, 0, msg.Slots*msg.SlotSize, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("mmap: %w", err)
+	}
+
+	if w.ring != nil {
+		unix.Munmap(w.ring)
+	}
+	w.ring, w.slots, w.slotSize = ring, msg.Slots, msg.SlotSize
+	return nil
+}
+
+func (w *shmWire) rawFrame(f shmFrame) ([]byte, error) {
+	size := f.Width * f.Height * 3
+	if w.ring == nil || f.Slot < 0 || f.Slot >= w.slots || size > w.slotSize {
+		return nil, fmt.Errorf("frame in slot %d does not fit the ring", f.Slot)
+	}
+
+	data := make([]byte, 12+size)
+	copy(data, rawFrameMagic)
+	binary.BigEndian.PutUint32(data[4:8], uint32(f.Width))
+	binary.BigEndian.PutUint32(data[8:12], uint32(f.Height))
+	copy(data[12:], w.ring[f.Slot*w.slotSize:f.Slot*w.slotSize+size])
+	return data, nil
+}
+
+func (w *shmWire) batch(frames []shmFrame) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(batchFrameMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(len(frames)))
+
+	for _, f := range frames {
+		data, err := w.rawFrame(f)
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(&buf, binary.BigEndian, f.FrameID)
+		binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (w *shmWire) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	_, err = w.conn.Write(append(data, '\n'))
+	return err
+}
+
+func (w *shmWire) Close() error {
+	for _, fd := range w.fds {
+		unix.Close(fd)
+	}
+	if w.ring != nil {
+		unix.Munmap(w.ring)
+		w.ring = nil
+	}
+	return w.conn.Close()
+}
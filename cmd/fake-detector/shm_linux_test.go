@@ -0,0 +1,122 @@
+//go:build linux
+
+package main
+
+import (
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+)
+
+func startShm(t testing.TB, srv *server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "detector.sock")
+	if err := srv.listenShm(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestShmTransport(t *testing.T) {
+	srv, err := newServer(options{Mode: "random", Classes: []string{"person"}, MaxBatch: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		batch int
+	}{
+		{"single", 0},
+		{"batched", 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			det := processing.NewRemoteDetector(startShm(t, srv), config.DetectorConfig{
+				Transport: config.TransportShm,
+				Shm:       config.ShmConfig{Slots: 4},
+				Batch:     config.BatchConfig{Size: tc.batch, MaxWaitMs: 5},
+			})
+			det.Start()
+			defer det.Stop()
+			waitConnected(t, det)
+
+			// Frames grow halfway through, which replaces the ring.
+			const frames = 40
+			go func() {
+				for i := range uint64(frames) {
+					size := 32
+					if i >= frames/2 {
+						size = 96
+					}
+					det.Input() <- processing.Frame{ID: i, Image: image.NewRGBA(image.Rect(0, 0, size, size))}
+				}
+			}()
+
+			for i := range uint64(frames) {
+				select {
+				case res := <-det.Output():
+					if res.FrameID != i || res.Detections == nil {
+						t.Fatalf("result %d for frame %d, detections %v", i, res.FrameID, res.Detections)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("no result for frame %d", i)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTransport sends VGA frames through each transport with a few in
+// flight, against a server that answers at once.
+func BenchmarkTransport(b *testing.B) {
+	srv, err := newServer(options{Mode: "random", Classes: []string{"person"}})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", srv.handleWS)
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+	ws := strings.TrimPrefix(hs.URL, "http://")
+
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+
+	for _, tc := range []struct {
+		name string
+		host string
+		cfg  config.DetectorConfig
+	}{
+		{"websocket-jpeg", ws, config.DetectorConfig{}},
+		{"websocket-raw", ws, config.DetectorConfig{Encoding: config.EncodingConfig{Format: config.EncodingRaw}}},
+		{"shm", startShm(b, srv), config.DetectorConfig{Transport: config.TransportShm}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			det := processing.NewRemoteDetector(tc.host, tc.cfg)
+			det.Start()
+			defer det.Stop()
+			waitConnected(b, det)
+
+			b.SetBytes(int64(len(img.Pix)))
+			b.ResetTimer()
+
+			go func() {
+				for i := range uint64(b.N) {
+					det.Input() <- processing.Frame{ID: i, Image: img}
+				}
+			}()
+			for range b.N {
+				if res := <-det.Output(); res.Detections == nil {
+					b.Fatalf("frame %d failed", res.FrameID)
+				}
+			}
+		})
+	}
+}
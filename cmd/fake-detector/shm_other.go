@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func (s *server) listenShm(string) error {
+	return errors.New("the shm transport is only available on linux")
+}
@@ -0,0 +1,223 @@
+// Package bench load-tests a detection server with the same client the app
+// uses, and reports the latency distribution and throughput it sustains.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+)
+
+type Options struct {
+	Endpoint string
+	Source   string
+	Width    int
+	Height   int
+	Frames   int
+
+	// Concurrency is the number of independent connections, each with up to
+	// InFlight frames awaiting results.
+	Concurrency int
+	InFlight    int
+
+	// FPS is the combined send rate over all connections. Zero sends as fast
+	// as the in-flight limits allow.
+	FPS      float64
+	Duration time.Duration
+	Timeout  time.Duration
+
+	Detector config.DetectorConfig
+}
+
+// Run sends frames until Duration has passed or ctx is cancelled, then waits
+// up to Timeout for outstanding results.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.InFlight < 1 {
+		opts.InFlight = 1
+	}
+
+	frames, err := loadFrames(opts.Source, opts.Width, opts.Height, opts.Frames)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]*client, opts.Concurrency)
+	for i := range clients {
+		clients[i] = newClient(opts)
+		clients[i].det.Start()
+		defer clients[i].det.Stop()
+	}
+
+	if err := waitConnected(ctx, clients, opts.Timeout); err != nil {
+		return nil, err
+	}
+
+	r := &run{opts: opts, frames: frames, clients: clients}
+	return r.execute(ctx), nil
+}
+
+func waitConnected(ctx context.Context, clients []*client, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		ready := 0
+		var lastErr error
+		for _, c := range clients {
+			status := c.det.Status()
+			if status.State == processing.StateConnected {
+				ready++
+			} else if status.LastError != nil {
+				lastErr = status.LastError
+			}
+		}
+		if ready == len(clients) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("only %d of %d connections ready after %v (last error: %v)", ready, len(clients), timeout, lastErr)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+type run struct {
+	opts    Options
+	frames  []image.Image
+	clients []*client
+
+	next    atomic.Uint64
+	skipped atomic.Uint64
+}
+
+func (r *run) execute(ctx context.Context) *Report {
+	sendCtx, cancel := context.WithTimeout(ctx, r.opts.Duration)
+	defer cancel()
+
+	start := time.Now()
+
+	var recv sync.WaitGroup
+	recvCtx, stopRecv := context.WithCancel(context.Background())
+	for _, c := range r.clients {
+		recv.Add(1)
+		go func() {
+			defer recv.Done()
+			c.receive(recvCtx, r.opts.Timeout)
+		}()
+	}
+
+	var ticks <-chan struct{}
+	if r.opts.FPS > 0 {
+		ticks = r.pace(sendCtx)
+	}
+
+	var send sync.WaitGroup
+	for _, c := range r.clients {
+		send.Add(1)
+		go func() {
+			defer send.Done()
+			r.sendLoop(sendCtx, c, ticks)
+		}()
+	}
+	send.Wait()
+	sending := time.Since(start)
+
+	r.drain(ctx)
+	stopRecv()
+	recv.Wait()
+
+	return r.report(sending, time.Since(start))
+}
+
+// pace emits FPS ticks per second. A tick that finds every connection at its
+// in-flight limit is counted as skipped rather than queued, so a slow server
+// shows up as a lower throughput instead of ever-growing latencies.
+func (r *run) pace(ctx context.Context) <-chan struct{} {
+	ticks := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.opts.FPS))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case ticks <- struct{}{}:
+				default:
+					r.skipped.Add(1)
+				}
+			}
+		}
+	}()
+
+	return ticks
+}
+
+func (r *run) sendLoop(ctx context.Context, c *client, ticks <-chan struct{}) {
+	for {
+		if !c.acquire(ctx) {
+			return
+		}
+
+		if ticks != nil {
+			select {
+			case <-ctx.Done():
+				c.release()
+				return
+			case <-ticks:
+			}
+		}
+
+		img := r.frames[(r.next.Add(1)-1)%uint64(len(r.frames))]
+		if !c.send(ctx, img) {
+			return
+		}
+	}
+}
+
+// drain waits for the results of frames still in flight once sending stops.
+func (r *run) drain(ctx context.Context) {
+	deadline := time.After(r.opts.Timeout)
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		pending := 0
+		for _, c := range r.clients {
+			pending += c.inFlight()
+		}
+		if pending == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+	}
+}
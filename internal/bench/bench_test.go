@@ -0,0 +1,164 @@
+package bench
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// flakyServer answers every frame with empty results. Its first connection
+// drops after dropAfter frames, and new connections are refused for outage.
+type flakyServer struct {
+	*httptest.Server
+	dropAfter int
+	outage    time.Duration
+
+	mu        sync.Mutex
+	conns     int
+	downUntil time.Time
+}
+
+func newFlakyServer(t *testing.T, dropAfter int, outage time.Duration) *flakyServer {
+	t.Helper()
+
+	s := &flakyServer{dropAfter: dropAfter, outage: outage}
+	upgrader := websocket.Upgrader{}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		if time.Now().Before(s.downUntil) {
+			s.mu.Unlock()
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		s.conns++
+		first := s.conns == 1
+		s.mu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		frames := 0
+		for {
+			typ, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if typ != websocket.BinaryMessage {
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, []byte("[]")); err != nil {
+				return
+			}
+
+			frames++
+			if first && frames == s.dropAfter {
+				s.mu.Lock()
+				s.downUntil = time.Now().Add(s.outage)
+				s.mu.Unlock()
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *flakyServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func writeFrames(t *testing.T, n int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := range n {
+		img := image.NewRGBA(image.Rect(0, 0, 32, 24))
+		img.Pix[i*4] = 255
+
+		f, err := os.Create(filepath.Join(dir, "frame"+strconv.Itoa(i)+".png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	return dir
+}
+
+func TestRunSurvivesReconnect(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		outage    time.Duration
+		duration  time.Duration
+		reconnect bool
+	}{
+		// Sending stops while the server is still down: the detector's queue
+		// is full and the frame stuck on its way there has already timed out.
+		{"ends while down", 3 * time.Second, 1500 * time.Millisecond, false},
+		{"reconnects", 300 * time.Millisecond, 3 * time.Second, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFlakyServer(t, 10, tc.outage)
+
+			done := make(chan *Report, 1)
+			go func() {
+				rep, err := Run(context.Background(), Options{
+					Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+					Source:   writeFrames(t, 3),
+					InFlight: 6,
+					FPS:      100,
+					Duration: tc.duration,
+					Timeout:  200 * time.Millisecond,
+				})
+				if err != nil {
+					t.Error(err)
+				}
+				done <- rep
+			}()
+
+			var rep *Report
+			select {
+			case rep = <-done:
+			case <-time.After(tc.duration + 5*time.Second):
+				t.Fatal("Run did not return")
+			}
+			if rep == nil {
+				return
+			}
+
+			if rep.Sent != rep.Completed+rep.Failed+rep.TimedOut {
+				t.Errorf("sent %d != completed %d + failed %d + timed out %d", rep.Sent, rep.Completed, rep.Failed, rep.TimedOut)
+			}
+			if rep.TimedOut == 0 {
+				t.Error("no frame timed out during the outage")
+			}
+			if rep.Disconnects == 0 {
+				t.Error("disconnect not counted")
+			}
+			if reconnected := srv.connections() > 1; reconnected != tc.reconnect {
+				t.Errorf("reconnected = %v, want %v", reconnected, tc.reconnect)
+			}
+			if tc.reconnect && rep.Completed <= 10 {
+				t.Errorf("completed %d frames, want more after the reconnect", rep.Completed)
+			}
+		})
+	}
+}
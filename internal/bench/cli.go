@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"vision/internal/config"
+)
+
+// Main runs the bench subcommand. Connection settings not given as flags are
+// taken from the app config, so a run exercises the same client setup.
+func Main(args []string) error {
+	cfg := config.LoadConfigFile(config.DefaultConfigPath)
+	dc := cfg.GetDetectorConfig()
+
+	endpoint := config.DefaultDetectorProcessorUrl
+	if len(dc.Endpoints) > 0 {
+		endpoint = dc.Endpoints[0]
+	}
+
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench [flags] <video file or image directory>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	opts := Options{Detector: dc}
+	var encoding, transport, report string
+
+	fs.StringVar(&opts.Endpoint, "endpoint", endpoint, "detector host:port, or socket path with -transport shm")
+	fs.StringVar(&transport, "transport", string(dc.Transport), "detector transport: websocket or shm")
+	fs.IntVar(&opts.Concurrency, "c", 1, "number of concurrent connections")
+	fs.IntVar(&opts.InFlight, "in-flight", dc.MaxInFlight, "frames awaiting results per connection")
+	fs.Float64Var(&opts.FPS, "fps", 0, "combined frame rate over all connections (0 = as fast as possible)")
+	fs.DurationVar(&opts.Duration, "duration", 30*time.Second, "how long to send frames")
+	fs.DurationVar(&opts.Timeout, "timeout", 10*time.Second, "how long to wait for a connection or a result")
+	fs.IntVar(&opts.Frames, "frames", 300, "frames to preload from the source (0 = all)")
+	fs.IntVar(&opts.Width, "width", cfg.GetWidth(), "width video frames are scaled to")
+	fs.IntVar(&opts.Height, "height", cfg.GetHeight(), "height video frames are scaled to")
+	fs.StringVar(&encoding, "encoding", string(dc.Encoding.Format), "upload encoding: jpeg, png or raw")
+	fs.IntVar(&opts.Detector.Encoding.Quality, "quality", dc.Encoding.Quality, "JPEG quality")
+	fs.IntVar(&opts.Detector.Batch.Size, "batch", dc.Batch.Size, "frames per upload")
+	fs.StringVar(&report, "report", "", "write a JSON report to this file (- for stdout)")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one source")
+	}
+
+	opts.Source = fs.Arg(0)
+	opts.Detector.Encoding.Format = config.FrameEncoding(encoding)
+	opts.Detector.Transport = config.DetectorTransport(transport)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	rep, err := Run(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if report == "-" {
+		out = os.Stderr
+	}
+	rep.Print(out)
+
+	if report != "" {
+		return rep.WriteJSON(report)
+	}
+	return nil
+}
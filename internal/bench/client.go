@@ -0,0 +1,162 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync"
+	"time"
+
+	processing "vision/processing/detector"
+)
+
+// client is one detector connection. Results are matched to the frames in
+// flight by ID.
+type client struct {
+	det   *processing.RemoteDetector
+	slots chan struct{}
+
+	mu        sync.Mutex
+	nextID    uint64
+	sentAt    map[uint64]time.Time
+	latencies []time.Duration
+
+	sent        uint64
+	completed   uint64
+	failed      uint64
+	timedOut    uint64
+	disconnects uint64
+	serverErrs  map[string]uint64
+}
+
+func newClient(opts Options) *client {
+	return &client{
+		det:        processing.NewRemoteDetector(opts.Endpoint, opts.Detector),
+		slots:      make(chan struct{}, opts.InFlight),
+		sentAt:     make(map[uint64]time.Time),
+		serverErrs: make(map[string]uint64),
+	}
+}
+
+func (c *client) acquire(ctx context.Context) bool {
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (c *client) release() {
+	<-c.slots
+}
+
+func (c *client) inFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sentAt)
+}
+
+// send records the frame as in flight before handing it over, so a fast
+// result can never arrive ahead of its timestamp. From then on the frame's
+// entry owns the slot: if ctx ends first, send takes the frame back and frees
+// the slot, unless the frame already expired while waiting, which freed it.
+func (c *client) send(ctx context.Context, img image.Image) bool {
+	c.mu.Lock()
+	id := c.nextID
+	c.nextID++
+	c.sentAt[id] = time.Now()
+	c.sent++
+	c.mu.Unlock()
+
+	select {
+	case c.det.Input() <- processing.Frame{ID: id, Image: img}:
+		return true
+	case <-ctx.Done():
+		c.mu.Lock()
+		if _, ok := c.sentAt[id]; ok {
+			delete(c.sentAt, id)
+			c.sent--
+			c.release()
+		}
+		c.mu.Unlock()
+		return false
+	}
+}
+
+func (c *client) receive(ctx context.Context, timeout time.Duration) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	lastState := c.det.Status().State
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case res := <-c.det.Output():
+			c.complete(res.FrameID, res.Detections != nil, time.Now())
+
+		case err := <-c.det.Errors():
+			code := "error"
+			var serr *processing.ServerError
+			if errors.As(err, &serr) {
+				code = serr.Code
+			}
+
+			c.mu.Lock()
+			c.serverErrs[code]++
+			c.mu.Unlock()
+
+		case now := <-ticker.C:
+			c.expire(now, timeout)
+
+			state := c.det.Status().State
+			if state == processing.StateDisconnected && lastState != processing.StateDisconnected {
+				c.mu.Lock()
+				c.disconnects++
+				c.mu.Unlock()
+			}
+			lastState = state
+		}
+	}
+}
+
+// complete accounts for the result of frame id. Results for frames that
+// already timed out are ignored.
+func (c *client) complete(id uint64, ok bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentAt, found := c.sentAt[id]
+	if !found {
+		return
+	}
+
+	rtt := now.Sub(sentAt)
+	delete(c.sentAt, id)
+	c.release()
+
+	if !ok {
+		c.failed++
+		return
+	}
+	c.completed++
+	c.latencies = append(c.latencies, rtt)
+}
+
+// expire gives up on frames lost to a dropped connection, which would
+// otherwise hold their slots forever.
+func (c *client) expire(now time.Time, timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, sentAt := range c.sentAt {
+		if now.Sub(sentAt) > timeout {
+			delete(c.sentAt, id)
+			c.release()
+			c.timedOut++
+		}
+	}
+}
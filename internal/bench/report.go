@@ -0,0 +1,195 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Report is the outcome of a run. Durations are in milliseconds so the JSON
+// form is easy to plot or compare between runs.
+type Report struct {
+	Endpoint    string  `json:"endpoint"`
+	Transport   string  `json:"transport"`
+	Source      string  `json:"source"`
+	Frames      int     `json:"frames"`
+	Concurrency int     `json:"concurrency"`
+	InFlight    int     `json:"in_flight"`
+	TargetFPS   float64 `json:"target_fps"`
+	Encoding    string  `json:"encoding"`
+	BatchSize   int     `json:"batch_size"`
+
+	DurationMs float64 `json:"duration_ms"`
+	Throughput float64 `json:"throughput_fps"`
+
+	Sent        uint64 `json:"sent"`
+	Completed   uint64 `json:"completed"`
+	Failed      uint64 `json:"failed"`
+	TimedOut    uint64 `json:"timed_out"`
+	Skipped     uint64 `json:"skipped"`
+	Disconnects uint64 `json:"disconnects"`
+
+	ServerErrors map[string]uint64 `json:"server_errors,omitempty"`
+
+	Latency LatencySummary `json:"latency_ms"`
+
+	UploadBytes   uint64  `json:"upload_bytes"`
+	AvgFrameBytes int     `json:"avg_frame_bytes"`
+	AvgEncodeMs   float64 `json:"avg_encode_ms"`
+}
+
+type LatencySummary struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+func (r *run) report(sending, total time.Duration) *Report {
+	rep := &Report{
+		Endpoint:     r.opts.Endpoint,
+		Transport:    string(r.opts.Detector.Transport),
+		Source:       r.opts.Source,
+		Frames:       len(r.frames),
+		Concurrency:  r.opts.Concurrency,
+		InFlight:     r.opts.InFlight,
+		TargetFPS:    r.opts.FPS,
+		Encoding:     string(r.opts.Detector.Encoding.Format),
+		BatchSize:    max(r.opts.Detector.Batch.Size, 1),
+		DurationMs:   ms(total),
+		Skipped:      r.skipped.Load(),
+		ServerErrors: make(map[string]uint64),
+	}
+
+	var latencies []time.Duration
+	var encodeTime time.Duration
+	var encoded uint64
+
+	for _, c := range r.clients {
+		c.mu.Lock()
+		rep.Sent += c.sent
+		rep.Completed += c.completed
+		rep.Failed += c.failed
+		// Frames still unanswered after the drain are as good as lost.
+		rep.TimedOut += c.timedOut + uint64(len(c.sentAt))
+		rep.Disconnects += c.disconnects
+		for code, n := range c.serverErrs {
+			rep.ServerErrors[code] += n
+		}
+		latencies = append(latencies, c.latencies...)
+		c.mu.Unlock()
+
+		es := c.det.EncodeStats()
+		rep.UploadBytes += es.Bytes
+		encoded += es.Frames
+		encodeTime += es.AvgEncode * time.Duration(es.Frames)
+		if es.Encoding != "" {
+			rep.Encoding = string(es.Encoding)
+		}
+	}
+
+	if encoded > 0 {
+		rep.AvgFrameBytes = int(rep.UploadBytes / encoded)
+		rep.AvgEncodeMs = ms(encodeTime / time.Duration(encoded))
+	}
+
+	if sending > 0 {
+		rep.Throughput = float64(rep.Completed) / sending.Seconds()
+	}
+
+	rep.Latency = summarize(latencies)
+	return rep
+}
+
+func summarize(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+
+	slices.Sort(latencies)
+
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+
+	return LatencySummary{
+		Min:  ms(latencies[0]),
+		Mean: ms(sum / time.Duration(len(latencies))),
+		P50:  ms(percentile(latencies, 50)),
+		P95:  ms(percentile(latencies, 95)),
+		P99:  ms(percentile(latencies, 99)),
+		Max:  ms(latencies[len(latencies)-1]),
+	}
+}
+
+// percentile uses the nearest-rank method on sorted values.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+func ms(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+func (rep *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Endpoint:     %s (%s)\n", rep.Endpoint, rep.Transport)
+	fmt.Fprintf(w, "Source:       %s (%d frames)\n", rep.Source, rep.Frames)
+	fmt.Fprintf(w, "Load:         %d connections x %d in flight, ", rep.Concurrency, rep.InFlight)
+	if rep.TargetFPS > 0 {
+		fmt.Fprintf(w, "%.1f fps target\n", rep.TargetFPS)
+	} else {
+		fmt.Fprintf(w, "unthrottled\n")
+	}
+	fmt.Fprintf(w, "Upload:       %s, batch %d, %.1f KB/frame, %.2f ms encode\n",
+		rep.Encoding, rep.BatchSize, float64(rep.AvgFrameBytes)/1024, rep.AvgEncodeMs)
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "Duration:     %.1f s\n", rep.DurationMs/1000)
+	fmt.Fprintf(w, "Throughput:   %.1f frames/s\n", rep.Throughput)
+	fmt.Fprintf(w, "Frames:       %d sent, %d ok, %d failed, %d timed out, %d skipped\n",
+		rep.Sent, rep.Completed, rep.Failed, rep.TimedOut, rep.Skipped)
+	fmt.Fprintf(w, "Disconnects:  %d\n", rep.Disconnects)
+
+	if len(rep.ServerErrors) > 0 {
+		codes := make([]string, 0, len(rep.ServerErrors))
+		for code := range rep.ServerErrors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+
+		fmt.Fprintf(w, "Errors:      ")
+		for _, code := range codes {
+			fmt.Fprintf(w, " %s=%d", code, rep.ServerErrors[code])
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+
+	l := rep.Latency
+	fmt.Fprintf(w, "Latency (ms): min %.1f  mean %.1f  p50 %.1f  p95 %.1f  p99 %.1f  max %.1f\n",
+		l.Min, l.Mean, l.P50, l.P95, l.P99, l.Max)
+}
+
+// WriteJSON writes the report to path, or to stdout when path is "-".
+func (rep *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
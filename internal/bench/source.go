@@ -0,0 +1,112 @@
+package bench
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	stream "vision/processing/capture"
+)
+
+// videoDecodeFPS is the rate frames are pulled out of ffmpeg while preloading
+// a video. It only needs to be fast; the benchmark paces frames itself.
+const videoDecodeFPS = 1000
+
+// loadFrames decodes up to limit frames from an image directory or a video
+// file. Frames are kept in memory so decoding does not skew the measurements.
+func loadFrames(path string, width, height, limit int) ([]image.Image, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []image.Image
+	if info.IsDir() {
+		frames, err = loadImageDir(path, limit)
+	} else {
+		frames, err = loadVideo(path, width, height, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames found in %s", path)
+	}
+	return frames, nil
+}
+
+func loadImageDir(dir string, limit int) ([]image.Image, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".jpg", ".jpeg", ".png":
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var frames []image.Image
+	for _, name := range names {
+		if limit > 0 && len(frames) >= limit {
+			break
+		}
+
+		img, err := decodeImage(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, img)
+	}
+
+	return frames, nil
+}
+
+func decodeImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return img, nil
+}
+
+func loadVideo(path string, width, height, limit int) ([]image.Image, error) {
+	ls, err := stream.NewLocalStreamer(path, videoDecodeFPS, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ls.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	defer ls.Stop()
+
+	var frames []image.Image
+	for img := range ls.FrameChan() {
+		frames = append(frames, img)
+		if limit > 0 && len(frames) >= limit {
+			break
+		}
+	}
+
+	return frames, nil
+}
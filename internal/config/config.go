@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"maps"
 	"os"
 	"sync"
 )
@@ -17,6 +18,61 @@ const (
 	DefaultDetectorProcessorUrl string = "localhost:8080"
 )
 
+type DetectorBackend string
+
+const (
+	BackendRemote DetectorBackend = "remote"
+	BackendMotion DetectorBackend = "motion"
+	BackendColor  DetectorBackend = "color"
+	BackendReplay DetectorBackend = "replay"
+)
+
+// DetectorTransport is how frames reach a remote detector. With TransportShm
+// the endpoints are Unix socket paths and the server must run on this host.
+type DetectorTransport string
+
+const (
+	TransportWebSocket DetectorTransport = "websocket"
+	TransportShm       DetectorTransport = "shm"
+)
+
+type ReplayMode string
+
+const (
+	ReplayByHash     ReplayMode = "hash"
+	ReplayBySequence ReplayMode = "sequence"
+)
+
+type BalanceStrategy string
+
+const (
+	BalanceRoundRobin    BalanceStrategy = "round-robin"
+	BalanceLeastInFlight BalanceStrategy = "least-in-flight"
+	BalanceLatency       BalanceStrategy = "latency-weighted"
+)
+
+type SyncMode string
+
+const (
+	SyncLatest SyncMode = "latest"
+	SyncExact  SyncMode = "synced"
+)
+
+type NMSMode string
+
+const (
+	NMSOff      NMSMode = "off"
+	NMSPerClass NMSMode = "class"
+	NMSAgnostic NMSMode = "agnostic"
+)
+
+type LogFormat string
+
+const (
+	LogJSONL LogFormat = "jsonl"
+	LogCSV   LogFormat = "csv"
+)
+
 var SourcesList = [...]string{
 	string(SourceLocal),
 	string(SourceWebcam),
@@ -35,6 +91,266 @@ type YouTubeConfig struct {
 	URL string `json:"url"`
 }
 
+type FrameEncoding string
+
+const (
+	EncodingJPEG FrameEncoding = "jpeg"
+	EncodingPNG  FrameEncoding = "png"
+	EncodingRaw  FrameEncoding = "raw"
+)
+
+// EncodingConfig is the preferred upload format. The server may answer the
+// handshake with a different one, which then wins.
+type EncodingConfig struct {
+	Format  FrameEncoding `json:"format"`
+	Quality int           `json:"quality"`
+}
+
+// BatchConfig groups up to Size frames into one upload, sending a partial
+// batch once the oldest frame has waited MaxWaitMs. A size of 1 disables it.
+type BatchConfig struct {
+	Size      int `json:"size"`
+	MaxWaitMs int `json:"max_wait_ms"`
+}
+
+// SessionConfig is sent to the detection server on connect and whenever it
+// changes. Empty Model and Classes leave the server defaults in place; the
+// thresholds are always sent, so 0 means 0.
+type SessionConfig struct {
+	Model      string   `json:"model"`
+	Classes    []string `json:"classes"`
+	Confidence float32  `json:"confidence"`
+	IoU        float32  `json:"iou"`
+}
+
+// ShmConfig sizes the shared-memory ring frames are passed through. Each slot
+// holds one raw frame, so Slots bounds the frames awaiting results.
+type ShmConfig struct {
+	Slots int `json:"slots"`
+}
+
+// ReplayConfig selects a recording made with DetectorConfig.Record. Frames are
+// matched by pixel hash, or purely by their position in the stream.
+type ReplayConfig struct {
+	Path string     `json:"path"`
+	Mode ReplayMode `json:"mode"`
+}
+
+type MotionConfig struct {
+	Threshold    uint8   `json:"threshold"`
+	LearningRate float32 `json:"learning_rate"`
+	MinArea      float32 `json:"min_area"`
+}
+
+// ColorRange selects pixels by HSV. Hue is in degrees and may wrap around
+// (HueMin > HueMax), saturation and value are in [0, 1].
+type ColorRange struct {
+	Name   string  `json:"name"`
+	HueMin float32 `json:"hue_min"`
+	HueMax float32 `json:"hue_max"`
+	SatMin float32 `json:"sat_min"`
+	SatMax float32 `json:"sat_max"`
+	ValMin float32 `json:"val_min"`
+	ValMax float32 `json:"val_max"`
+}
+
+type ColorConfig struct {
+	Ranges  []ColorRange `json:"ranges"`
+	MinArea float32      `json:"min_area"`
+}
+
+type DetectorConfig struct {
+	Backend   DetectorBackend   `json:"backend"`
+	Transport DetectorTransport `json:"transport"`
+	Endpoints []string          `json:"endpoints"`
+	Balance   BalanceStrategy   `json:"balance"`
+	Encoding  EncodingConfig    `json:"encoding"`
+	Batch     BatchConfig       `json:"batch"`
+	Shm       ShmConfig         `json:"shm"`
+
+	// Legacy skips the handshake, for servers that only understand frames.
+	Legacy bool `json:"legacy"`
+
+	// MaxInFlight caps how many frames may await results at once.
+	MaxInFlight int `json:"max_in_flight"`
+
+	Session SessionConfig `json:"session"`
+
+	Motion MotionConfig `json:"motion"`
+	Color  ColorConfig  `json:"color"`
+
+	// Record, when set, is the file every frame hash and its results are
+	// written to, whichever backend is active.
+	Record string       `json:"record"`
+	Replay ReplayConfig `json:"replay"`
+}
+
+// SyncConfig decides which detections are drawn over a frame. SyncLatest
+// draws the newest results on every frame; SyncExact holds each frame back
+// until its own results arrive, for at most MaxDelayMs.
+type SyncConfig struct {
+	Mode       SyncMode `json:"mode"`
+	MaxDelayMs int      `json:"max_delay_ms"`
+}
+
+// TrackingConfig controls the tracker that gives detections persistent IDs.
+// A track is confirmed after MinHits matched frames and dropped after MaxAge
+// frames without a match. Detections are matched to tracks of the same class
+// whose predicted box overlaps them by at least IoU.
+type TrackingConfig struct {
+	Enabled bool    `json:"enabled"`
+	MaxAge  int     `json:"max_age"`
+	MinHits int     `json:"min_hits"`
+	IoU     float32 `json:"iou"`
+}
+
+// PostProcessConfig filters the detector's boxes before they are tracked. An
+// empty Allow admits every class not in Deny; areas are fractions of the
+// frame, and a MaxArea of 0 means no upper limit.
+type PostProcessConfig struct {
+	MinScore      float32            `json:"min_score"`
+	ClassMinScore map[string]float32 `json:"class_min_score"`
+	Allow         []string           `json:"allow"`
+	Deny          []string           `json:"deny"`
+	NMS           NMSMode            `json:"nms"`
+	NMSIoU        float32            `json:"nms_iou"`
+	MinArea       float32            `json:"min_area"`
+	MaxArea       float32            `json:"max_area"`
+	Clip          bool               `json:"clip"`
+}
+
+// Point is a position in normalised frame coordinates, with x growing to the
+// right and y downwards.
+type Point struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+// ZoneConfig is a polygon whose occupancy is counted. An empty Classes counts
+// every class.
+type ZoneConfig struct {
+	Name    string   `json:"name"`
+	Polygon []Point  `json:"polygon"`
+	Classes []string `json:"classes"`
+}
+
+// LineConfig is a directed counting line from A to B. Tracked objects whose
+// center crosses it from the left of A→B to its right, as seen in the image,
+// are counted as in, and the other way round as out.
+type LineConfig struct {
+	Name    string   `json:"name"`
+	A       Point    `json:"a"`
+	B       Point    `json:"b"`
+	Classes []string `json:"classes"`
+}
+
+type ZonesConfig struct {
+	Zones []ZoneConfig `json:"zones"`
+	Lines []LineConfig `json:"lines"`
+}
+
+// RuleConfig fires an alert once MinCount detections of Class scoring at
+// least MinScore, inside Zone if set, have held for DurationMs; the rule then
+// rests for CooldownMs. Names must be unique.
+type RuleConfig struct {
+	Name       string  `json:"name"`
+	Class      string  `json:"class"`
+	MinScore   float32 `json:"min_score"`
+	Zone       string  `json:"zone"`
+	MinCount   int     `json:"min_count"`
+	DurationMs int     `json:"duration_ms"`
+	CooldownMs int     `json:"cooldown_ms"`
+}
+
+// AlertsConfig holds the alert rules. History is how many alerts the UI
+// keeps.
+type AlertsConfig struct {
+	Rules   []RuleConfig `json:"rules"`
+	History int          `json:"history"`
+}
+
+// WebhookConfig POSTs events as JSON to every URL, signed with Secret if set.
+// Deliveries failing MaxRetries times wait in QueueDir, which keeps the newest
+// QueueMax of them. RatePerSec caps requests, 0 meaning no limit.
+type WebhookConfig struct {
+	Enabled    bool     `json:"enabled"`
+	URLs       []string `json:"urls"`
+	Alerts     bool     `json:"alerts"`
+	Detections bool     `json:"detections"`
+	Secret     string   `json:"secret"`
+	TimeoutMs  int      `json:"timeout_ms"`
+	MaxRetries int      `json:"max_retries"`
+	RetryMinMs int      `json:"retry_min_ms"`
+	RetryMaxMs int      `json:"retry_max_ms"`
+	RatePerSec float64  `json:"rate_per_sec"`
+	QueueDir   string   `json:"queue_dir"`
+	QueueMax   int      `json:"queue_max"`
+}
+
+// MQTTConfig controls the MQTT sink, which publishes under TopicPrefix. With
+// Discovery set, Home Assistant entities are announced under DiscoveryPrefix.
+type MQTTConfig struct {
+	Enabled         bool   `json:"enabled"`
+	Broker          string `json:"broker"`
+	ClientID        string `json:"client_id"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	QoS             byte   `json:"qos"`
+	TopicPrefix     string `json:"topic_prefix"`
+	Detections      bool   `json:"detections"`
+	Counts          bool   `json:"counts"`
+	Alerts          bool   `json:"alerts"`
+	Discovery       bool   `json:"discovery"`
+	DiscoveryPrefix string `json:"discovery_prefix"`
+}
+
+// DetectionLogConfig controls the detection log. A new file is started in
+// Dir once the current one reaches MaxSizeMB, and only the newest MaxFiles
+// files are kept; 0 keeps them all.
+type DetectionLogConfig struct {
+	Enabled   bool      `json:"enabled"`
+	Dir       string    `json:"dir"`
+	Format    LogFormat `json:"format"`
+	MaxSizeMB int       `json:"max_size_mb"`
+	MaxFiles  int       `json:"max_files"`
+}
+
+// HistoryConfig controls the detection history database. Records older than
+// MaxAgeHours are deleted, and the oldest records go first once the data
+// outgrows MaxSizeMB. A limit of 0 is no limit.
+type HistoryConfig struct {
+	Enabled     bool   `json:"enabled"`
+	Path        string `json:"path"`
+	MaxAgeHours int    `json:"max_age_hours"`
+	MaxSizeMB   int    `json:"max_size_mb"`
+}
+
+// SnapshotConfig saves the frame (Full) and each object (Crops) when Classes,
+// any class if empty, score at least MinScore. A class that triggered a
+// snapshot is then ignored for CooldownMs.
+type SnapshotConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Dir        string   `json:"dir"`
+	Classes    []string `json:"classes"`
+	MinScore   float32  `json:"min_score"`
+	Full       bool     `json:"full"`
+	Crops      bool     `json:"crops"`
+	CooldownMs int      `json:"cooldown_ms"`
+	Quality    int      `json:"quality"`
+}
+
+// RecorderConfig controls the FFmpeg video recorder. Bitrate is an FFmpeg
+// rate such as "4M". A SegmentMinutes of 0 records into a single file, and an
+// FPS of 0 records at the capture frame rate.
+type RecorderConfig struct {
+	Dir            string `json:"dir"`
+	Container      string `json:"container"`
+	Codec          string `json:"codec"`
+	Bitrate        string `json:"bitrate"`
+	FPS            int    `json:"fps"`
+	SegmentMinutes int    `json:"segment_minutes"`
+}
+
 type Config struct {
 	mu sync.RWMutex
 
@@ -46,6 +362,19 @@ type Config struct {
 	Local   LocalConfig   `json:"local"`
 	Webcam  WebcamConfig  `json:"webcam"`
 	YouTube YouTubeConfig `json:"youtube"`
+
+	Detector    DetectorConfig     `json:"detector"`
+	Sync        SyncConfig         `json:"sync"`
+	Tracking    TrackingConfig     `json:"tracking"`
+	PostProcess PostProcessConfig  `json:"postprocess"`
+	Zones       ZonesConfig        `json:"zones"`
+	Alerts      AlertsConfig       `json:"alerts"`
+	Webhook     WebhookConfig      `json:"webhook"`
+	MQTT        MQTTConfig         `json:"mqtt"`
+	Log         DetectionLogConfig `json:"log"`
+	History     HistoryConfig      `json:"history"`
+	Snapshot    SnapshotConfig     `json:"snapshot"`
+	Recorder    RecorderConfig     `json:"recorder"`
 }
 
 func (c *Config) GetFPS() uint {
@@ -84,6 +413,207 @@ func (c *Config) SetHeight(height int) {
 	c.ScaledHeight = height
 }
 
+// SourceName describes the active source, e.g. for logs.
+func (c *Config) SourceName() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	switch c.ActiveSource {
+	case SourceWebcam:
+		return "webcam:" + c.Webcam.DeviceID
+	case SourceYouTube:
+		return c.YouTube.URL
+	default:
+		return c.Local.Path
+	}
+}
+
+func (c *Config) GetDetectorConfig() DetectorConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	dc := c.Detector
+	dc.Endpoints = append([]string(nil), dc.Endpoints...)
+	dc.Color.Ranges = append([]ColorRange(nil), dc.Color.Ranges...)
+	dc.Session.Classes = append([]string(nil), dc.Session.Classes...)
+	return dc
+}
+
+func (c *Config) GetSession() SessionConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s := c.Detector.Session
+	s.Classes = append([]string(nil), s.Classes...)
+	return s
+}
+
+func (c *Config) SetSession(s SessionConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Detector.Session = s
+}
+
+func (c *Config) GetSync() SyncConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Sync
+}
+
+func (c *Config) SetSync(s SyncConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Sync = s
+}
+
+func (c *Config) GetTracking() TrackingConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Tracking
+}
+
+func (c *Config) SetTracking(t TrackingConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Tracking = t
+}
+
+func (c *Config) GetPostProcess() PostProcessConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	pp := c.PostProcess
+	pp.ClassMinScore = maps.Clone(pp.ClassMinScore)
+	pp.Allow = append([]string(nil), pp.Allow...)
+	pp.Deny = append([]string(nil), pp.Deny...)
+	return pp
+}
+
+func (c *Config) SetPostProcess(pp PostProcessConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.PostProcess = pp
+}
+
+func (c *Config) GetZones() ZonesConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	z := ZonesConfig{
+		Zones: make([]ZoneConfig, len(c.Zones.Zones)),
+		Lines: make([]LineConfig, len(c.Zones.Lines)),
+	}
+	for i, zone := range c.Zones.Zones {
+		zone.Polygon = append([]Point(nil), zone.Polygon...)
+		zone.Classes = append([]string(nil), zone.Classes...)
+		z.Zones[i] = zone
+	}
+	for i, line := range c.Zones.Lines {
+		line.Classes = append([]string(nil), line.Classes...)
+		z.Lines[i] = line
+	}
+	return z
+}
+
+func (c *Config) SetZones(z ZonesConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Zones = z
+}
+
+func (c *Config) GetAlerts() AlertsConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	a := c.Alerts
+	a.Rules = append([]RuleConfig(nil), a.Rules...)
+	return a
+}
+
+func (c *Config) SetAlerts(a AlertsConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Alerts = a
+}
+
+func (c *Config) GetWebhook() WebhookConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	w := c.Webhook
+	w.URLs = append([]string(nil), w.URLs...)
+	return w
+}
+
+func (c *Config) SetWebhook(w WebhookConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Webhook = w
+}
+
+func (c *Config) GetMQTT() MQTTConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.MQTT
+}
+
+func (c *Config) SetMQTT(m MQTTConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.MQTT = m
+}
+
+func (c *Config) GetDetectionLog() DetectionLogConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Log
+}
+
+func (c *Config) SetDetectionLog(l DetectionLogConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Log = l
+}
+
+func (c *Config) GetHistory() HistoryConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.History
+}
+
+func (c *Config) SetHistory(h HistoryConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.History = h
+}
+
+func (c *Config) GetSnapshot() SnapshotConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s := c.Snapshot
+	s.Classes = append([]string(nil), s.Classes...)
+	return s
+}
+
+func (c *Config) SetSnapshot(s SnapshotConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Snapshot = s
+}
+
+func (c *Config) GetRecorder() RecorderConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Recorder
+}
+
+func (c *Config) SetRecorder(r RecorderConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Recorder = r
+}
+
 func (c *Config) Save(path string) {
 	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
 
@@ -135,5 +665,106 @@ func NewDefaultConfig() *Config {
 		TargetFPS:    24,
 		ScaledWitdh:  640,
 		ScaledHeight: 640,
+		Detector: DetectorConfig{
+			Backend:   BackendRemote,
+			Transport: TransportWebSocket,
+			Endpoints: []string{DefaultDetectorProcessorUrl},
+			Balance:   BalanceRoundRobin,
+			Encoding: EncodingConfig{
+				Format:  EncodingJPEG,
+				Quality: 75,
+			},
+			Batch: BatchConfig{
+				Size:      1,
+				MaxWaitMs: 50,
+			},
+			Shm: ShmConfig{
+				Slots: 8,
+			},
+			MaxInFlight: 4,
+			Session: SessionConfig{
+				Confidence: 0.5,
+				IoU:        0.45,
+			},
+			Motion: MotionConfig{
+				Threshold:    25,
+				LearningRate: 0.05,
+				MinArea:      0.002,
+			},
+			Color: ColorConfig{
+				Ranges: []ColorRange{
+					{Name: "red", HueMin: 340, HueMax: 20, SatMin: 0.5, SatMax: 1, ValMin: 0.3, ValMax: 1},
+					{Name: "green", HueMin: 90, HueMax: 150, SatMin: 0.5, SatMax: 1, ValMin: 0.3, ValMax: 1},
+					{Name: "blue", HueMin: 200, HueMax: 260, SatMin: 0.5, SatMax: 1, ValMin: 0.3, ValMax: 1},
+				},
+				MinArea: 0.002,
+			},
+			Replay: ReplayConfig{
+				Mode: ReplayByHash,
+			},
+		},
+		Sync: SyncConfig{
+			Mode:       SyncLatest,
+			MaxDelayMs: 500,
+		},
+		Tracking: TrackingConfig{
+			Enabled: true,
+			MaxAge:  5,
+			MinHits: 3,
+			IoU:     0.3,
+		},
+		PostProcess: PostProcessConfig{
+			NMS:    NMSOff,
+			NMSIoU: 0.5,
+			Clip:   true,
+		},
+		Alerts: AlertsConfig{
+			History: 100,
+		},
+		Webhook: WebhookConfig{
+			Alerts:     true,
+			TimeoutMs:  5000,
+			MaxRetries: 3,
+			RetryMinMs: 500,
+			RetryMaxMs: 10000,
+			RatePerSec: 5,
+			QueueDir:   "webhook-queue",
+			QueueMax:   10000,
+		},
+		MQTT: MQTTConfig{
+			Broker:          "tcp://localhost:1883",
+			ClientID:        "vision",
+			QoS:             1,
+			TopicPrefix:     "vision",
+			Counts:          true,
+			Alerts:          true,
+			Discovery:       true,
+			DiscoveryPrefix: "homeassistant",
+		},
+		Log: DetectionLogConfig{
+			Dir:       "detections",
+			Format:    LogJSONL,
+			MaxSizeMB: 64,
+			MaxFiles:  10,
+		},
+		History: HistoryConfig{
+			Path:        "history.db",
+			MaxAgeHours: 24 * 7,
+			MaxSizeMB:   512,
+		},
+		Snapshot: SnapshotConfig{
+			Dir:        "snapshots",
+			MinScore:   0.5,
+			Full:       true,
+			CooldownMs: 10000,
+			Quality:    90,
+		},
+		Recorder: RecorderConfig{
+			Dir:            "recordings",
+			Container:      "mp4",
+			Codec:          "libx264",
+			Bitrate:        "4M",
+			SegmentMinutes: 10,
+		},
 	}
 }
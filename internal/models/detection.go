@@ -1,9 +1,13 @@
 package models
 
+// DetectionResult is one object found in a frame. Box is [y1, x1, y2, x2],
+// normalised to the frame size. TrackID identifies the same object across
+// frames once the tracker has confirmed it, and is 0 otherwise.
 type DetectionResult struct {
-	Class string    `json:"class"`
-	Score float32   `json:"score"`
-	Box   []float32 `json:"box"`
+	Class   string    `json:"class"`
+	Score   float32   `json:"score"`
+	Box     []float32 `json:"box"`
+	TrackID uint64    `json:"track_id,omitempty"`
 }
 
 type Box struct {
@@ -0,0 +1,114 @@
+package ui
+
+import (
+	"fmt"
+	"sync"
+
+	processing "vision/processing/detector"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// alertHistory lists the most recent alerts, newest first. Selecting one
+// shows the frame it fired on.
+type alertHistory struct {
+	box  *fyne.Container
+	list *widget.List
+
+	mu     sync.Mutex
+	alerts []processing.Alert
+}
+
+func (a *DetectApp) setupAlertHistory() {
+	h := &alertHistory{}
+
+	h.list = widget.NewList(
+		func() int {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return len(h.alerts)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.ListItemID, o fyne.CanvasObject) {
+			if alert, ok := h.get(id); ok {
+				o.(*widget.Label).SetText(formatAlert(alert))
+			}
+		},
+	)
+	h.list.OnSelected = func(id widget.ListItemID) {
+		if alert, ok := h.get(id); ok {
+			a.showAlert(alert)
+		}
+		h.list.UnselectAll()
+	}
+
+	clearButton := widget.NewButton("Clear", func() {
+		h.mu.Lock()
+		h.alerts = nil
+		h.mu.Unlock()
+		h.list.Refresh()
+	})
+
+	list := container.NewGridWrap(fyne.NewSize(280, 160), h.list)
+
+	h.box = container.NewVBox(
+		widget.NewLabelWithStyle("Alerts", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		list,
+		clearButton,
+		widget.NewSeparator(),
+	)
+
+	a.alerts = h
+}
+
+func (h *alertHistory) get(i int) (processing.Alert, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < 0 || i >= len(h.alerts) {
+		return processing.Alert{}, false
+	}
+	return h.alerts[i], true
+}
+
+func (h *alertHistory) add(alert processing.Alert, limit int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.alerts = append([]processing.Alert{alert}, h.alerts...)
+	if limit > 0 && len(h.alerts) > limit {
+		h.alerts = h.alerts[:limit]
+	}
+}
+
+// runAlertLoop records every alert fired by the processor in the history.
+func (a *DetectApp) runAlertLoop() {
+	for alert := range a.processor.Alerts() {
+		a.alerts.add(alert, a.config.GetAlerts().History)
+		fyne.Do(a.alerts.list.Refresh)
+	}
+}
+
+func (a *DetectApp) showAlert(alert processing.Alert) {
+	details := widget.NewLabel(fmt.Sprintf("%s\nFrame %d, %d detection(s)",
+		alert.At.Format("2006-01-02 15:04:05"), alert.FrameID, alert.Count))
+
+	content := container.NewBorder(details, nil, nil, nil)
+	if alert.Snapshot != nil {
+		img := canvas.NewImageFromImage(alert.Snapshot)
+		img.FillMode = canvas.ImageFillContain
+		img.SetMinSize(fyne.NewSize(480, 360))
+		content.Add(img)
+	}
+
+	dialog.ShowCustom(alert.Rule, "Close", content, a.mainWin)
+}
+
+func formatAlert(alert processing.Alert) string {
+	return fmt.Sprintf("%s  %s (%d)", alert.At.Format("15:04:05"), alert.Rule, alert.Count)
+}
@@ -1,21 +1,27 @@
 package ui
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
+	"strings"
 	"time"
 	"vision/internal/config"
 	"vision/internal/models"
 	"vision/internal/ui/cwidget"
+	"vision/processing/annotate"
 	"vision/processing/capture"
 	processing "vision/processing/detector"
+	"vision/processing/history"
+	"vision/processing/sink"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
@@ -29,12 +35,26 @@ type DetectApp struct {
 
 	dynamicSettings *fyne.Container
 	staticSettings  *fyne.Container
+	session         *sessionSettings
+	alerts          *alertHistory
+	history         *history.Store
+	recorder        *sink.Recorder
 
 	videoCanvas   *canvas.Image
 	rectContainer *fyne.Container
 
-	latencyLabel *widget.Label
-	fpsLabel     *widget.Label
+	// The frame on screen and its detections, only touched on the UI
+	// goroutine.
+	shownFrame      image.Image
+	shownDetections []models.DetectionResult
+
+	latencyLabel  *widget.Label
+	fpsLabel      *widget.Label
+	windowLabel   *widget.Label
+	detectorLabel *widget.Label
+	encodeLabel   *widget.Label
+	poolLabel     *widget.Label
+	errorLabel    *widget.Label
 }
 
 func CreateApp(p *processing.Processor, cfg *config.Config) *DetectApp {
@@ -69,16 +89,41 @@ func (a *DetectApp) Run() {
 
 	videoOverlay := container.NewStack(a.videoCanvas, a.rectContainer)
 
-	a.latencyLabel = widget.NewLabel(a.formatLatency(a.processor.Latency))
-	a.fpsLabel = widget.NewLabel(a.formatFPS(a.processor.FPS))
+	stats := a.processor.Stats()
+	a.latencyLabel = widget.NewLabel(a.formatLatency(stats.Latency))
+	a.fpsLabel = widget.NewLabel(a.formatFPS(stats.FPS))
+	a.windowLabel = widget.NewLabel(a.formatWindowStats(stats.Window))
+	a.detectorLabel = widget.NewLabel(a.formatDetectorStatus(a.processor.DetectorStatus()))
+
+	a.encodeLabel = widget.NewLabel("")
+	a.encodeLabel.Hidden = true
+
+	a.errorLabel = widget.NewLabel("")
+	a.errorLabel.Importance = widget.DangerImportance
+	a.errorLabel.Wrapping = fyne.TextWrapWord
+	a.errorLabel.Hidden = true
+
+	a.poolLabel = widget.NewLabel("")
+	a.poolLabel.TextStyle = fyne.TextStyle{Monospace: true}
+	a.poolLabel.Hidden = true
 
 	videoSection := container.NewBorder(
-		container.NewHBox(a.fpsLabel, widget.NewSeparator(), a.latencyLabel),
-		nil, nil, nil,
+		container.NewHBox(
+			a.fpsLabel, widget.NewSeparator(),
+			a.latencyLabel, widget.NewSeparator(),
+			a.windowLabel, widget.NewSeparator(),
+			a.detectorLabel, a.encodeLabel,
+			layout.NewSpacer(),
+			a.newRecordButton(),
+			widget.NewButtonWithIcon("Snapshot", theme.MediaPhotoIcon(), a.saveSnapshot),
+		),
+		container.NewVBox(a.errorLabel, a.poolLabel), nil, nil,
 		videoOverlay,
 	)
 
 	a.setupConfigSettings()
+	a.setupSessionSettings()
+	a.setupAlertHistory()
 
 	sidebar := container.NewVBox(
 		settingsLabel,
@@ -89,13 +134,18 @@ func (a *DetectApp) Run() {
 		a.dynamicSettings,
 		a.staticSettings,
 		widget.NewSeparator(),
+		a.session.box,
+		a.newPostProcessSettings(),
+		a.alerts.box,
+		a.newOutputSettings(),
+		a.newHistoryButton(),
 		widget.NewButtonWithIcon("Start Processing", theme.MediaPlayIcon(), func() {
 			a.StartProcessing(true)
 		}),
 	)
 
 	split := container.NewHSplit(
-		container.NewPadded(sidebar),
+		container.NewVScroll(container.NewPadded(sidebar)),
 		container.NewPadded(videoSection),
 	)
 	split.SetOffset(0.3)
@@ -105,60 +155,101 @@ func (a *DetectApp) Run() {
 	a.refreshSettingsUI(string(a.config.ActiveSource))
 
 	a.mainWin.SetOnClosed(func() {
+		a.StopProcessing()
 		a.config.SaveByDefault()
 	})
 
+	go a.runDetectorStatusLoop()
+	go a.runErrorLoop()
+	go a.runAlertLoop()
+
 	a.mainWin.CenterOnScreen()
 	a.mainWin.ShowAndRun()
 }
 
+// StopProcessing stops the processor together with its streamer. It is safe
+// to call when nothing is running.
 func (a *DetectApp) StopProcessing() {
-	if a.processor.IsActive {
-		a.processor.Stop()
-	}
-
-	if a.processor.IsStreamerActive {
-		a.processor.InImageStream.Stop()
-	}
+	a.processor.Stop()
 }
 
 func (a *DetectApp) StartProcessing(forceRestart bool) {
-	if a.processor.IsActive && !forceRestart {
+	if a.processor.IsRunning() && !forceRestart {
 		return
 	}
 
 	a.StopProcessing()
 
-	if err := a.restartStreamer(); err != nil {
+	streamer, err := capture.NewStreamer(a.config)
+	if err != nil {
 		dialog.ShowError(err, a.mainWin)
 		return
 	}
 
-	a.processor.Start()
+	if err := a.processor.Start(context.Background(), streamer); err != nil {
+		dialog.ShowError(err, a.mainWin)
+		return
+	}
 
-	go a.runPlayerLoop()
-	go a.runStatLoop()
+	done := a.processor.Done()
+	go a.runPlayerLoop(done)
+	go a.runStatLoop(done)
 }
 
-func (a *DetectApp) runStatLoop() {
+func (a *DetectApp) runStatLoop(done <-chan struct{}) {
 	uiTicker := time.NewTicker(time.Millisecond * 200)
-	currentStopChan := a.processor.StopChan
-
 	defer uiTicker.Stop()
 
 	for {
 		select {
 		case <-uiTicker.C:
+			stats := a.processor.Stats()
 			fyne.Do(func() {
-				a.latencyLabel.SetText(a.formatLatency(a.processor.Latency))
-				a.fpsLabel.SetText(a.formatFPS(a.processor.FPS))
+				a.latencyLabel.SetText(a.formatLatency(stats.Latency))
+				a.fpsLabel.SetText(a.formatFPS(stats.FPS))
+				a.windowLabel.SetText(a.formatWindowStats(stats.Window))
 			})
-		case <-currentStopChan:
+		case <-done:
 			return
 		}
 	}
 }
 
+// runDetectorStatusLoop lives for the whole app, since the detector connection
+// is kept regardless of whether processing is running.
+func (a *DetectApp) runDetectorStatusLoop() {
+	ticker := time.NewTicker(time.Millisecond * 500)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		status := a.processor.DetectorStatus()
+		stats := a.processor.DetectorStats()
+		enc, hasEnc := a.processor.EncodeStats()
+		caps, _ := a.processor.Capabilities()
+		fyne.Do(func() {
+			a.setCapabilities(caps)
+			a.setDetectorStatus(status)
+			a.setPoolStats(stats)
+			if hasEnc && enc.Frames > 0 {
+				a.encodeLabel.SetText(a.formatEncodeStats(enc))
+				a.encodeLabel.Show()
+			}
+		})
+	}
+}
+
+// runErrorLoop shows the most recent error reported by the processor, such as
+// a frame the detection server failed to process.
+func (a *DetectApp) runErrorLoop() {
+	for err := range a.processor.Errors() {
+		text := fmt.Sprintf("%s  %v", time.Now().Format("15:04:05"), err)
+		fyne.Do(func() {
+			a.errorLabel.SetText(text)
+			a.errorLabel.Show()
+		})
+	}
+}
+
 func (a *DetectApp) formatFPS(v uint64) string {
 	return fmt.Sprintf("FPS: %d", v)
 }
@@ -167,11 +258,63 @@ func (a *DetectApp) formatLatency(v time.Duration) string {
 	return fmt.Sprintf("Latency: %d ms", v.Milliseconds())
 }
 
-func (a *DetectApp) runPlayerLoop() {
-	currentStopChan := a.processor.StopChan
+func (a *DetectApp) formatWindowStats(s processing.WindowStats) string {
+	return fmt.Sprintf("In-flight: %d/%d  Sent: %d  Dropped: %d  Rate: %.1f/s",
+		s.InFlight, s.Window, s.Sent, s.Dropped+s.Expired, s.Rate)
+}
+
+func (a *DetectApp) formatDetectorStatus(s processing.ConnStatus) string {
+	if s.State != processing.StateConnected && s.LastError != nil {
+		return fmt.Sprintf("Detector: %s (%v)", s.State, s.LastError)
+	}
+	return fmt.Sprintf("Detector: %s", s.State)
+}
+
+func (a *DetectApp) setDetectorStatus(s processing.ConnStatus) {
+	switch s.State {
+	case processing.StateConnected:
+		a.detectorLabel.Importance = widget.SuccessImportance
+	case processing.StateDegraded, processing.StateConnecting:
+		a.detectorLabel.Importance = widget.WarningImportance
+	default:
+		a.detectorLabel.Importance = widget.DangerImportance
+	}
+	a.detectorLabel.SetText(a.formatDetectorStatus(s))
+}
+
+func (a *DetectApp) formatEncodeStats(s processing.EncodeStats) string {
+	return fmt.Sprintf("Upload: %s %.1f KB/frame, encode %.1f ms",
+		s.Encoding, float64(s.AvgBytes)/1024, float64(s.AvgEncode.Microseconds())/1000)
+}
+
+func (a *DetectApp) setPoolStats(stats []processing.EndpointStats) {
+	if len(stats) == 0 {
+		a.poolLabel.Hide()
+		return
+	}
+
+	lines := make([]string, len(stats))
+	for i, s := range stats {
+		health := "ejected"
+		if s.Healthy {
+			health = "healthy"
+		}
+		lines[i] = fmt.Sprintf("%s  %-12s %-7s in-flight %d  sent %d  recv %d  lost %d  %d ms  %s %.1f KB",
+			s.URL, s.State, health, s.InFlight, s.Sent, s.Received, s.Lost, s.AvgLatency.Milliseconds(),
+			s.Encode.Encoding, float64(s.Encode.AvgBytes)/1024)
+	}
 
-	frameChan := a.processor.OutImageStream
-	detectionsChan := a.processor.OutDetectionsStream
+	a.poolLabel.SetText(strings.Join(lines, "\n"))
+	a.poolLabel.Show()
+}
+
+// runPlayerLoop shows the newest frame on every display tick. In synced mode
+// that is the newest frame paired with its own detections, otherwise the
+// newest captured frame with the newest detections.
+func (a *DetectApp) runPlayerLoop(done <-chan struct{}) {
+	frameChan := a.processor.Frames()
+	detectionsChan := a.processor.Detections()
+	syncedChan := a.processor.Synced()
 
 	displayFPS := time.Duration(a.config.TargetFPS)
 	displayTicker := time.NewTicker(time.Second / displayFPS)
@@ -179,6 +322,7 @@ func (a *DetectApp) runPlayerLoop() {
 
 	var lastFrame image.Image
 	var lastDetections []models.DetectionResult
+	var lastSynced processing.AnnotatedFrame
 
 	for {
 		select {
@@ -197,16 +341,26 @@ func (a *DetectApp) runPlayerLoop() {
 				lastDetections = detections
 			}
 
+		case af := <-syncedChan:
+			lastSynced = af
+
 		case <-displayTicker.C:
-			if lastFrame != nil {
+			frame, detections := lastFrame, lastDetections
+			if a.config.GetSync().Mode == config.SyncExact {
+				frame, detections = lastSynced.Frame, lastSynced.Detections
+			}
+
+			if frame != nil {
 				fyne.Do(func() {
-					a.videoCanvas.Image = lastFrame
+					a.shownFrame, a.shownDetections = frame, detections
+					a.videoCanvas.Image = frame
 					a.videoCanvas.Refresh()
-					a.updateRectangles(lastFrame.Bounds(), lastDetections)
+					a.updateRectangles(frame.Bounds(), detections)
+					a.drawZones(frame.Bounds(), a.processor.Counts())
 				})
 			}
 
-		case <-currentStopChan:
+		case <-done:
 			return
 		}
 	}
@@ -220,22 +374,15 @@ func (a *DetectApp) updateRectangles(imgBounds image.Rectangle, detections []mod
 		return
 	}
 
-	canvasSize := a.videoCanvas.Size()
-
-	scaleX := float32(canvasSize.Width) / float32(imgBounds.Dx())
-	scaleY := float32(canvasSize.Height) / float32(imgBounds.Dy())
-
-	scale := scaleX
-	if scaleY < scaleX {
-		scale = scaleY
-	}
-
-	offsetX := (canvasSize.Width - float32(imgBounds.Dx())*scale) / 2
-	offsetY := (canvasSize.Height - float32(imgBounds.Dy())*scale) / 2
+	scale, offsetX, offsetY := a.frameTransform(imgBounds)
 
 	greenColor := color.RGBA{0, 255, 0, 255}
 
 	for _, det := range detections {
+		if len(det.Box) < 4 {
+			continue
+		}
+
 		y1 := det.Box[0]
 		x1 := det.Box[1]
 		y2 := det.Box[2]
@@ -252,7 +399,7 @@ func (a *DetectApp) updateRectangles(imgBounds image.Rectangle, detections []mod
 		rect.Resize(fyne.NewSize(rectW, rectH))
 		rect.Move(fyne.NewPos(rectX, rectY))
 
-		txt := canvas.NewText(fmt.Sprintf("%s %.0f%%", det.Class, det.Score*100), greenColor)
+		txt := canvas.NewText(formatDetectionLabel(det), greenColor)
 		txt.TextSize = 12
 		txt.TextStyle = fyne.TextStyle{Bold: true}
 		txt.Move(fyne.NewPos(rectX, rectY-15))
@@ -264,19 +411,26 @@ func (a *DetectApp) updateRectangles(imgBounds image.Rectangle, detections []mod
 	a.rectContainer.Refresh()
 }
 
-func (a *DetectApp) restartStreamer() error {
-	if a.processor.InImageStream != nil {
-		a.processor.InImageStream.Stop()
-	}
+// frameTransform returns how a frame of the given size is scaled and offset
+// to fit the video canvas.
+func (a *DetectApp) frameTransform(imgBounds image.Rectangle) (scale, offsetX, offsetY float32) {
+	canvasSize := a.videoCanvas.Size()
 
-	streamer, err := capture.NewStreamer(a.config)
+	scaleX := float32(canvasSize.Width) / float32(imgBounds.Dx())
+	scaleY := float32(canvasSize.Height) / float32(imgBounds.Dy())
 
-	if err != nil {
-		return err
+	scale = scaleX
+	if scaleY < scaleX {
+		scale = scaleY
 	}
 
-	a.processor.InImageStream = streamer
-	return a.processor.InImageStream.Start()
+	offsetX = (canvasSize.Width - float32(imgBounds.Dx())*scale) / 2
+	offsetY = (canvasSize.Height - float32(imgBounds.Dy())*scale) / 2
+	return scale, offsetX, offsetY
+}
+
+func formatDetectionLabel(det models.DetectionResult) string {
+	return annotate.Label(det)
 }
 
 func (a *DetectApp) setupConfigSettings() {
@@ -287,9 +441,33 @@ func (a *DetectApp) setupConfigSettings() {
 	widthInput := cwidget.NewIntInput("Width", "Int", a.config.ScaledWitdh, func(i int) { a.config.SetWidth(i) })
 	heightInput := cwidget.NewIntInput("Height", "Int", a.config.ScaledHeight, func(i int) { a.config.SetHeight(i) })
 
+	syncSelect := widget.NewSelect([]string{string(config.SyncLatest), string(config.SyncExact)}, func(s string) {
+		sc := a.config.GetSync()
+		sc.Mode = config.SyncMode(s)
+		a.config.SetSync(sc)
+	})
+	syncSelect.SetSelected(string(a.config.GetSync().Mode))
+
+	maxDelayInput := cwidget.NewIntInput("Max sync delay (ms)", "Int", a.config.GetSync().MaxDelayMs, func(i int) {
+		sc := a.config.GetSync()
+		sc.MaxDelayMs = i
+		a.config.SetSync(sc)
+	})
+
 	a.staticSettings.Add(fpsInput)
 	a.staticSettings.Add(widthInput)
 	a.staticSettings.Add(heightInput)
+	trackCheck := widget.NewCheck("Track objects", func(on bool) {
+		tc := a.config.GetTracking()
+		tc.Enabled = on
+		a.config.SetTracking(tc)
+	})
+	trackCheck.SetChecked(a.config.GetTracking().Enabled)
+
+	a.staticSettings.Add(widget.NewLabel("Overlay:"))
+	a.staticSettings.Add(syncSelect)
+	a.staticSettings.Add(maxDelayInput)
+	a.staticSettings.Add(trackCheck)
 	a.staticSettings.Add(widget.NewButton("Save config", func() { a.StartProcessing(true) }))
 }
 
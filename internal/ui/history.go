@@ -0,0 +1,235 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"vision/processing/history"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	historyAllClasses = "All classes"
+	historyQueryLimit = 1000
+)
+
+var historyRanges = []struct {
+	name string
+	span time.Duration
+}{
+	{"Last hour", time.Hour},
+	{"Last 24 hours", 24 * time.Hour},
+	{"Last 7 days", 7 * 24 * time.Hour},
+	{"Everything", 0},
+}
+
+var historyViews = []string{"Detections", "Tracks", "Alerts"}
+
+// SetHistory makes the detection history browsable from the UI. It must be
+// called before Run.
+func (a *DetectApp) SetHistory(s *history.Store) {
+	a.history = s
+}
+
+// newHistoryButton returns the button opening the history window, hidden
+// when no history is kept.
+func (a *DetectApp) newHistoryButton() fyne.CanvasObject {
+	button := widget.NewButtonWithIcon("History…", theme.HistoryIcon(), a.showHistory)
+	if a.history == nil {
+		button.Hide()
+	}
+	return button
+}
+
+// showHistory opens a window for searching the detection history.
+func (a *DetectApp) showHistory() {
+	w := a.fyneApp.NewWindow("Detection history")
+	w.Resize(fyne.NewSize(800, 600))
+
+	var rows []string
+	list := widget.NewList(
+		func() int { return len(rows) },
+		func() fyne.CanvasObject {
+			l := widget.NewLabel("")
+			l.TextStyle = fyne.TextStyle{Monospace: true}
+			return l
+		},
+		func(id widget.ListItemID, o fyne.CanvasObject) {
+			o.(*widget.Label).SetText(rows[id])
+		},
+	)
+
+	summary := widget.NewLabel("")
+
+	view := widget.NewSelect(historyViews, nil)
+	view.SetSelected(historyViews[0])
+
+	class := widget.NewSelect([]string{historyAllClasses}, nil)
+	class.SetSelected(historyAllClasses)
+
+	source := widget.NewEntry()
+	source.SetPlaceHolder("any source")
+
+	rangeNames := make([]string, len(historyRanges))
+	for i, r := range historyRanges {
+		rangeNames[i] = r.name
+	}
+	span := widget.NewSelect(rangeNames, nil)
+	span.SetSelected(rangeNames[1])
+
+	minScore := widget.NewEntry()
+	minScore.SetPlaceHolder("0.0")
+
+	search := func() {
+		f := history.Filter{Source: strings.TrimSpace(source.Text), Limit: historyQueryLimit}
+		if class.Selected != historyAllClasses {
+			f.Class = class.Selected
+		}
+		for _, r := range historyRanges {
+			if r.name == span.Selected && r.span > 0 {
+				f.From = time.Now().Add(-r.span)
+			}
+		}
+		score, err := parseScore(minScore.Text)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		f.MinScore = score
+
+		go func() {
+			result, text, err := a.queryHistory(view.Selected, f)
+			fyne.Do(func() {
+				if err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				rows = result
+				summary.SetText(text)
+				list.Refresh()
+			})
+		}()
+	}
+
+	searchButton := widget.NewButton("Search", search)
+	searchButton.Importance = widget.HighImportance
+
+	filters := container.NewVBox(
+		container.NewGridWithColumns(3,
+			container.NewBorder(nil, nil, widget.NewLabel("Show:"), nil, view),
+			container.NewBorder(nil, nil, widget.NewLabel("Class:"), nil, class),
+			container.NewBorder(nil, nil, widget.NewLabel("Period:"), nil, span),
+		),
+		container.NewGridWithColumns(3,
+			container.NewBorder(nil, nil, widget.NewLabel("Source:"), nil, source),
+			container.NewBorder(nil, nil, widget.NewLabel("Min score:"), nil, minScore),
+			searchButton,
+		),
+		summary,
+		widget.NewSeparator(),
+	)
+
+	w.SetContent(container.NewBorder(filters, nil, nil, nil, list))
+	w.Show()
+
+	go func() {
+		classes, err := a.history.Classes(context.Background())
+		if err != nil {
+			return
+		}
+		fyne.Do(func() {
+			class.Options = append([]string{historyAllClasses}, classes...)
+			class.Refresh()
+		})
+	}()
+
+	search()
+}
+
+// queryHistory runs a search and returns the formatted rows together with a
+// one-line summary.
+func (a *DetectApp) queryHistory(view string, f history.Filter) ([]string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var rows []string
+
+	switch view {
+	case "Tracks":
+		tracks, err := a.history.Tracks(ctx, f)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, t := range tracks {
+			rows = append(rows, fmt.Sprintf("%s  %-8s #%-5d %-12s %4.0f%%  %s  %d frames",
+				t.LastSeen.Format("2006-01-02 15:04:05"), t.LastSeen.Sub(t.FirstSeen).Round(time.Second),
+				t.ID, t.Class, t.MaxScore*100, t.Source, t.Frames))
+		}
+
+	case "Alerts":
+		// Alerts are filed by rule, not by class.
+		af := f
+		af.Class = ""
+		alerts, err := a.history.Alerts(ctx, af)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, al := range alerts {
+			rows = append(rows, fmt.Sprintf("%s  %-20s %3d  %s",
+				al.At.Format("2006-01-02 15:04:05"), al.Rule, al.Count, al.Source))
+		}
+
+	default:
+		dets, err := a.history.Detections(ctx, f)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, d := range dets {
+			track := ""
+			if d.TrackID != 0 {
+				track = fmt.Sprintf("#%d", d.TrackID)
+			}
+			rows = append(rows, fmt.Sprintf("%s  %-6s %-12s %4.0f%%  %s",
+				d.At.Format("2006-01-02 15:04:05.000"), track, d.Class, d.Score*100, d.Source))
+		}
+	}
+
+	text := fmt.Sprintf("%d result(s)", len(rows))
+	if len(rows) == f.Limit {
+		text = fmt.Sprintf("Newest %d results", len(rows))
+	}
+
+	if f.Class != "" {
+		last, ok, err := a.history.LastSeen(ctx, history.Filter{Class: f.Class, Source: f.Source})
+		if err != nil {
+			return nil, "", err
+		}
+		if ok {
+			text += fmt.Sprintf(" — %s last seen %s", f.Class, last.Format("2006-01-02 15:04:05"))
+		} else {
+			text += fmt.Sprintf(" — %s never seen", f.Class)
+		}
+	}
+
+	return rows, text, nil
+}
+
+func parseScore(s string) (float32, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	var v float32
+	if _, err := fmt.Sscan(s, &v); err != nil || v < 0 || v > 1 {
+		return 0, fmt.Errorf("min score must be between 0 and 1")
+	}
+	return v, nil
+}
@@ -0,0 +1,107 @@
+package ui
+
+import (
+	"time"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+	"vision/processing/sink"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// newOutputSettings builds the sidebar section for what is written to disk.
+// Changes take effect with the next frame.
+func (a *DetectApp) newOutputSettings() *fyne.Container {
+	current := a.config.GetDetectionLog()
+
+	logDir := widget.NewEntry()
+	logDir.SetText(current.Dir)
+	logDir.OnSubmitted = func(s string) {
+		a.applyDetectionLog(func(l *config.DetectionLogConfig) { l.Dir = s })
+	}
+
+	logFormat := widget.NewSelect([]string{string(config.LogJSONL), string(config.LogCSV)}, func(s string) {
+		a.applyDetectionLog(func(l *config.DetectionLogConfig) { l.Format = config.LogFormat(s) })
+	})
+	logFormat.SetSelected(string(current.Format))
+
+	logEnabled := widget.NewCheck("Log detections", func(on bool) {
+		a.applyDetectionLog(func(l *config.DetectionLogConfig) { l.Enabled = on })
+	})
+	logEnabled.SetChecked(current.Enabled)
+
+	snap := a.config.GetSnapshot()
+
+	snapClasses := a.newClassListEntry("any class", snap.Classes, func(classes []string) {
+		a.applySnapshot(func(s *config.SnapshotConfig) { s.Classes = classes })
+	})
+
+	snapFull := widget.NewCheck("Full frame", func(on bool) {
+		a.applySnapshot(func(s *config.SnapshotConfig) { s.Full = on })
+	})
+	snapFull.SetChecked(snap.Full)
+
+	snapCrops := widget.NewCheck("Object crops", func(on bool) {
+		a.applySnapshot(func(s *config.SnapshotConfig) { s.Crops = on })
+	})
+	snapCrops.SetChecked(snap.Crops)
+
+	snapEnabled := widget.NewCheck("Save snapshots on detection", func(on bool) {
+		a.applySnapshot(func(s *config.SnapshotConfig) { s.Enabled = on })
+	})
+	snapEnabled.SetChecked(snap.Enabled)
+
+	return container.NewVBox(
+		widget.NewLabelWithStyle("Outputs", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		logEnabled,
+		container.NewBorder(nil, nil, widget.NewLabel("Format:"), nil, logFormat),
+		container.NewBorder(nil, nil, widget.NewLabel("Directory:"), nil, logDir),
+		snapEnabled,
+		container.NewBorder(nil, nil, widget.NewLabel("Classes:"), nil, snapClasses),
+		container.NewHBox(snapFull, snapCrops),
+		a.newRecorderSettings(),
+		widget.NewSeparator(),
+	)
+}
+
+func (a *DetectApp) applySnapshot(update func(*config.SnapshotConfig)) {
+	s := a.config.GetSnapshot()
+	update(&s)
+	a.config.SetSnapshot(s)
+}
+
+// saveSnapshot saves the frame on screen with its boxes drawn in.
+func (a *DetectApp) saveSnapshot() {
+	if a.shownFrame == nil {
+		dialog.ShowInformation("Snapshot", "There is no frame to save yet.", a.mainWin)
+		return
+	}
+
+	r := processing.FrameResult{
+		At:         time.Now(),
+		Source:     a.config.SourceName(),
+		Frame:      a.shownFrame,
+		Detections: a.shownDetections,
+	}
+
+	go func() {
+		path, err := sink.SaveAnnotated(a.config.GetSnapshot(), r)
+		fyne.Do(func() {
+			if err != nil {
+				dialog.ShowError(err, a.mainWin)
+				return
+			}
+			dialog.ShowInformation("Snapshot", "Saved "+path, a.mainWin)
+		})
+	}()
+}
+
+func (a *DetectApp) applyDetectionLog(update func(*config.DetectionLogConfig)) {
+	l := a.config.GetDetectionLog()
+	update(&l)
+	a.config.SetDetectionLog(l)
+}
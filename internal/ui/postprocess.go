@@ -0,0 +1,177 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"vision/internal/config"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+var nmsModes = map[string]config.NMSMode{
+	"Off":       config.NMSOff,
+	"Per class": config.NMSPerClass,
+	"All boxes": config.NMSAgnostic,
+}
+
+// newPostProcessSettings builds the sidebar section for the post-processing
+// chain. Changes are picked up by the processor with the next results.
+func (a *DetectApp) newPostProcessSettings() *fyne.Container {
+	current := a.config.GetPostProcess()
+
+	minScore := a.newThresholdSlider("Min score", current.MinScore, func(v float32) {
+		a.applyPostProcess(func(pp *config.PostProcessConfig) { pp.MinScore = v })
+	})
+
+	classScores := widget.NewEntry()
+	classScores.SetPlaceHolder("person=0.6, car=0.4")
+	classScores.SetText(formatClassScores(current.ClassMinScore))
+	classScores.Validator = func(s string) error {
+		_, err := parseClassScores(s)
+		return err
+	}
+	classScores.OnSubmitted = func(s string) {
+		if scores, err := parseClassScores(s); err == nil {
+			a.applyPostProcess(func(pp *config.PostProcessConfig) { pp.ClassMinScore = scores })
+		}
+	}
+
+	allow := a.newClassListEntry("only these classes", current.Allow, func(classes []string) {
+		a.applyPostProcess(func(pp *config.PostProcessConfig) { pp.Allow = classes })
+	})
+	deny := a.newClassListEntry("never these classes", current.Deny, func(classes []string) {
+		a.applyPostProcess(func(pp *config.PostProcessConfig) { pp.Deny = classes })
+	})
+
+	nmsNames := []string{"Off", "Per class", "All boxes"}
+	nmsSelect := widget.NewSelect(nmsNames, func(s string) {
+		a.applyPostProcess(func(pp *config.PostProcessConfig) { pp.NMS = nmsModes[s] })
+	})
+	nmsSelect.SetSelected(nmsNames[0])
+	for name, mode := range nmsModes {
+		if mode == current.NMS {
+			nmsSelect.SetSelected(name)
+		}
+	}
+
+	nmsIoU := a.newThresholdSlider("NMS IoU", current.NMSIoU, func(v float32) {
+		a.applyPostProcess(func(pp *config.PostProcessConfig) { pp.NMSIoU = v })
+	})
+
+	minArea := a.newAreaSlider("Min area", current.MinArea, func(v float32) {
+		a.applyPostProcess(func(pp *config.PostProcessConfig) { pp.MinArea = v })
+	})
+	maxArea := a.newAreaSlider("Max area", current.MaxArea, func(v float32) {
+		a.applyPostProcess(func(pp *config.PostProcessConfig) { pp.MaxArea = v })
+	})
+
+	clip := widget.NewCheck("Clip boxes to frame", func(on bool) {
+		a.applyPostProcess(func(pp *config.PostProcessConfig) { pp.Clip = on })
+	})
+	clip.SetChecked(current.Clip)
+
+	return container.NewVBox(
+		widget.NewLabelWithStyle("Post-processing", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		minScore,
+		widget.NewLabel("Per-class min score:"),
+		classScores,
+		widget.NewLabel("Allow:"),
+		allow,
+		widget.NewLabel("Deny:"),
+		deny,
+		widget.NewLabel("NMS:"),
+		nmsSelect,
+		nmsIoU,
+		minArea,
+		maxArea,
+		clip,
+		widget.NewSeparator(),
+	)
+}
+
+func (a *DetectApp) applyPostProcess(update func(*config.PostProcessConfig)) {
+	pp := a.config.GetPostProcess()
+	update(&pp)
+	a.config.SetPostProcess(pp)
+}
+
+func (a *DetectApp) newClassListEntry(placeholder string, classes []string, onSubmitted func([]string)) *widget.Entry {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder(placeholder)
+	entry.SetText(strings.Join(classes, ", "))
+	entry.OnSubmitted = func(s string) {
+		onSubmitted(splitClasses(s))
+	}
+	return entry
+}
+
+// newAreaSlider edits a fraction of the frame area, shown as a percentage.
+// Zero turns the limit off.
+func (a *DetectApp) newAreaSlider(name string, value float32, onChanged func(float32)) fyne.CanvasObject {
+	format := func(v float64) string {
+		if v == 0 {
+			return fmt.Sprintf("%s: off", name)
+		}
+		return fmt.Sprintf("%s: %.1f%%", name, v)
+	}
+
+	label := widget.NewLabel(format(float64(value) * 100))
+
+	slider := widget.NewSlider(0, 100)
+	slider.Step = 0.5
+	slider.SetValue(float64(value) * 100)
+	slider.OnChanged = func(v float64) {
+		label.SetText(format(v))
+	}
+	slider.OnChangeEnded = func(v float64) {
+		onChanged(float32(v / 100))
+	}
+
+	return container.NewVBox(label, slider)
+}
+
+func splitClasses(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func parseClassScores(s string) (map[string]float32, error) {
+	scores := make(map[string]float32)
+	for _, part := range splitClasses(s) {
+		class, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected class=score, got %q", part)
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 32)
+		if err != nil || v < 0 || v > 1 {
+			return nil, fmt.Errorf("score for %s must be between 0 and 1", strings.TrimSpace(class))
+		}
+		scores[strings.TrimSpace(class)] = float32(v)
+	}
+	return scores, nil
+}
+
+func formatClassScores(scores map[string]float32) string {
+	classes := make([]string, 0, len(scores))
+	for c := range scores {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+
+	parts := make([]string, len(classes))
+	for i, c := range classes {
+		parts[i] = fmt.Sprintf("%s=%.2f", c, scores[c])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,147 @@
+package ui
+
+import (
+	"fmt"
+	"slices"
+
+	"vision/internal/config"
+	"vision/processing/sink"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+var recordingSegments = []int{0, 5, 10, 30, 60}
+
+// SetRecorder makes video recording available from the UI. It must be
+// called before Run.
+func (a *DetectApp) SetRecorder(r *sink.Recorder) {
+	a.recorder = r
+}
+
+// newRecordButton returns the button starting and stopping a recording,
+// hidden when there is no recorder.
+func (a *DetectApp) newRecordButton() *widget.Button {
+	button := widget.NewButtonWithIcon("Record", theme.MediaRecordIcon(), nil)
+	if a.recorder == nil {
+		button.Hide()
+		return button
+	}
+
+	button.OnTapped = func() {
+		if a.recorder.Recording() {
+			// Stopping waits for FFmpeg to finish the file, which can take a
+			// while, so it mustn't block the UI.
+			button.Disable()
+			button.SetText("Stopping…")
+			go func() {
+				a.recorder.Stop()
+				fyne.Do(func() {
+					setRecordButton(button, false)
+					button.Enable()
+				})
+			}()
+			return
+		}
+		if err := a.recorder.Start(); err != nil {
+			dialog.ShowError(err, a.mainWin)
+			return
+		}
+		setRecordButton(button, true)
+	}
+
+	go func() {
+		for err := range a.recorder.Errors() {
+			fyne.Do(func() {
+				setRecordButton(button, a.recorder.Recording())
+				dialog.ShowError(fmt.Errorf("recording stopped: %w", err), a.mainWin)
+			})
+		}
+	}()
+
+	return button
+}
+
+func setRecordButton(button *widget.Button, recording bool) {
+	if recording {
+		button.SetText("Stop recording")
+		button.SetIcon(theme.MediaStopIcon())
+		button.Importance = widget.DangerImportance
+	} else {
+		button.SetText("Record")
+		button.SetIcon(theme.MediaRecordIcon())
+		button.Importance = widget.MediumImportance
+	}
+	button.Refresh()
+}
+
+// newRecorderSettings builds the recording options of the Outputs section.
+// They apply to the next recording.
+func (a *DetectApp) newRecorderSettings() fyne.CanvasObject {
+	if a.recorder == nil {
+		return container.NewVBox()
+	}
+
+	current := a.config.GetRecorder()
+
+	format := widget.NewSelect([]string{"mp4", "mkv"}, func(s string) {
+		a.applyRecorder(func(r *config.RecorderConfig) { r.Container = s })
+	})
+	format.SetSelected(current.Container)
+
+	codec := widget.NewSelectEntry([]string{"libx264", "libx265", "libvpx-vp9", "h264_nvenc"})
+	codec.SetText(current.Codec)
+	codec.OnChanged = func(s string) {
+		a.applyRecorder(func(r *config.RecorderConfig) { r.Codec = s })
+	}
+
+	bitrate := widget.NewEntry()
+	bitrate.SetPlaceHolder("codec default")
+	bitrate.SetText(current.Bitrate)
+	bitrate.OnChanged = func(s string) {
+		a.applyRecorder(func(r *config.RecorderConfig) { r.Bitrate = s })
+	}
+
+	segmentNames := make([]string, len(recordingSegments))
+	for i, m := range recordingSegments {
+		segmentNames[i] = formatSegment(m)
+	}
+	segment := widget.NewSelect(segmentNames, func(s string) {
+		i := slices.Index(segmentNames, s)
+		a.applyRecorder(func(r *config.RecorderConfig) { r.SegmentMinutes = recordingSegments[i] })
+	})
+	segment.SetSelected(formatSegment(current.SegmentMinutes))
+
+	dir := widget.NewEntry()
+	dir.SetText(current.Dir)
+	dir.OnSubmitted = func(s string) {
+		a.applyRecorder(func(r *config.RecorderConfig) { r.Dir = s })
+	}
+
+	return container.NewVBox(
+		widget.NewLabel("Recording:"),
+		container.NewGridWithColumns(2,
+			container.NewBorder(nil, nil, widget.NewLabel("Format:"), nil, format),
+			container.NewBorder(nil, nil, widget.NewLabel("New file:"), nil, segment),
+		),
+		container.NewBorder(nil, nil, widget.NewLabel("Codec:"), nil, codec),
+		container.NewBorder(nil, nil, widget.NewLabel("Bitrate:"), nil, bitrate),
+		container.NewBorder(nil, nil, widget.NewLabel("Directory:"), nil, dir),
+	)
+}
+
+func formatSegment(minutes int) string {
+	if minutes <= 0 {
+		return "Never"
+	}
+	return fmt.Sprintf("Every %d min", minutes)
+}
+
+func (a *DetectApp) applyRecorder(update func(*config.RecorderConfig)) {
+	r := a.config.GetRecorder()
+	update(&r)
+	a.config.SetRecorder(r)
+}
@@ -0,0 +1,129 @@
+package ui
+
+import (
+	"fmt"
+	"slices"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const serverDefaultModel = "Server default"
+
+type sessionSettings struct {
+	box *fyne.Container
+
+	modelSelect  *widget.Select
+	classesGroup *widget.CheckGroup
+
+	caps processing.Capabilities
+}
+
+// setupSessionSettings builds the sidebar section for the detection server
+// session. It stays empty for in-process detectors.
+func (a *DetectApp) setupSessionSettings() {
+	a.session = &sessionSettings{box: container.NewVBox()}
+
+	if _, ok := a.processor.Capabilities(); !ok {
+		return
+	}
+
+	current := a.config.GetSession()
+
+	a.session.modelSelect = widget.NewSelect([]string{serverDefaultModel}, func(s string) {
+		if s == serverDefaultModel {
+			s = ""
+		}
+		a.applySession(func(sc *config.SessionConfig) { sc.Model = s })
+	})
+	if current.Model != "" {
+		a.session.modelSelect.Options = append(a.session.modelSelect.Options, current.Model)
+		a.session.modelSelect.SetSelected(current.Model)
+	} else {
+		a.session.modelSelect.SetSelected(serverDefaultModel)
+	}
+
+	a.session.classesGroup = widget.NewCheckGroup(current.Classes, func(selected []string) {
+		a.applySession(func(sc *config.SessionConfig) { sc.Classes = selected })
+	})
+	a.session.classesGroup.Selected = current.Classes
+
+	classesScroll := container.NewVScroll(a.session.classesGroup)
+	classesScroll.SetMinSize(fyne.NewSize(0, 150))
+
+	confidence := a.newThresholdSlider("Confidence", current.Confidence, func(v float32) {
+		a.applySession(func(sc *config.SessionConfig) { sc.Confidence = v })
+	})
+	iou := a.newThresholdSlider("IoU", current.IoU, func(v float32) {
+		a.applySession(func(sc *config.SessionConfig) { sc.IoU = v })
+	})
+
+	a.session.box.Add(widget.NewLabelWithStyle("Detector", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	a.session.box.Add(widget.NewLabel("Model:"))
+	a.session.box.Add(a.session.modelSelect)
+	a.session.box.Add(confidence)
+	a.session.box.Add(iou)
+	a.session.box.Add(widget.NewLabel("Classes (none selected = all):"))
+	a.session.box.Add(classesScroll)
+	a.session.box.Add(widget.NewSeparator())
+}
+
+func (a *DetectApp) newThresholdSlider(name string, value float32, onChanged func(float32)) fyne.CanvasObject {
+	label := widget.NewLabel(fmt.Sprintf("%s: %.2f", name, value))
+
+	slider := widget.NewSlider(0, 1)
+	slider.Step = 0.05
+	slider.SetValue(float64(value))
+	slider.OnChanged = func(v float64) {
+		label.SetText(fmt.Sprintf("%s: %.2f", name, v))
+	}
+	slider.OnChangeEnded = func(v float64) {
+		onChanged(float32(v))
+	}
+
+	return container.NewVBox(label, slider)
+}
+
+func (a *DetectApp) applySession(update func(*config.SessionConfig)) {
+	s := a.config.GetSession()
+	update(&s)
+
+	a.config.SetSession(s)
+	a.processor.UpdateSession(s)
+}
+
+// setCapabilities refreshes the model and class choices after the server has
+// reported what it can run.
+func (a *DetectApp) setCapabilities(caps processing.Capabilities) {
+	if a.session.modelSelect == nil {
+		return
+	}
+
+	if slices.Equal(caps.Models, a.session.caps.Models) && slices.Equal(caps.Classes, a.session.caps.Classes) {
+		return
+	}
+	a.session.caps = caps
+
+	current := a.config.GetSession()
+
+	models := append([]string{serverDefaultModel}, caps.Models...)
+	if current.Model != "" && !slices.Contains(models, current.Model) {
+		models = append(models, current.Model)
+	}
+	a.session.modelSelect.Options = models
+	a.session.modelSelect.Refresh()
+
+	var selected []string
+	for _, c := range current.Classes {
+		if slices.Contains(caps.Classes, c) {
+			selected = append(selected, c)
+		}
+	}
+	a.session.classesGroup.Options = caps.Classes
+	a.session.classesGroup.Selected = selected
+	a.session.classesGroup.Refresh()
+}
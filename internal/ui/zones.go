@@ -0,0 +1,90 @@
+package ui
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+)
+
+var (
+	zoneColor = color.RGBA{255, 200, 0, 255}
+	lineColor = color.RGBA{0, 200, 255, 255}
+)
+
+// drawZones adds the configured zones and counting lines, labelled with their
+// counts, to the overlay. It must run after updateRectangles, which clears it.
+func (a *DetectApp) drawZones(imgBounds image.Rectangle, counts processing.ZoneCounts) {
+	zones := a.config.GetZones()
+	if len(zones.Zones) == 0 && len(zones.Lines) == 0 {
+		return
+	}
+
+	scale, offsetX, offsetY := a.frameTransform(imgBounds)
+	toCanvas := func(p config.Point) fyne.Position {
+		return fyne.NewPos(
+			p.X*float32(imgBounds.Dx())*scale+offsetX,
+			p.Y*float32(imgBounds.Dy())*scale+offsetY,
+		)
+	}
+
+	for _, z := range zones.Zones {
+		if len(z.Polygon) < 3 {
+			continue
+		}
+
+		for i := range z.Polygon {
+			next := z.Polygon[(i+1)%len(z.Polygon)]
+			a.addLine(toCanvas(z.Polygon[i]), toCanvas(next), zoneColor, 2)
+		}
+
+		label := z.Name
+		if c, ok := counts.Zone(z.Name); ok {
+			label = fmt.Sprintf("%s: %d", z.Name, c.Occupancy)
+		}
+		a.addLabel(label, toCanvas(z.Polygon[0]), zoneColor)
+	}
+
+	for _, l := range zones.Lines {
+		from, to := toCanvas(l.A), toCanvas(l.B)
+		a.addLine(from, to, lineColor, 3)
+
+		// A short tick from the middle points to the side counted as in.
+		mid := fyne.NewPos((from.X+to.X)/2, (from.Y+to.Y)/2)
+		dx, dy := to.X-from.X, to.Y-from.Y
+		if n := float32(math.Hypot(float64(dx), float64(dy))); n > 0 {
+			tick := fyne.NewPos(mid.X-dy/n*12, mid.Y+dx/n*12)
+			a.addLine(mid, tick, lineColor, 3)
+		}
+
+		label := l.Name
+		if c, ok := counts.Line(l.Name); ok {
+			label = fmt.Sprintf("%s: in %d / out %d", l.Name, c.In, c.Out)
+		}
+		a.addLabel(label, from, lineColor)
+	}
+
+	a.rectContainer.Refresh()
+}
+
+func (a *DetectApp) addLine(from, to fyne.Position, c color.Color, width float32) {
+	line := canvas.NewLine(c)
+	line.StrokeWidth = width
+	line.Position1 = from
+	line.Position2 = to
+	a.rectContainer.Add(line)
+}
+
+func (a *DetectApp) addLabel(text string, at fyne.Position, c color.Color) {
+	txt := canvas.NewText(text, c)
+	txt.TextSize = 12
+	txt.TextStyle = fyne.TextStyle{Bold: true}
+	txt.Move(fyne.NewPos(at.X, at.Y-15))
+	a.rectContainer.Add(txt)
+}
@@ -1,21 +1,82 @@
 package main
 
 import (
+	"log"
+	"os"
+
+	"vision/internal/bench"
 	"vision/internal/config"
 	ui "vision/internal/ui"
 	processing "vision/processing/detector"
+	"vision/processing/history"
+	"vision/processing/sink"
 )
 
 func main() {
-	det := processing.NewRemoteDetector(config.DefaultDetectorProcessorUrl)
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := bench.Main(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg := config.LoadConfigFile(config.DefaultConfigPath)
+
+	det, err := processing.NewDetector(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	det.Start()
 	defer det.Stop()
 
-	cfg := config.LoadConfigFile(config.DefaultConfigPath)
 	proc := processing.NewProcessor(cfg, det)
 
+	// The detection log and snapshots are switched on and off from the UI.
+	dl := sink.NewDetectionLog(cfg)
+	defer dl.Close()
+	proc.AddSink(dl)
+
+	snaps := sink.NewSnapshots(cfg)
+	defer snaps.Close()
+	proc.AddSink(snaps)
+
+	if wc := cfg.GetWebhook(); wc.Enabled {
+		wh, err := sink.NewWebhook(wc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer wh.Close()
+		proc.AddSink(wh)
+	}
+
+	if cfg.GetMQTT().Enabled {
+		mq, err := sink.NewMQTT(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer mq.Close()
+		proc.AddSink(mq)
+	}
+
 	app := ui.CreateApp(proc, cfg)
 
+	// Recording is started and stopped from the UI.
+	rec := sink.NewRecorder(cfg)
+	defer rec.Close()
+	proc.AddSink(rec)
+	app.SetRecorder(rec)
+
+	if cfg.GetHistory().Enabled {
+		// History is optional, so the app still runs without it.
+		if store, err := history.Open(cfg); err != nil {
+			log.Printf("History disabled: %v", err)
+		} else {
+			defer store.Close()
+			proc.AddSink(store)
+			app.SetHistory(store)
+		}
+	}
+
 	app.Run()
 }
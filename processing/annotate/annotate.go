@@ -0,0 +1,101 @@
+// Package annotate burns detection boxes and labels into frames, for output
+// that is viewed outside the UI.
+package annotate
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"vision/internal/models"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const strokeWidth = 2
+
+var (
+	boxColor   = color.RGBA{0, 255, 0, 255}
+	labelColor = color.RGBA{0, 0, 0, 255}
+)
+
+// Label is the text shown with a detection, as in the UI overlay.
+func Label(det models.DetectionResult) string {
+	if det.TrackID != 0 {
+		return fmt.Sprintf("#%d %s %.0f%%", det.TrackID, det.Class, det.Score*100)
+	}
+	return fmt.Sprintf("%s %.0f%%", det.Class, det.Score*100)
+}
+
+// Frame returns a copy of img with the detections drawn on it.
+func Frame(img image.Image, dets []models.DetectionResult) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+
+	Draw(out, dets)
+	return out
+}
+
+// Draw draws the detections onto dst in place.
+func Draw(dst draw.Image, dets []models.DetectionResult) {
+	for _, d := range dets {
+		r := BoxRect(dst.Bounds(), d)
+		if r.Empty() {
+			continue
+		}
+
+		drawRect(dst, r, boxColor)
+		drawLabel(dst, r.Min, Label(d))
+	}
+}
+
+// BoxRect converts a detection box to pixel coordinates within bounds.
+func BoxRect(bounds image.Rectangle, d models.DetectionResult) image.Rectangle {
+	if len(d.Box) < 4 {
+		return image.Rectangle{}
+	}
+
+	w, h := float32(bounds.Dx()), float32(bounds.Dy())
+	r := image.Rect(
+		bounds.Min.X+int(d.Box[1]*w), bounds.Min.Y+int(d.Box[0]*h),
+		bounds.Min.X+int(d.Box[3]*w), bounds.Min.Y+int(d.Box[2]*h),
+	)
+	return r.Intersect(bounds)
+}
+
+func drawRect(dst draw.Image, r image.Rectangle, c color.Color) {
+	src := image.NewUniform(c)
+	sw := min(strokeWidth, r.Dx(), r.Dy())
+
+	for _, edge := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+sw),
+		image.Rect(r.Min.X, r.Max.Y-sw, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+sw, r.Max.Y),
+		image.Rect(r.Max.X-sw, r.Min.Y, r.Max.X, r.Max.Y),
+	} {
+		draw.Draw(dst, edge, src, image.Point{}, draw.Src)
+	}
+}
+
+// drawLabel writes text on a filled tab above at, or just inside the box
+// when there is no room above it.
+func drawLabel(dst draw.Image, at image.Point, text string) {
+	face := basicfont.Face7x13
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(labelColor), Face: face}
+
+	width := d.MeasureString(text).Ceil() + 4
+	height := face.Metrics().Height.Ceil() + 2
+
+	tab := image.Rect(at.X, at.Y-height, at.X+width, at.Y)
+	if tab.Min.Y < dst.Bounds().Min.Y {
+		tab = tab.Add(image.Pt(0, height))
+	}
+	draw.Draw(dst, tab, image.NewUniform(boxColor), image.Point{}, draw.Src)
+
+	d.Dot = fixed.P(tab.Min.X+2, tab.Min.Y+face.Metrics().Ascent.Ceil()+1)
+	d.DrawString(text)
+}
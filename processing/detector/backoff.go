@@ -0,0 +1,32 @@
+package processing
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff produces exponentially growing reconnect delays with full jitter.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	return &backoff{min: min, max: max}
+}
+
+func (b *backoff) Next() time.Duration {
+	ceil := b.min << b.attempt
+	if ceil <= 0 || ceil > b.max {
+		ceil = b.max
+	} else {
+		b.attempt++
+	}
+
+	return b.min/2 + time.Duration(rand.Int63n(int64(ceil-b.min/2)+1))
+}
+
+func (b *backoff) Reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,54 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"vision/internal/models"
+)
+
+// deliverBatch splits a batched response into per-frame result lists,
+// emitted in frame order. Frames of the batch the response leaves out get a
+// failed result; results for frames that were not sent are reported.
+func (d *RemoteDetector) deliverBatch(ctx context.Context, resp batchResponse, sent *sentFrames) {
+	sort.Slice(resp.Results, func(i, j int) bool {
+		return resp.Results[i].FrameID < resp.Results[j].FrameID
+	})
+
+	var batch uint64
+	for _, item := range resp.Results {
+		b, ok := sent.batchOf(item.FrameID)
+		if !ok || b == 0 {
+			d.reportError(fmt.Errorf("detector %s: batch result for unknown frame %d", d.serverURL, item.FrameID))
+			continue
+		}
+		batch = b
+
+		if item.Error != nil {
+			wire := item.FrameID
+			item.Error.FrameID = &wire
+			serr := newServerError(d.serverURL, *item.Error)
+			d.failFrame(ctx, sent, serr)
+			d.reportError(serr)
+			continue
+		}
+
+		if item.Results == nil {
+			item.Results = []models.DetectionResult{}
+		}
+		if id, ok := sent.take(&item.FrameID); ok {
+			d.deliver(ctx, Result{FrameID: id, Detections: item.Results})
+		}
+	}
+
+	if batch == 0 {
+		return
+	}
+	if missing := sent.takeBatch(batch); len(missing) > 0 {
+		d.reportError(fmt.Errorf("detector %s: batch response is missing %d frames", d.serverURL, len(missing)))
+		for _, id := range missing {
+			d.deliver(ctx, Result{FrameID: id})
+		}
+	}
+}
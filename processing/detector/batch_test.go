@@ -0,0 +1,253 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// memConn is an in-memory detectorConn. It answers the hello with maxBatch
+// and every upload with what reply makes of the frame numbers sent.
+type memConn struct {
+	maxBatch int
+	reply    func(wires []uint64) any
+
+	incoming  chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	next    uint64
+	uploads [][]uint64
+}
+
+func newMemConn(maxBatch int, reply func(wires []uint64) any) *memConn {
+	return &memConn{
+		maxBatch: maxBatch,
+		reply:    reply,
+		incoming: make(chan []byte, 64),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (c *memConn) send(v any) {
+	data, _ := json.Marshal(v)
+	c.incoming <- data
+}
+
+func (c *memConn) WriteJSON(v any) error {
+	if _, ok := v.(helloRequest); ok {
+		c.send(helloResponse{Type: msgHello, Encoding: config.EncodingJPEG, MaxBatch: c.maxBatch})
+	}
+	return nil
+}
+
+func (c *memConn) WriteFrame(img image.Image, enc config.EncodingConfig) (bool, error) {
+	c.mu.Lock()
+	wire := c.next
+	c.next++
+	c.uploads = append(c.uploads, []uint64{wire})
+	c.mu.Unlock()
+
+	c.send(c.reply([]uint64{wire}))
+	return true, nil
+}
+
+func (c *memConn) WriteBatch(frames []image.Image, ids []uint64, enc config.EncodingConfig) ([]bool, error) {
+	c.mu.Lock()
+	c.uploads = append(c.uploads, slices.Clone(ids))
+	c.next += uint64(len(ids))
+	c.mu.Unlock()
+
+	c.send(c.reply(ids))
+	ok := make([]bool, len(frames))
+	for i := range ok {
+		ok[i] = true
+	}
+	return ok, nil
+}
+
+func (c *memConn) ReadMessage() ([]byte, error) {
+	select {
+	case msg := <-c.incoming:
+		return msg, nil
+	case <-c.closed:
+		return nil, errors.New("closed")
+	}
+}
+
+func (c *memConn) SetReadDeadline(time.Time) error { return nil }
+func (c *memConn) Ping() error                     { return nil }
+func (c *memConn) SetPongHandler(func())           {}
+
+func (c *memConn) Close(bool) error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *memConn) sent() [][]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.uploads)
+}
+
+func newMemDetector(t *testing.T, cfg config.DetectorConfig, conn *memConn) *RemoteDetector {
+	t.Helper()
+	d := NewRemoteDetector("mem", cfg)
+	d.dial = func(context.Context) (detectorConn, error) { return conn, nil }
+	d.Start()
+	t.Cleanup(d.Stop)
+	waitState(t, d, StateConnected)
+	return d
+}
+
+// batchReply answers every frame of a batch, leaving out those in skip.
+func batchReply(skip ...uint64) func([]uint64) any {
+	return func(wires []uint64) any {
+		resp := batchResponse{Type: msgBatch}
+		for _, w := range wires {
+			if !slices.Contains(skip, w) {
+				resp.Results = append(resp.Results, batchItem{FrameID: w, Results: []models.DetectionResult{{Class: "car"}}})
+			}
+		}
+		return resp
+	}
+}
+
+func collect(t *testing.T, d *RemoteDetector, n int) map[uint64]Result {
+	t.Helper()
+	got := make(map[uint64]Result)
+	timeout := time.After(5 * time.Second)
+	for len(got) < n {
+		select {
+		case res := <-d.Output():
+			if _, dup := got[res.FrameID]; dup {
+				t.Fatalf("two results for frame %d", res.FrameID)
+			}
+			got[res.FrameID] = res
+		case <-timeout:
+			t.Fatalf("got %d of %d results: %+v", len(got), n, got)
+		}
+	}
+	return got
+}
+
+func TestRemoteDetectorBatching(t *testing.T) {
+	conn := newMemConn(8, batchReply())
+	d := newMemDetector(t, config.DetectorConfig{Batch: config.BatchConfig{Size: 3, MaxWaitMs: 20}}, conn)
+
+	waitBatchSize(t, d, 3)
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for id := range uint64(4) {
+		d.Input() <- Frame{ID: 100 + id, Image: img}
+	}
+
+	// Three frames fill a batch, the fourth goes once it has waited long
+	// enough.
+	got := collect(t, d, 4)
+	for id := range uint64(4) {
+		if res := got[100+id]; len(res.Detections) != 1 {
+			t.Errorf("frame %d: %+v", 100+id, res)
+		}
+	}
+	if uploads := conn.sent(); !slices.EqualFunc(uploads, [][]uint64{{0, 1, 2}, {3}}, slices.Equal) {
+		t.Errorf("uploads = %v, want [0 1 2] then [3]", uploads)
+	}
+}
+
+func TestRemoteDetectorBatchMissingFrames(t *testing.T) {
+	conn := newMemConn(8, batchReply(1))
+	d := newMemDetector(t, config.DetectorConfig{Batch: config.BatchConfig{Size: 3, MaxWaitMs: 1000}}, conn)
+
+	waitBatchSize(t, d, 3)
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for id := range uint64(3) {
+		d.Input() <- Frame{ID: 100 + id, Image: img}
+	}
+
+	got := collect(t, d, 3)
+	if got[101].Detections != nil {
+		t.Errorf("frame left out of the response got %+v, want a failed result", got[101])
+	}
+	if got[100].Detections == nil || got[102].Detections == nil {
+		t.Errorf("answered frames failed: %+v", got)
+	}
+
+	select {
+	case err := <-d.Errors():
+		if err == nil {
+			t.Error("nil error")
+		}
+	case <-time.After(time.Second):
+		t.Error("missing frame not reported")
+	}
+}
+
+func TestDeliverBatch(t *testing.T) {
+	d := NewRemoteDetector("mem", config.DetectorConfig{Batch: config.BatchConfig{Size: 4}})
+	sent := &sentFrames{}
+	sent.add(7) // a single frame, not part of the batch
+	wires := sent.addBatch([]uint64{10, 11, 12, 13})
+
+	unknown := uint64(99)
+	d.deliverBatch(context.Background(), batchResponse{Results: []batchItem{
+		{FrameID: wires[3], Results: nil},
+		{FrameID: wires[0], Results: []models.DetectionResult{{Class: "dog"}}},
+		{FrameID: wires[2], Error: &errorMessage{Code: "decode", Message: "bad image"}},
+		{FrameID: unknown},
+		{FrameID: 0}, // the single frame
+	}}, sent)
+
+	got := make(map[uint64]Result)
+	for len(d.OutputResult) > 0 {
+		res := <-d.OutputResult
+		got[res.FrameID] = res
+	}
+
+	if len(got) != 4 {
+		t.Fatalf("results = %+v, want one per frame of the batch", got)
+	}
+	if len(got[10].Detections) != 1 {
+		t.Errorf("frame 10 = %+v", got[10])
+	}
+	if got[13].Detections == nil {
+		t.Error("empty results turned into a failure")
+	}
+	for _, id := range []uint64{11, 12} {
+		if got[id].Detections != nil {
+			t.Errorf("frame %d = %+v, want failed", id, got[id])
+		}
+	}
+	if id, ok := sent.take(nil); !ok || id != 7 {
+		t.Errorf("single frame taken by a batch response: %d, %v", id, ok)
+	}
+
+	// The error, the unknown frame, the single frame and the missing one.
+	if n := len(d.errs); n != 4 {
+		t.Errorf("%d errors reported, want 4", n)
+	}
+}
+
+func waitBatchSize(t *testing.T, d *RemoteDetector, size int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, n := d.negotiation(); n == size {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("batch size never reached %d", size)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
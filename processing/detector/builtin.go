@@ -0,0 +1,142 @@
+package processing
+
+import (
+	"context"
+	"image"
+	"sync"
+	"time"
+
+	"vision/internal/models"
+)
+
+// analysisWidth is the width frames are sampled down to before analysis.
+// Detection boxes are normalised, so the working resolution only trades
+// precision for speed.
+const analysisWidth = 160
+
+// FrameAnalyzer turns a single frame into detections. Implementations may keep
+// state between frames and are not safe for concurrent use.
+type FrameAnalyzer interface {
+	Analyze(img image.Image) []models.DetectionResult
+}
+
+// LocalDetector runs a pure-Go analyzer in-process. It needs no server and is
+// always reported as connected.
+type LocalDetector struct {
+	analyzer FrameAnalyzer
+
+	input  chan Frame
+	output chan Result
+	errs   chan error
+
+	started time.Time
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func newLocalDetector(analyzer FrameAnalyzer) *LocalDetector {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &LocalDetector{
+		analyzer: analyzer,
+		input:    make(chan Frame, 5),
+		output:   make(chan Result, 5),
+		started:  time.Now(),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (d *LocalDetector) Start() {
+	d.startOnce.Do(func() {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.run()
+		}()
+	})
+}
+
+func (d *LocalDetector) Stop() {
+	d.stopOnce.Do(func() {
+		d.cancel()
+		d.wg.Wait()
+	})
+}
+
+func (d *LocalDetector) Input() chan<- Frame {
+	return d.input
+}
+
+func (d *LocalDetector) Output() <-chan Result {
+	return d.output
+}
+
+// Errors is nil, and so never ready, unless the analyzer can report errors.
+func (d *LocalDetector) Errors() <-chan error {
+	return d.errs
+}
+
+func (d *LocalDetector) Status() ConnStatus {
+	return ConnStatus{State: StateConnected, Since: d.started}
+}
+
+func (d *LocalDetector) run() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case f := <-d.input:
+			results := d.analyzer.Analyze(f.Image)
+
+			select {
+			case d.output <- Result{FrameID: f.ID, Detections: results}:
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// sampler reads pixels from a frame at a reduced resolution, with a fast path
+// for the *image.RGBA frames produced by the capture package.
+type sampler struct {
+	img    image.Image
+	rgba   *image.RGBA
+	bounds image.Rectangle
+
+	Width  int
+	Height int
+	step   float64
+}
+
+func newSampler(img image.Image) sampler {
+	b := img.Bounds()
+	s := sampler{img: img, bounds: b, Width: b.Dx(), Height: b.Dy(), step: 1}
+
+	if s.Width > analysisWidth {
+		s.step = float64(s.Width) / analysisWidth
+		s.Width = analysisWidth
+		s.Height = int(float64(b.Dy()) / s.step)
+	}
+
+	s.rgba, _ = img.(*image.RGBA)
+	return s
+}
+
+func (s sampler) RGB(x, y int) (r, g, b uint8) {
+	px := s.bounds.Min.X + int(float64(x)*s.step)
+	py := s.bounds.Min.Y + int(float64(y)*s.step)
+
+	if s.rgba != nil {
+		i := s.rgba.PixOffset(px, py)
+		return s.rgba.Pix[i], s.rgba.Pix[i+1], s.rgba.Pix[i+2]
+	}
+
+	cr, cg, cb, _ := s.img.At(px, py).RGBA()
+	return uint8(cr >> 8), uint8(cg >> 8), uint8(cb >> 8)
+}
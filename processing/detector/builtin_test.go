@@ -0,0 +1,243 @@
+package processing
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// testFrame is a w×h frame of bg with each rect filled with its colour.
+func testFrame(w, h int, bg color.RGBA, rects ...coloredRect) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetRGBA(x, y, bg)
+		}
+	}
+	for _, r := range rects {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.SetRGBA(x, y, r.c)
+			}
+		}
+	}
+	return img
+}
+
+type coloredRect struct {
+	image.Rectangle
+	c color.RGBA
+}
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	gray  = color.RGBA{100, 100, 100, 255}
+	white = color.RGBA{255, 255, 255, 255}
+	red   = color.RGBA{220, 20, 20, 255}
+	pink  = color.RGBA{220, 20, 60, 255} // hue ~348°, past the wrap
+	blue  = color.RGBA{20, 20, 220, 255}
+	dull  = color.RGBA{120, 100, 100, 255} // reddish but barely saturated
+)
+
+func rect(x0, y0, x1, y1 int, c color.RGBA) coloredRect {
+	return coloredRect{image.Rect(x0, y0, x1, y1), c}
+}
+
+func checkDetections(t *testing.T, got, want []models.DetectionResult) {
+	t.Helper()
+
+	if got == nil {
+		t.Fatal("got nil detections, want a non-nil slice")
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d detections %+v, want %d", len(got), got, len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Class != w.Class || math.Abs(float64(g.Score-w.Score)) > 0.01 {
+			t.Errorf("detection %d = %s %.2f, want %s %.2f", i, g.Class, g.Score, w.Class, w.Score)
+		}
+		for j := range w.Box {
+			if math.Abs(float64(g.Box[j]-w.Box[j])) > 0.001 {
+				t.Errorf("detection %d box = %v, want %v", i, g.Box, w.Box)
+				break
+			}
+		}
+	}
+}
+
+func TestMotionAnalyzer(t *testing.T) {
+	cfg := config.MotionConfig{Threshold: 25, LearningRate: 0.05, MinArea: 0.002}
+
+	for _, tc := range []struct {
+		name   string
+		cfg    config.MotionConfig
+		frames []*image.RGBA
+		want   []models.DetectionResult
+	}{
+		{
+			name:   "first frame",
+			cfg:    cfg,
+			frames: []*image.RGBA{testFrame(100, 100, gray, rect(10, 30, 30, 40, white))},
+			want:   []models.DetectionResult{},
+		},
+		{
+			name: "object appears",
+			cfg:  cfg,
+			frames: []*image.RGBA{
+				testFrame(100, 100, gray),
+				testFrame(100, 100, gray, rect(10, 30, 30, 40, white)),
+			},
+			want: []models.DetectionResult{{Class: "motion", Score: 1, Box: []float32{0.3, 0.1, 0.4, 0.3}}},
+		},
+		{
+			name: "below threshold",
+			cfg:  cfg,
+			frames: []*image.RGBA{
+				testFrame(100, 100, gray),
+				testFrame(100, 100, gray, rect(10, 30, 30, 40, color.RGBA{120, 120, 120, 255})),
+			},
+			want: []models.DetectionResult{},
+		},
+		{
+			name: "below min area",
+			cfg:  config.MotionConfig{Threshold: 25, LearningRate: 0.05, MinArea: 0.01},
+			frames: []*image.RGBA{
+				testFrame(100, 100, gray),
+				testFrame(100, 100, gray, rect(50, 50, 55, 55, white)),
+			},
+			want: []models.DetectionResult{},
+		},
+		{
+			name: "frame size changes",
+			cfg:  cfg,
+			frames: []*image.RGBA{
+				testFrame(100, 100, gray),
+				testFrame(80, 60, gray, rect(10, 10, 20, 20, white)),
+			},
+			want: []models.DetectionResult{},
+		},
+		{
+			name: "two objects",
+			cfg:  cfg,
+			frames: []*image.RGBA{
+				testFrame(100, 100, gray),
+				testFrame(100, 100, gray, rect(0, 0, 10, 10, white), rect(60, 80, 100, 100, black)),
+			},
+			want: []models.DetectionResult{
+				{Class: "motion", Score: 1, Box: []float32{0, 0, 0.1, 0.1}},
+				{Class: "motion", Score: 1, Box: []float32{0.8, 0.6, 1, 1}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := NewMotionAnalyzer(tc.cfg)
+			var got []models.DetectionResult
+			for _, f := range tc.frames {
+				got = a.Analyze(f)
+			}
+			checkDetections(t, got, tc.want)
+		})
+	}
+}
+
+func TestColorAnalyzer(t *testing.T) {
+	redRange := config.ColorRange{Name: "red", HueMin: 340, HueMax: 20, SatMin: 0.5, SatMax: 1, ValMin: 0.3, ValMax: 1}
+	blueRange := config.ColorRange{Name: "blue", HueMin: 200, HueMax: 260, SatMin: 0.5, SatMax: 1, ValMin: 0.3, ValMax: 1}
+	cfg := config.ColorConfig{Ranges: []config.ColorRange{redRange, blueRange}, MinArea: 0.002}
+
+	for _, tc := range []struct {
+		name  string
+		cfg   config.ColorConfig
+		frame *image.RGBA
+		want  []models.DetectionResult
+	}{
+		{
+			name:  "nothing",
+			cfg:   cfg,
+			frame: testFrame(100, 100, black),
+			want:  []models.DetectionResult{},
+		},
+		{
+			name:  "red blob",
+			cfg:   cfg,
+			frame: testFrame(100, 100, black, rect(20, 40, 60, 50, red)),
+			want:  []models.DetectionResult{{Class: "red", Score: 1, Box: []float32{0.4, 0.2, 0.5, 0.6}}},
+		},
+		{
+			name:  "hue wraps around",
+			cfg:   cfg,
+			frame: testFrame(100, 100, black, rect(0, 0, 10, 10, pink)),
+			want:  []models.DetectionResult{{Class: "red", Score: 1, Box: []float32{0, 0, 0.1, 0.1}}},
+		},
+		{
+			name:  "not saturated",
+			cfg:   cfg,
+			frame: testFrame(100, 100, black, rect(0, 0, 10, 10, dull)),
+			want:  []models.DetectionResult{},
+		},
+		{
+			name:  "below min area",
+			cfg:   config.ColorConfig{Ranges: cfg.Ranges, MinArea: 0.05},
+			frame: testFrame(100, 100, black, rect(0, 0, 10, 10, red)),
+			want:  []models.DetectionResult{},
+		},
+		{
+			name: "one class per range",
+			cfg:  cfg,
+			frame: testFrame(100, 100, black,
+				rect(0, 0, 10, 10, blue), rect(50, 50, 70, 60, red)),
+			want: []models.DetectionResult{
+				{Class: "red", Score: 1, Box: []float32{0.5, 0.5, 0.6, 0.7}},
+				{Class: "blue", Score: 1, Box: []float32{0, 0, 0.1, 0.1}},
+			},
+		},
+		{
+			// An L shape fills three quarters of its box.
+			name: "score is fill",
+			cfg:  cfg,
+			frame: testFrame(100, 100, black,
+				rect(0, 0, 20, 10, red), rect(0, 10, 10, 20, red)),
+			want: []models.DetectionResult{{Class: "red", Score: 0.75, Box: []float32{0, 0, 0.2, 0.2}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			checkDetections(t, NewColorAnalyzer(tc.cfg).Analyze(tc.frame), tc.want)
+		})
+	}
+}
+
+func TestLocalDetector(t *testing.T) {
+	d := NewMotionDetector(config.MotionConfig{Threshold: 25, LearningRate: 0.05, MinArea: 0.002})
+	d.Start()
+	defer d.Stop()
+
+	frames := []*image.RGBA{
+		testFrame(100, 100, gray),
+		testFrame(100, 100, gray, rect(10, 30, 30, 40, white)),
+	}
+	for i, f := range frames {
+		d.Input() <- Frame{ID: uint64(i + 7), Image: f}
+
+		select {
+		case res := <-d.Output():
+			if res.FrameID != uint64(i+7) {
+				t.Fatalf("result for frame %d, want %d", res.FrameID, i+7)
+			}
+			if want := i; len(res.Detections) != want {
+				t.Errorf("frame %d: %d detections, want %d", i, len(res.Detections), want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no result for frame %d", i)
+		}
+	}
+
+	if st := d.Status(); st.State != StateConnected {
+		t.Errorf("state = %s, want %s", st.State, StateConnected)
+	}
+}
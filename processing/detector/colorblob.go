@@ -0,0 +1,99 @@
+package processing
+
+import (
+	"image"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// colorAnalyzer reports connected blobs of pixels falling into any of the
+// configured HSV ranges, using the range name as the class.
+type colorAnalyzer struct {
+	ranges  []config.ColorRange
+	minArea float32
+}
+
+func NewColorDetector(cfg config.ColorConfig) *LocalDetector {
+	return newLocalDetector(NewColorAnalyzer(cfg))
+}
+
+func NewColorAnalyzer(cfg config.ColorConfig) FrameAnalyzer {
+	return &colorAnalyzer{ranges: cfg.Ranges, minArea: cfg.MinArea}
+}
+
+func (c *colorAnalyzer) Analyze(img image.Image) []models.DetectionResult {
+	s := newSampler(img)
+	n := s.Width * s.Height
+
+	masks := make([][]bool, len(c.ranges))
+	for i := range masks {
+		masks[i] = make([]bool, n)
+	}
+
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			h, sat, v := rgbToHSV(s.RGB(x, y))
+
+			for i, r := range c.ranges {
+				if inColorRange(r, h, sat, v) {
+					masks[i][y*s.Width+x] = true
+				}
+			}
+		}
+	}
+
+	minArea := minAreaPixels(c.minArea, s.Width, s.Height)
+
+	results := []models.DetectionResult{}
+	for i, r := range c.ranges {
+		for _, comp := range labelComponents(masks[i], s.Width, s.Height, minArea) {
+			results = append(results, comp.toDetection(r.Name, s.Width, s.Height))
+		}
+	}
+	return results
+}
+
+func inColorRange(r config.ColorRange, h, s, v float32) bool {
+	if s < r.SatMin || s > r.SatMax || v < r.ValMin || v > r.ValMax {
+		return false
+	}
+
+	if r.HueMin <= r.HueMax {
+		return h >= r.HueMin && h <= r.HueMax
+	}
+	return h >= r.HueMin || h <= r.HueMax
+}
+
+// rgbToHSV returns hue in degrees and saturation/value in [0, 1].
+func rgbToHSV(r8, g8, b8 uint8) (h, s, v float32) {
+	r := float32(r8) / 255
+	g := float32(g8) / 255
+	b := float32(b8) / 255
+
+	hi := max(r, g, b)
+	lo := min(r, g, b)
+	delta := hi - lo
+
+	v = hi
+	if hi > 0 {
+		s = delta / hi
+	}
+	if delta == 0 {
+		return 0, s, v
+	}
+
+	switch hi {
+	case r:
+		h = 60 * ((g - b) / delta)
+	case g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+
+	if h < 0 {
+		h += 360
+	}
+	return h, s, v
+}
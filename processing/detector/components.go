@@ -0,0 +1,83 @@
+package processing
+
+import "vision/internal/models"
+
+type component struct {
+	area                   int
+	minX, minY, maxX, maxY int
+}
+
+// labelComponents finds 8-connected regions of set pixels in a width*height
+// mask and returns those covering at least minArea pixels.
+func labelComponents(mask []bool, width, height, minArea int) []component {
+	visited := make([]bool, len(mask))
+	stack := make([]int, 0, 64)
+
+	var comps []component
+
+	for start, set := range mask {
+		if !set || visited[start] {
+			continue
+		}
+
+		c := component{minX: width, minY: height, maxX: -1, maxY: -1}
+		visited[start] = true
+		stack = append(stack[:0], start)
+
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			x, y := i%width, i/width
+			c.area++
+			c.minX = min(c.minX, x)
+			c.minY = min(c.minY, y)
+			c.maxX = max(c.maxX, x)
+			c.maxY = max(c.maxY, y)
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= width || ny >= height {
+						continue
+					}
+
+					j := ny*width + nx
+					if mask[j] && !visited[j] {
+						visited[j] = true
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+
+		if c.area >= minArea {
+			comps = append(comps, c)
+		}
+	}
+
+	return comps
+}
+
+// toDetection converts a component to the normalised [y1, x1, y2, x2] box
+// layout the detection server uses. The score is the share of the box the
+// component actually fills.
+func (c component) toDetection(class string, width, height int) models.DetectionResult {
+	w := c.maxX - c.minX + 1
+	h := c.maxY - c.minY + 1
+
+	return models.DetectionResult{
+		Class: class,
+		Score: float32(c.area) / float32(w*h),
+		Box: []float32{
+			float32(c.minY) / float32(height),
+			float32(c.minX) / float32(width),
+			float32(c.maxY+1) / float32(height),
+			float32(c.maxX+1) / float32(width),
+		},
+	}
+}
+
+func minAreaPixels(fraction float32, width, height int) int {
+	return max(1, int(fraction*float32(width*height)))
+}
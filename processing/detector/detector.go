@@ -0,0 +1,57 @@
+package processing
+
+import (
+	"image"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// Frame is an image submitted for detection. Its ID comes back with the
+// results, so they reach the right frame whatever was lost in between.
+type Frame struct {
+	ID    uint64
+	Image image.Image
+}
+
+// Result holds the detections for one frame. Detections are nil when the
+// detector failed on the frame.
+type Result struct {
+	FrameID    uint64
+	Detections []models.DetectionResult
+}
+
+type Detector interface {
+	Start()
+	Stop()
+	Input() chan<- Frame
+	Output() <-chan Result
+	Errors() <-chan error
+	Status() ConnStatus
+}
+
+// SessionDetector is implemented by detectors backed by a server whose model,
+// classes and thresholds can be changed at runtime.
+type SessionDetector interface {
+	UpdateSession(s config.SessionConfig)
+	Capabilities() Capabilities
+}
+
+// detectorAs looks for a T among d and the detectors it wraps, following
+// Unwrap like errors.As does.
+func detectorAs[T any](d Detector) (T, bool) {
+	for d != nil {
+		if t, ok := d.(T); ok {
+			return t, true
+		}
+
+		w, ok := d.(interface{ Unwrap() Detector })
+		if !ok {
+			break
+		}
+		d = w.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
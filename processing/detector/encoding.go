@@ -0,0 +1,118 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"sync"
+	"time"
+
+	"vision/internal/config"
+)
+
+// rawFrameMagic prefixes raw uploads, followed by the big-endian uint32 width
+// and height and then width*height*3 bytes of packed RGB.
+var rawFrameMagic = [4]byte{'R', 'G', 'B', '8'}
+
+var pngEncoder = png.Encoder{CompressionLevel: png.BestSpeed}
+
+func encodeFrame(img image.Image, enc config.EncodingConfig) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch enc.Format {
+	case config.EncodingPNG:
+		if err := pngEncoder.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+
+	case config.EncodingRaw:
+		writeRawRGB(&buf, img)
+
+	case config.EncodingJPEG, "":
+		var opts *jpeg.Options
+		if enc.Quality > 0 {
+			opts = &jpeg.Options{Quality: enc.Quality}
+		}
+		if err := jpeg.Encode(&buf, img, opts); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown frame encoding: %s", enc.Format)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeRawRGB(buf *bytes.Buffer, img image.Image) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	buf.Grow(len(rawFrameMagic) + 8 + w*h*3)
+	buf.Write(rawFrameMagic[:])
+	binary.Write(buf, binary.BigEndian, uint32(w))
+	binary.Write(buf, binary.BigEndian, uint32(h))
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := rgba.Pix[rgba.PixOffset(b.Min.X, y):]
+			for x := 0; x < w; x++ {
+				buf.Write(row[x*4 : x*4+3])
+			}
+		}
+		return
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			buf.Write([]byte{uint8(r >> 8), uint8(g >> 8), uint8(bl >> 8)})
+		}
+	}
+}
+
+type EncodeStats struct {
+	Encoding   config.FrameEncoding
+	Frames     uint64
+	Bytes      uint64
+	AvgBytes   int
+	AvgEncode  time.Duration
+	LastEncode time.Duration
+}
+
+// encodeMeter keeps running totals and exponential moving averages of the
+// upload cost per frame.
+type encodeMeter struct {
+	mu    sync.Mutex
+	stats EncodeStats
+}
+
+const encodeMeterAlpha = 0.1
+
+func (m *encodeMeter) Observe(enc config.FrameEncoding, size int, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := &m.stats
+	if s.Frames == 0 || s.Encoding != enc {
+		s.AvgBytes = size
+		s.AvgEncode = took
+	} else {
+		s.AvgBytes += int(encodeMeterAlpha * float64(size-s.AvgBytes))
+		s.AvgEncode += time.Duration(encodeMeterAlpha * float64(took-s.AvgEncode))
+	}
+
+	s.Encoding = enc
+	s.Frames++
+	s.Bytes += uint64(size)
+	s.LastEncode = took
+}
+
+func (m *encodeMeter) Snapshot() EncodeStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
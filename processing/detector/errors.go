@@ -0,0 +1,37 @@
+package processing
+
+import "fmt"
+
+// ServerError is a failure or status change reported by the detection server
+// itself, as opposed to a transport error.
+type ServerError struct {
+	Endpoint string
+	Code     string
+	Message  string
+
+	FrameID  uint64
+	PerFrame bool
+}
+
+func (e *ServerError) Error() string {
+	msg := fmt.Sprintf("detector %s: %s", e.Endpoint, e.Code)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.PerFrame {
+		msg += fmt.Sprintf(" (frame %d)", e.FrameID)
+	}
+	return msg
+}
+
+func newServerError(endpoint string, m errorMessage) *ServerError {
+	e := &ServerError{Endpoint: endpoint, Code: m.Code, Message: m.Message}
+	if m.FrameID != nil {
+		e.FrameID = *m.FrameID
+		e.PerFrame = true
+	}
+	if e.Code == "" {
+		e.Code = "error"
+	}
+	return e
+}
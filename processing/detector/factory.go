@@ -0,0 +1,48 @@
+package processing
+
+import (
+	"fmt"
+
+	"vision/internal/config"
+)
+
+func NewDetector(cfg *config.Config) (Detector, error) {
+	dc := cfg.GetDetectorConfig()
+
+	det, err := newBackend(dc)
+	if err != nil {
+		return nil, err
+	}
+
+	if dc.Record != "" {
+		return NewRecordingDetector(det, dc.Record)
+	}
+	return det, nil
+}
+
+func newBackend(dc config.DetectorConfig) (Detector, error) {
+	switch dc.Backend {
+	case config.BackendMotion:
+		return NewMotionDetector(dc.Motion), nil
+	case config.BackendColor:
+		return NewColorDetector(dc.Color), nil
+	case config.BackendReplay:
+		return NewReplayDetector(dc.Replay)
+	case config.BackendRemote, "":
+	default:
+		return nil, fmt.Errorf("unknown detector backend: %s", dc.Backend)
+	}
+
+	if err := checkTransport(dc.Transport); err != nil {
+		return nil, err
+	}
+
+	switch len(dc.Endpoints) {
+	case 0:
+		return nil, fmt.Errorf("no detector endpoints configured")
+	case 1:
+		return NewRemoteDetector(dc.Endpoints[0], dc), nil
+	default:
+		return NewDetectorPool(dc)
+	}
+}
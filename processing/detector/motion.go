@@ -0,0 +1,74 @@
+package processing
+
+import (
+	"image"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// motionAnalyzer subtracts a running-average background from each frame. A
+// learning rate of 1 makes the background the previous frame, which turns it
+// into plain frame differencing.
+type motionAnalyzer struct {
+	cfg config.MotionConfig
+
+	background    []float32
+	width, height int
+}
+
+func NewMotionDetector(cfg config.MotionConfig) *LocalDetector {
+	return newLocalDetector(NewMotionAnalyzer(cfg))
+}
+
+func NewMotionAnalyzer(cfg config.MotionConfig) FrameAnalyzer {
+	return &motionAnalyzer{cfg: cfg}
+}
+
+func (m *motionAnalyzer) Analyze(img image.Image) []models.DetectionResult {
+	s := newSampler(img)
+	n := s.Width * s.Height
+
+	if len(m.background) != n || m.width != s.Width {
+		m.background = make([]float32, n)
+		m.width, m.height = s.Width, s.Height
+
+		for y := 0; y < s.Height; y++ {
+			for x := 0; x < s.Width; x++ {
+				m.background[y*s.Width+x] = luma(s.RGB(x, y))
+			}
+		}
+		return []models.DetectionResult{}
+	}
+
+	threshold := float32(m.cfg.Threshold)
+	rate := m.cfg.LearningRate
+	mask := make([]bool, n)
+
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			i := y*s.Width + x
+			v := luma(s.RGB(x, y))
+
+			diff := v - m.background[i]
+			if diff < 0 {
+				diff = -diff
+			}
+			mask[i] = diff > threshold
+
+			m.background[i] += rate * (v - m.background[i])
+		}
+	}
+
+	comps := labelComponents(mask, s.Width, s.Height, minAreaPixels(m.cfg.MinArea, s.Width, s.Height))
+
+	results := make([]models.DetectionResult, 0, len(comps))
+	for _, c := range comps {
+		results = append(results, c.toDetection("motion", s.Width, s.Height))
+	}
+	return results
+}
+
+func luma(r, g, b uint8) float32 {
+	return 0.299*float32(r) + 0.587*float32(g) + 0.114*float32(b)
+}
@@ -0,0 +1,532 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"vision/internal/config"
+)
+
+const (
+	poolFrameTimeout   = 3 * time.Second
+	poolHealthInterval = 500 * time.Millisecond
+	poolReadmitDelay   = 5 * time.Second
+	poolMaxFailures    = 3
+	poolLatencyAlpha   = 0.2
+)
+
+type EndpointStats struct {
+	URL        string
+	State      ConnState
+	Healthy    bool
+	InFlight   int
+	Sent       uint64
+	Received   uint64
+	Lost       uint64
+	AvgLatency time.Duration
+	Encode     EncodeStats
+}
+
+type pendingFrame struct {
+	seq    uint64
+	id     uint64
+	sentAt time.Time
+}
+
+type poolMember struct {
+	det *RemoteDetector
+
+	healthy   bool
+	failures  int
+	ejectedAt time.Time
+	conns     uint64
+
+	pending []pendingFrame
+	latency time.Duration
+
+	sent     uint64
+	received uint64
+	lost     uint64
+}
+
+type memberResult struct {
+	idx     int
+	results Result
+}
+
+// DetectorPool spreads frames across several RemoteDetectors and hands the
+// results back in the order the frames were submitted. Members whose
+// connection drops or who keep timing out are ejected until their connection
+// has been healthy again for poolReadmitDelay.
+type DetectorPool struct {
+	strategy config.BalanceStrategy
+	members  []*poolMember
+
+	input   chan Frame
+	output  chan Result
+	errs    chan error
+	results chan memberResult
+
+	nextSeq  uint64
+	emitSeq  uint64
+	inFlight map[uint64]pendingFrame
+	done     map[uint64]Result
+	rrIndex  int
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	stopOnce  sync.Once
+
+	mu    sync.RWMutex
+	stats []EndpointStats
+}
+
+func NewDetectorPool(cfg config.DetectorConfig) (*DetectorPool, error) {
+	strategy := cfg.Balance
+
+	switch strategy {
+	case "":
+		strategy = config.BalanceRoundRobin
+	case config.BalanceRoundRobin, config.BalanceLeastInFlight, config.BalanceLatency:
+	default:
+		return nil, fmt.Errorf("unknown balance strategy: %s", strategy)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &DetectorPool{
+		strategy: strategy,
+		input:    make(chan Frame, 5),
+		output:   make(chan Result, 5),
+		errs:     make(chan error, 10),
+		results:  make(chan memberResult, 5*len(cfg.Endpoints)),
+		inFlight: make(map[uint64]pendingFrame),
+		done:     make(map[uint64]Result),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	for _, host := range cfg.Endpoints {
+		p.members = append(p.members, &poolMember{det: NewRemoteDetector(host, cfg)})
+	}
+	p.stats = make([]EndpointStats, len(p.members))
+	p.publishStats()
+
+	return p, nil
+}
+
+func (p *DetectorPool) Start() {
+	p.startOnce.Do(func() {
+		for i, m := range p.members {
+			m.det.Start()
+
+			p.wg.Add(1)
+			go func(idx int, det *RemoteDetector) {
+				defer p.wg.Done()
+				p.forwardResults(idx, det)
+			}(i, m.det)
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run()
+		}()
+	})
+}
+
+func (p *DetectorPool) Stop() {
+	p.stopOnce.Do(func() {
+		p.cancel()
+		p.wg.Wait()
+
+		for _, m := range p.members {
+			m.det.Stop()
+		}
+	})
+}
+
+func (p *DetectorPool) Input() chan<- Frame {
+	return p.input
+}
+
+func (p *DetectorPool) Output() <-chan Result {
+	return p.output
+}
+
+func (p *DetectorPool) Errors() <-chan error {
+	return p.errs
+}
+
+// Status summarises the members: Connected when every member is healthy,
+// Degraded when only some are and Disconnected when none are.
+func (p *DetectorPool) Status() ConnStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var status ConnStatus
+	healthy, connecting := 0, 0
+
+	for i, m := range p.members {
+		st := m.det.Status()
+		if p.stats[i].Healthy {
+			healthy++
+		}
+		if st.State == StateConnecting {
+			connecting++
+		}
+		if st.LastError != nil {
+			status.LastError = st.LastError
+		}
+		if st.Since.After(status.Since) {
+			status.Since = st.Since
+		}
+	}
+
+	switch {
+	case healthy == len(p.members):
+		status.State = StateConnected
+	case healthy > 0:
+		status.State = StateDegraded
+	case connecting > 0:
+		status.State = StateConnecting
+	default:
+		status.State = StateDisconnected
+	}
+
+	return status
+}
+
+func (p *DetectorPool) EndpointStats() []EndpointStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]EndpointStats(nil), p.stats...)
+}
+
+func (p *DetectorPool) UpdateSession(s config.SessionConfig) {
+	for _, m := range p.members {
+		m.det.UpdateSession(s)
+	}
+}
+
+// Capabilities reports the union of the models offered by all members, with
+// the classes of the first member that has reported any.
+func (p *DetectorPool) Capabilities() Capabilities {
+	var caps Capabilities
+	seen := make(map[string]bool)
+
+	for _, m := range p.members {
+		c := m.det.Capabilities()
+		for _, model := range c.Models {
+			if !seen[model] {
+				seen[model] = true
+				caps.Models = append(caps.Models, model)
+			}
+		}
+
+		if caps.Classes == nil && c.Classes != nil {
+			caps.Model = c.Model
+			caps.Classes = c.Classes
+		}
+	}
+	return caps
+}
+
+// EncodeStats combines the upload metrics of all members, weighting the
+// averages by the number of frames each member encoded.
+func (p *DetectorPool) EncodeStats() EncodeStats {
+	var total EncodeStats
+	var bytesSum, encodeSum float64
+
+	for _, m := range p.members {
+		s := m.det.EncodeStats()
+		if s.Frames == 0 {
+			continue
+		}
+
+		if total.Encoding == "" {
+			total.Encoding = s.Encoding
+		}
+		total.Frames += s.Frames
+		total.Bytes += s.Bytes
+		bytesSum += float64(s.AvgBytes) * float64(s.Frames)
+		encodeSum += float64(s.AvgEncode) * float64(s.Frames)
+		total.LastEncode = max(total.LastEncode, s.LastEncode)
+	}
+
+	if total.Frames > 0 {
+		total.AvgBytes = int(bytesSum / float64(total.Frames))
+		total.AvgEncode = time.Duration(encodeSum / float64(total.Frames))
+	}
+	return total
+}
+
+func (p *DetectorPool) forwardResults(idx int, det *RemoteDetector) {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case results := <-det.Output():
+			select {
+			case p.results <- memberResult{idx: idx, results: results}:
+			case <-p.ctx.Done():
+				return
+			}
+		case err := <-det.Errors():
+			select {
+			case p.errs <- err:
+			default:
+			}
+		}
+	}
+}
+
+func (p *DetectorPool) run() {
+	ticker := time.NewTicker(poolHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+
+		case f := <-p.input:
+			p.dispatch(f)
+
+		case res := <-p.results:
+			p.collect(res)
+			p.flush()
+
+		case <-ticker.C:
+			p.checkHealth()
+			p.flush()
+			p.publishStats()
+		}
+	}
+}
+
+// dispatch hands f to a healthy member. Frames no member can take get an
+// empty result in their place, so the caller is never left waiting on them.
+func (p *DetectorPool) dispatch(f Frame) {
+	seq := p.nextSeq
+	p.nextSeq++
+
+	m := p.pick()
+	if m != nil {
+		select {
+		case m.det.InputFrames <- f:
+		default:
+			m = nil
+		}
+	}
+	if m == nil {
+		p.done[seq] = Result{FrameID: f.ID}
+		p.flush()
+		return
+	}
+
+	frame := pendingFrame{seq: seq, id: f.ID, sentAt: time.Now()}
+	p.inFlight[seq] = frame
+	m.pending = append(m.pending, frame)
+	m.sent++
+}
+
+func (p *DetectorPool) pick() *poolMember {
+	var healthy []*poolMember
+	for _, m := range p.members {
+		if m.healthy {
+			healthy = append(healthy, m)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	switch p.strategy {
+	case config.BalanceLeastInFlight:
+		best := healthy[0]
+		for _, m := range healthy[1:] {
+			if len(m.pending) < len(best.pending) {
+				best = m
+			}
+		}
+		return best
+
+	case config.BalanceLatency:
+		return pickByLatency(healthy)
+
+	default:
+		p.rrIndex = (p.rrIndex + 1) % len(healthy)
+		return healthy[p.rrIndex]
+	}
+}
+
+// pickByLatency chooses a member at random with probability inversely
+// proportional to its average latency. Members without measurements yet are
+// treated as being as fast as the fastest known member.
+func pickByLatency(members []*poolMember) *poolMember {
+	fastest := time.Duration(0)
+	for _, m := range members {
+		if m.latency > 0 && (fastest == 0 || m.latency < fastest) {
+			fastest = m.latency
+		}
+	}
+	if fastest == 0 {
+		fastest = time.Millisecond
+	}
+
+	weights := make([]float64, len(members))
+	total := 0.0
+	for i, m := range members {
+		lat := m.latency
+		if lat == 0 {
+			lat = fastest
+		}
+		weights[i] = 1 / float64(lat)
+		total += weights[i]
+	}
+
+	r := rand.Float64() * total
+	for i, w := range weights {
+		r -= w
+		if r <= 0 {
+			return members[i]
+		}
+	}
+	return members[len(members)-1]
+}
+
+func (p *DetectorPool) collect(res memberResult) {
+	m := p.members[res.idx]
+
+	// Results for frames already given up on find nothing pending.
+	i := slices.IndexFunc(m.pending, func(f pendingFrame) bool { return f.id == res.results.FrameID })
+	if i < 0 {
+		return
+	}
+
+	frame := m.pending[i]
+	m.pending = slices.Delete(m.pending, i, i+1)
+	m.received++
+	m.failures = 0
+
+	lat := time.Since(frame.sentAt)
+	if m.latency == 0 {
+		m.latency = lat
+	} else {
+		m.latency += time.Duration(poolLatencyAlpha * float64(lat-m.latency))
+	}
+
+	if _, ok := p.inFlight[frame.seq]; ok {
+		delete(p.inFlight, frame.seq)
+		p.done[frame.seq] = Result{FrameID: frame.id, Detections: res.results.Detections}
+	}
+}
+
+// flush emits every result that is next in frame order, waiting for the
+// consumer. Frames that timed out get a failed result so a single failure
+// cannot stall the stream.
+func (p *DetectorPool) flush() {
+	for p.emitSeq < p.nextSeq {
+		results, ok := p.done[p.emitSeq]
+		if ok {
+			delete(p.done, p.emitSeq)
+		} else {
+			f, ok := p.inFlight[p.emitSeq]
+			if !ok {
+				p.emitSeq++
+				continue
+			}
+			if time.Since(f.sentAt) < poolFrameTimeout {
+				return
+			}
+			delete(p.inFlight, p.emitSeq)
+			results = Result{FrameID: f.id}
+		}
+
+		select {
+		case p.output <- results:
+		case <-p.ctx.Done():
+			return
+		}
+		p.emitSeq++
+	}
+}
+
+func (p *DetectorPool) checkHealth() {
+	now := time.Now()
+
+	for _, m := range p.members {
+		st := m.det.Status()
+
+		if conns := m.det.connections(); conns != m.conns {
+			// The member reconnected: whatever it had in flight is gone.
+			m.conns = conns
+			p.dropPending(m, func(pendingFrame) bool { return true })
+		}
+
+		if p.dropPending(m, func(f pendingFrame) bool { return now.Sub(f.sentAt) > poolFrameTimeout }) {
+			m.failures++
+		}
+
+		switch {
+		case st.State != StateConnected || m.failures >= poolMaxFailures:
+			if m.healthy {
+				log.Printf("Detector %s ejected from pool (%s)", m.det.serverURL, st.State)
+				m.healthy = false
+				m.ejectedAt = now
+			}
+		case !m.healthy && (m.ejectedAt.IsZero() || now.Sub(m.ejectedAt) >= poolReadmitDelay):
+			log.Printf("Detector %s admitted to pool", m.det.serverURL)
+			m.healthy = true
+			m.failures = 0
+		}
+	}
+}
+
+// dropPending gives up on the frames of m that match lost, failing those
+// not yet emitted, and reports whether there were any.
+func (p *DetectorPool) dropPending(m *poolMember, lost func(pendingFrame) bool) bool {
+	n := len(m.pending)
+	m.pending = slices.DeleteFunc(m.pending, func(f pendingFrame) bool {
+		if !lost(f) {
+			return false
+		}
+		if _, ok := p.inFlight[f.seq]; ok {
+			delete(p.inFlight, f.seq)
+			p.done[f.seq] = Result{FrameID: f.id}
+		}
+		return true
+	})
+	m.lost += uint64(n - len(m.pending))
+	return len(m.pending) < n
+}
+
+func (p *DetectorPool) publishStats() {
+	stats := make([]EndpointStats, len(p.members))
+	for i, m := range p.members {
+		stats[i] = EndpointStats{
+			URL:        m.det.serverURL,
+			State:      m.det.Status().State,
+			Healthy:    m.healthy,
+			InFlight:   len(m.pending),
+			Sent:       m.sent,
+			Received:   m.received,
+			Lost:       m.lost,
+			AvgLatency: m.latency,
+			Encode:     m.det.EncodeStats(),
+		}
+	}
+
+	p.mu.Lock()
+	p.stats = stats
+	p.mu.Unlock()
+}
@@ -0,0 +1,109 @@
+package processing
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+)
+
+func newTestPool(t *testing.T, servers ...*testServer) *DetectorPool {
+	t.Helper()
+
+	cfg := config.DetectorConfig{Balance: config.BalanceRoundRobin}
+	for _, s := range servers {
+		cfg.Endpoints = append(cfg.Endpoints, s.host())
+	}
+
+	p, err := NewDetectorPool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	t.Cleanup(p.Stop)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Status().State != StateConnected {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool state is %s, want %s", p.Status().State, StateConnected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return p
+}
+
+// runPool submits n frames every gap and returns their results in the order
+// the pool emitted them.
+func runPool(t *testing.T, p *DetectorPool, n int, gap time.Duration, timeout time.Duration) []Result {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	go func() {
+		for i := range uint64(n) {
+			p.Input() <- Frame{ID: i, Image: img}
+			time.Sleep(gap)
+		}
+	}()
+
+	var results []Result
+	deadline := time.After(timeout)
+	for len(results) < n {
+		select {
+		case res := <-p.Output():
+			results = append(results, res)
+		case <-deadline:
+			t.Fatalf("got %d of %d results: %+v", len(results), n, results)
+		}
+	}
+	return results
+}
+
+func checkOrder(t *testing.T, results []Result) (failed int) {
+	t.Helper()
+	for i, res := range results {
+		if res.FrameID != uint64(i) {
+			t.Fatalf("result %d is for frame %d", i, res.FrameID)
+		}
+		if res.Detections == nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+func TestDetectorPoolOrder(t *testing.T) {
+	// Results from the fast member overtake those of the slow one.
+	p := newTestPool(t, newTestServer(t, answersSlowly), newTestServer(t, answersHello))
+
+	results := runPool(t, p, 20, slowReply*3/2, 5*time.Second)
+	if failed := checkOrder(t, results); failed != 0 {
+		t.Errorf("%d frames failed", failed)
+	}
+}
+
+func TestDetectorPoolWorkerDies(t *testing.T) {
+	p := newTestPool(t, newTestServer(t, dropsOnFrame), newTestServer(t, answersHello))
+
+	results := runPool(t, p, 10, 20*time.Millisecond, 5*time.Second)
+	if failed := checkOrder(t, results); failed == 0 {
+		t.Error("no failed result for the frame the dying member took")
+	}
+
+	var lost uint64
+	for _, s := range p.EndpointStats() {
+		lost += s.Lost
+	}
+	if lost == 0 {
+		t.Error("no frame counted as lost")
+	}
+}
+
+func TestDetectorPoolTimeout(t *testing.T) {
+	p := newTestPool(t, newTestServer(t, ignoresFrames))
+
+	results := runPool(t, p, 1, 0, poolFrameTimeout+2*time.Second)
+	if failed := checkOrder(t, results); failed != 1 {
+		t.Errorf("result %+v, want a failed one", results[0])
+	}
+}
@@ -0,0 +1,183 @@
+package processing
+
+import (
+	"slices"
+	"sort"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// PostProcessor is one step of the chain run over every frame's detections.
+// Steps return a new slice and leave their input untouched.
+type PostProcessor interface {
+	Process(dets []models.DetectionResult) []models.DetectionResult
+}
+
+// PostProcessChain runs its steps in order.
+type PostProcessChain []PostProcessor
+
+func (c PostProcessChain) Process(dets []models.DetectionResult) []models.DetectionResult {
+	for _, step := range c {
+		dets = step.Process(dets)
+	}
+	return dets
+}
+
+// NewPostProcessChain builds the configured chain. Boxes are clipped first so
+// the area filter and NMS see what will be drawn, and NMS runs last so that
+// only boxes that survived the filters can suppress others.
+func NewPostProcessChain(cfg config.PostProcessConfig) PostProcessChain {
+	var chain PostProcessChain
+
+	if cfg.Clip {
+		chain = append(chain, ClipBoxes{})
+	}
+	if len(cfg.Allow) > 0 || len(cfg.Deny) > 0 {
+		chain = append(chain, ClassFilter{Allow: cfg.Allow, Deny: cfg.Deny})
+	}
+	if cfg.MinScore > 0 || len(cfg.ClassMinScore) > 0 {
+		chain = append(chain, ScoreThreshold{Default: cfg.MinScore, PerClass: cfg.ClassMinScore})
+	}
+	if cfg.MinArea > 0 || cfg.MaxArea > 0 {
+		chain = append(chain, AreaFilter{Min: cfg.MinArea, Max: cfg.MaxArea})
+	}
+
+	switch cfg.NMS {
+	case config.NMSPerClass:
+		chain = append(chain, NMS{IoU: cfg.NMSIoU})
+	case config.NMSAgnostic:
+		chain = append(chain, NMS{IoU: cfg.NMSIoU, ClassAgnostic: true})
+	}
+
+	return chain
+}
+
+// keep returns the detections accepted by ok. The result is never nil, as a
+// nil list stands for a frame the detector failed on.
+func keep(dets []models.DetectionResult, ok func(models.DetectionResult) bool) []models.DetectionResult {
+	out := make([]models.DetectionResult, 0, len(dets))
+	for _, d := range dets {
+		if ok(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// ClipBoxes limits boxes to the frame and drops those left empty. Detections
+// without a complete box are dropped too.
+type ClipBoxes struct{}
+
+func (ClipBoxes) Process(dets []models.DetectionResult) []models.DetectionResult {
+	out := make([]models.DetectionResult, 0, len(dets))
+	for _, d := range dets {
+		if len(d.Box) < 4 {
+			continue
+		}
+
+		b := boxOf(d)
+		for i := range b {
+			b[i] = min(max(b[i], 0), 1)
+		}
+		if b[2] <= b[0] || b[3] <= b[1] {
+			continue
+		}
+
+		d.Box = b[:]
+		out = append(out, d)
+	}
+	return out
+}
+
+type ClassFilter struct {
+	Allow []string
+	Deny  []string
+}
+
+func (f ClassFilter) Process(dets []models.DetectionResult) []models.DetectionResult {
+	return keep(dets, func(d models.DetectionResult) bool {
+		if len(f.Allow) > 0 && !slices.Contains(f.Allow, d.Class) {
+			return false
+		}
+		return !slices.Contains(f.Deny, d.Class)
+	})
+}
+
+type ScoreThreshold struct {
+	Default  float32
+	PerClass map[string]float32
+}
+
+func (t ScoreThreshold) Process(dets []models.DetectionResult) []models.DetectionResult {
+	return keep(dets, func(d models.DetectionResult) bool {
+		threshold, ok := t.PerClass[d.Class]
+		if !ok {
+			threshold = t.Default
+		}
+		return d.Score >= threshold
+	})
+}
+
+// AreaFilter keeps boxes covering between Min and Max of the frame. A zero
+// Max means no upper limit.
+type AreaFilter struct {
+	Min float32
+	Max float32
+}
+
+func (f AreaFilter) Process(dets []models.DetectionResult) []models.DetectionResult {
+	return keep(dets, func(d models.DetectionResult) bool {
+		if len(d.Box) < 4 {
+			return false
+		}
+
+		area := (d.Box[2] - d.Box[0]) * (d.Box[3] - d.Box[1])
+		return area >= f.Min && (f.Max <= 0 || area <= f.Max)
+	})
+}
+
+// NMS suppresses boxes overlapping a higher-scoring box by more than IoU,
+// either within each class or across all classes.
+type NMS struct {
+	IoU           float32
+	ClassAgnostic bool
+}
+
+func (n NMS) Process(dets []models.DetectionResult) []models.DetectionResult {
+	order := make([]int, 0, len(dets))
+	for i, d := range dets {
+		if len(d.Box) >= 4 {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return dets[order[i]].Score > dets[order[j]].Score
+	})
+
+	var kept []int
+	for _, i := range order {
+		suppressed := false
+		for _, k := range kept {
+			if !n.ClassAgnostic && dets[k].Class != dets[i].Class {
+				continue
+			}
+			if boxIoU(boxOf(dets[k]), boxOf(dets[i])) > n.IoU {
+				suppressed = true
+				break
+			}
+		}
+		if !suppressed {
+			kept = append(kept, i)
+		}
+	}
+
+	// Keep the server's ordering of the survivors.
+	sort.Ints(kept)
+
+	out := make([]models.DetectionResult, 0, len(kept))
+	for _, i := range kept {
+		out = append(out, dets[i])
+	}
+	return out
+}
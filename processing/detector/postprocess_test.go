@@ -0,0 +1,154 @@
+package processing
+
+import (
+	"slices"
+	"testing"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+func det(class string, score float32, box ...float32) models.DetectionResult {
+	return models.DetectionResult{Class: class, Score: score, Box: box}
+}
+
+func classes(dets []models.DetectionResult) []string {
+	var out []string
+	for _, d := range dets {
+		out = append(out, d.Class)
+	}
+	return out
+}
+
+func TestPostProcessSteps(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		step PostProcessor
+		in   []models.DetectionResult
+		want []string
+	}{
+		{
+			name: "clip",
+			step: ClipBoxes{},
+			in: []models.DetectionResult{
+				det("inside", 1, 0.1, 0.1, 0.5, 0.5),
+				det("overhang", 1, -0.2, 0.5, 0.5, 1.3),
+				det("outside", 1, 1.1, 0.1, 1.5, 0.5),
+				det("no box", 1),
+			},
+			want: []string{"inside", "overhang"},
+		},
+		{
+			name: "allow and deny",
+			step: ClassFilter{Allow: []string{"car", "person"}, Deny: []string{"person"}},
+			in:   []models.DetectionResult{det("car", 1), det("person", 1), det("dog", 1)},
+			want: []string{"car"},
+		},
+		{
+			name: "deny only",
+			step: ClassFilter{Deny: []string{"dog"}},
+			in:   []models.DetectionResult{det("car", 1), det("dog", 1)},
+			want: []string{"car"},
+		},
+		{
+			name: "score per class",
+			step: ScoreThreshold{Default: 0.5, PerClass: map[string]float32{"car": 0.8}},
+			in:   []models.DetectionResult{det("car", 0.7), det("car", 0.8), det("dog", 0.5), det("dog", 0.4)},
+			want: []string{"car", "dog"},
+		},
+		{
+			name: "area",
+			step: AreaFilter{Min: 0.01, Max: 0.5},
+			in: []models.DetectionResult{
+				det("tiny", 1, 0, 0, 0.05, 0.05),
+				det("small", 1, 0, 0, 0.1, 0.1),
+				det("huge", 1, 0, 0, 0.9, 0.9),
+				det("no box", 1),
+			},
+			want: []string{"small"},
+		},
+		{
+			name: "area without maximum",
+			step: AreaFilter{Min: 0.01},
+			in:   []models.DetectionResult{det("tiny", 1, 0, 0, 0.05, 0.05), det("huge", 1, 0, 0, 0.9, 0.9)},
+			want: []string{"huge"},
+		},
+		{
+			name: "nms per class",
+			step: NMS{IoU: 0.5},
+			in: []models.DetectionResult{
+				det("car", 0.6, 0, 0, 0.5, 0.5),
+				det("car", 0.9, 0, 0, 0.5, 0.55),
+				det("person", 0.5, 0, 0, 0.5, 0.5),
+				det("car", 0.7, 0.6, 0.6, 0.9, 0.9),
+			},
+			want: []string{"car", "person", "car"},
+		},
+		{
+			name: "nms across classes",
+			step: NMS{IoU: 0.5, ClassAgnostic: true},
+			in: []models.DetectionResult{
+				det("car", 0.6, 0, 0, 0.5, 0.5),
+				det("truck", 0.9, 0, 0, 0.5, 0.55),
+				det("person", 0.5, 0, 0.3, 0.5, 0.8),
+			},
+			want: []string{"truck", "person"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in := slices.Clone(tc.in)
+			out := tc.step.Process(in)
+
+			if got := classes(out); !slices.Equal(got, tc.want) {
+				t.Errorf("kept %v, want %v", got, tc.want)
+			}
+			if out == nil {
+				t.Error("nil result would read as a failed frame")
+			}
+			for i := range in {
+				if !slices.Equal(in[i].Box, tc.in[i].Box) {
+					t.Errorf("input box %d modified", i)
+				}
+			}
+		})
+	}
+}
+
+func TestClipBoxes(t *testing.T) {
+	in := []models.DetectionResult{det("car", 1, -0.2, 0.5, 0.5, 1.3)}
+	out := ClipBoxes{}.Process(in)
+
+	if !slices.Equal(out[0].Box, []float32{0, 0.5, 0.5, 1}) {
+		t.Errorf("clipped box %v", out[0].Box)
+	}
+	if in[0].Box[0] != -0.2 {
+		t.Error("input box modified")
+	}
+}
+
+func TestPostProcessChain(t *testing.T) {
+	chain := NewPostProcessChain(config.PostProcessConfig{
+		Clip:     true,
+		Deny:     []string{"dog"},
+		MinScore: 0.5,
+		MinArea:  0.05,
+		NMS:      config.NMSPerClass,
+		NMSIoU:   0.5,
+	})
+
+	out := chain.Process([]models.DetectionResult{
+		// Clipped down to a sliver, so below MinArea.
+		det("car", 0.9, 0.9, 0.9, 1.5, 1.5),
+		det("car", 0.8, 0.1, 0.1, 0.5, 0.5),
+		// Would suppress the car above if the score filter ran after NMS.
+		det("car", 0.4, 0.1, 0.1, 0.5, 0.55),
+		det("dog", 0.9, 0.1, 0.1, 0.5, 0.5),
+	})
+	if len(out) != 1 || out[0].Score != 0.8 {
+		t.Errorf("chain kept %+v", out)
+	}
+
+	if out := NewPostProcessChain(config.PostProcessConfig{}).Process([]models.DetectionResult{}); out == nil {
+		t.Error("empty chain turned no detections into a failed frame")
+	}
+}
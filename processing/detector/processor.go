@@ -1,10 +1,12 @@
 package processing
 
 import (
+	"context"
+	"errors"
 	"image"
 	"log"
+	"reflect"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"vision/internal/config"
@@ -12,134 +14,569 @@ import (
 	stream "vision/processing/capture"
 )
 
-type Processor struct {
-	InImageStream stream.VideoStreamer
+type ProcessorState int
+
+const (
+	ProcessorIdle ProcessorState = iota
+	ProcessorRunning
+	ProcessorStopping
+)
+
+func (s ProcessorState) String() string {
+	switch s {
+	case ProcessorRunning:
+		return "Running"
+	case ProcessorStopping:
+		return "Stopping"
+	default:
+		return "Idle"
+	}
+}
 
-	OutImageStream      chan image.Image
-	OutDetectionsStream chan []models.DetectionResult
+var ErrProcessorRunning = errors.New("processor is already running")
 
-	ErrChan  chan error
-	StopChan chan struct{}
+// ProcessorStats is a snapshot of a run. Err is the reason the last run ended
+// on its own, such as the end of a video file.
+type ProcessorStats struct {
+	State     ProcessorState
+	StartedAt time.Time
 
-	Latency          time.Duration
-	FPS              uint64
-	IsActive         bool
-	IsStreamerActive bool
+	FPS     uint64
+	Latency time.Duration
+	Frames  uint64
+	Results uint64
 
+	// SyncDelay is how long the last synced frame waited for its results,
+	// Missed how many synced frames were shown without them.
+	SyncDelay time.Duration
+	Missed    uint64
+
+	Window WindowStats
+	Err    error
+}
+
+// Processor feeds frames from a streamer to the detector and passes frames
+// and results on for display. Each Start begins a run that owns the streamer
+// until Stop, the parent context or the end of the stream finishes it; the
+// processor then returns to Idle and can be started again.
+type Processor struct {
 	cfg *config.Config
-	det *RemoteDetector
+	det Detector
+
+	frames     chan image.Image
+	detections chan []models.DetectionResult
+	synced     chan AnnotatedFrame
+	alerts     chan Alert
+	errs       chan error
+
+	window   *frameWindow
+	slotFree chan struct{}
+	held     frameSync
 
-	pendingTimestamps chan time.Time
-	mu                sync.RWMutex
+	// nextID numbers frames across runs, so late results from an earlier
+	// run can never be taken for a frame of this one. Only the frame loop
+	// touches it.
+	nextID uint64
+
+	sinkMu sync.RWMutex
+	sinks  []Sink
+
+	mu        sync.Mutex
+	state     ProcessorState
+	cancel    context.CancelFunc
+	done      chan struct{}
+	startedAt time.Time
+	source    string
+	fps       uint64
+	latency   time.Duration
+	nFrames   uint64
+	nResults  uint64
+	syncDelay time.Duration
+	nMissed   uint64
+	counts    ZoneCounts
+	lastErr   error
 }
 
-func NewProcessor(cfg *config.Config, det *RemoteDetector) *Processor {
+func NewProcessor(cfg *config.Config, det Detector) *Processor {
+	done := make(chan struct{})
+	close(done)
+
 	return &Processor{
-		cfg:                 cfg,
-		det:                 det,
-		ErrChan:             make(chan error, 1),
-		StopChan:            make(chan struct{}),
-		OutImageStream:      make(chan image.Image, cfg.GetFPS()),
-		OutDetectionsStream: make(chan []models.DetectionResult, cfg.GetFPS()),
-		pendingTimestamps:   make(chan time.Time, cfg.GetFPS()*2),
+		cfg:        cfg,
+		det:        det,
+		frames:     make(chan image.Image, cfg.GetFPS()),
+		detections: make(chan []models.DetectionResult, cfg.GetFPS()),
+		synced:     make(chan AnnotatedFrame, cfg.GetFPS()),
+		alerts:     make(chan Alert, 16),
+		errs:       make(chan error, 1),
+		window:     newFrameWindow(cfg.GetDetectorConfig().MaxInFlight),
+		slotFree:   make(chan struct{}, 1),
+		done:       done,
 	}
 }
 
-func (p *Processor) Start() {
+// Start starts src and begins a run. It fails if a run is already active.
+func (p *Processor) Start(ctx context.Context, src stream.VideoStreamer) error {
 	p.mu.Lock()
-	p.StopChan = make(chan struct{})
-	p.IsActive = true
-	p.mu.Unlock()
+	defer p.mu.Unlock()
+
+	if p.state != ProcessorIdle {
+		return ErrProcessorRunning
+	}
+
+	if err := src.Start(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	p.state = ProcessorRunning
+	p.cancel = cancel
+	p.done = done
+	p.startedAt = time.Now()
+	p.source = p.cfg.SourceName()
+	p.fps, p.latency, p.nFrames, p.nResults = 0, 0, 0, 0
+	p.syncDelay, p.nMissed = 0, 0
+	p.counts = ZoneCounts{}
+	p.lastErr = nil
+
+	p.window.Reset()
+	p.held.Reset()
+	p.drainStale()
 
-	go p.processResultsLoop()
+	var wg sync.WaitGroup
+	wg.Add(2)
 
 	go func() {
-		p.IsActive = true
-		var frameCount uint64 = 0
-		lastFpsUpdate := time.Now()
+		defer wg.Done()
+		defer cancel()
+		p.frameLoop(ctx, src)
+	}()
 
-		for {
-			select {
-			case frame, ok := <-p.InImageStream.FrameChan():
-				if !ok {
-					p.Stop()
-					return
-				}
-				if frame == nil {
-					continue
-				}
+	go func() {
+		defer wg.Done()
+		p.resultsLoop(ctx)
+	}()
 
-				select {
-				case p.det.InputFrames <- frame:
-					select {
-					case p.pendingTimestamps <- time.Now():
-					default:
-					}
-				default:
-				}
+	go func() {
+		<-ctx.Done()
+		p.setState(ProcessorStopping)
+
+		wg.Wait()
+		src.Stop()
+
+		p.mu.Lock()
+		p.state = ProcessorIdle
+		p.cancel = nil
+		p.mu.Unlock()
+
+		close(done)
+	}()
+
+	return nil
+}
+
+// Stop ends the current run, stops its streamer and waits until every
+// goroutine of the run has exited. It is a no-op when idle.
+func (p *Processor) Stop() {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	<-done
+}
+
+// Done is closed when the current or most recent run has ended.
+func (p *Processor) Done() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done
+}
+
+func (p *Processor) State() ProcessorState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+func (p *Processor) IsRunning() bool {
+	return p.State() == ProcessorRunning
+}
+
+func (p *Processor) Stats() ProcessorStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return ProcessorStats{
+		State:     p.state,
+		StartedAt: p.startedAt,
+		FPS:       p.fps,
+		Latency:   p.latency,
+		Frames:    p.nFrames,
+		Results:   p.nResults,
+		SyncDelay: p.syncDelay,
+		Missed:    p.nMissed,
+		Window:    p.window.Stats(),
+		Err:       p.lastErr,
+	}
+}
+
+// Counts returns the zone occupancy for the newest results and the line
+// crossings of the current or most recent run.
+func (p *Processor) Counts() ZoneCounts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.counts
+}
+
+// Frames carries every frame read from the streamer, Detections the results
+// for the frames the detector got. Both outlive runs and drop values when
+// nobody reads them.
+func (p *Processor) Frames() <-chan image.Image {
+	return p.frames
+}
+
+func (p *Processor) Detections() <-chan []models.DetectionResult {
+	return p.detections
+}
+
+// Synced carries frames paired with their own detections while the sync mode
+// is config.SyncExact, in capture order. Frames the detector never got are
+// left out.
+func (p *Processor) Synced() <-chan AnnotatedFrame {
+	return p.synced
+}
+
+// Alerts carries the alerts fired by the configured rules. Alerts are dropped
+// when nobody reads them.
+func (p *Processor) Alerts() <-chan Alert {
+	return p.alerts
+}
+
+// Errors reports errors from the detector, such as frames the server failed
+// to process. Only the most recent unread one is kept.
+func (p *Processor) Errors() <-chan error {
+	return p.errs
+}
+
+func (p *Processor) setState(s ProcessorState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == ProcessorRunning {
+		p.state = s
+	}
+}
+
+// drainStale drops results left over from a previous run.
+func (p *Processor) drainStale() {
+	for {
+		select {
+		case <-p.det.Output():
+		default:
+			return
+		}
+	}
+}
+
+func (p *Processor) frameLoop(ctx context.Context, src stream.VideoStreamer) {
+	var frameCount uint64
+	lastFpsUpdate := time.Now()
 
+	// pending is the newest frame not yet handed to the detector. It is
+	// replaced whenever a newer frame arrives before a slot frees up.
+	var pending image.Image
+	var pendingID uint64
+
+	expireTicker := time.NewTicker(500 * time.Millisecond)
+	defer expireTicker.Stop()
+
+	errc := src.ErrorChan()
+
+	for {
+		select {
+		case frame, ok := <-src.FrameChan():
+			if !ok {
+				// A streamer may close its frames right after reporting
+				// why, so pick the reason up before leaving.
 				select {
-				case p.OutImageStream <- frame:
+				case err := <-errc:
+					p.streamEnded(err)
 				default:
 				}
+				return
+			}
+			if frame == nil {
+				continue
+			}
 
-				frameCount++
-				if time.Since(lastFpsUpdate) >= time.Second {
-					atomic.StoreUint64(&p.FPS, frameCount)
-					frameCount = 0
-					lastFpsUpdate = time.Now()
-				}
+			if pending != nil {
+				p.window.Dropped()
+			}
+			pendingID = p.nextID
+			p.nextID++
+			pending = p.submit(pendingID, frame)
 
-			case err := <-p.InImageStream.ErrorChan():
-				log.Print("Streamer error:", err)
-				p.Stop()
-				return
+			select {
+			case p.frames <- frame:
+			default:
+			}
+			p.dispatchFrame(pendingID, frame)
 
-			case <-p.StopChan:
-				return
+			frameCount++
+			p.mu.Lock()
+			p.nFrames++
+			if time.Since(lastFpsUpdate) >= time.Second {
+				p.fps = frameCount
+				frameCount = 0
+				lastFpsUpdate = time.Now()
+			}
+			p.mu.Unlock()
+
+		case <-p.slotFree:
+			pending = p.submit(pendingID, pending)
+
+		case <-expireTicker.C:
+			if p.window.Expire(time.Now()) > 0 {
+				pending = p.submit(pendingID, pending)
+			}
+
+		case err, ok := <-errc:
+			if !ok {
+				errc = nil
+				continue
 			}
+			p.streamEnded(err)
+			return
+
+		case <-ctx.Done():
+			return
 		}
-	}()
+	}
+}
+
+func (p *Processor) streamEnded(err error) {
+	if err == nil {
+		return
+	}
+
+	log.Print("Streamer error:", err)
+	p.mu.Lock()
+	p.lastErr = err
+	p.mu.Unlock()
+}
+
+// submit hands frame to the detector if the in-flight window has room and
+// returns it back otherwise, so the caller keeps it as the pending frame.
+func (p *Processor) submit(id uint64, frame image.Image) image.Image {
+	if frame == nil || !p.window.HasSlot() {
+		return frame
+	}
+
+	// Frames are held until their results arrive, for the synced overlay
+	// and for alert snapshots.
+	now := time.Now()
+	p.window.Sent(id, now)
+	p.held.Hold(id, frame, now)
+
+	select {
+	case p.det.Input() <- Frame{ID: id, Image: frame}:
+		return nil
+	default:
+		p.window.Unsend(id)
+		p.held.Drop(id)
+		return frame
+	}
 }
 
-func (p *Processor) processResultsLoop() {
+func (p *Processor) resultsLoop(ctx context.Context) {
+	syncTicker := time.NewTicker(50 * time.Millisecond)
+	defer syncTicker.Stop()
+
+	// The tracker starts afresh with every run and whenever tracking is
+	// switched back on, since its tracks would be stale by then.
+	var tracker *Tracker
+	postCfg := p.cfg.GetPostProcess()
+	post := NewPostProcessChain(postCfg)
+	zones := NewZoneCounter(p.cfg.GetZones())
+	rules := NewRuleEngine(p.cfg.GetAlerts().Rules, p.cfg.GetZones())
+
 	for {
 		select {
-		case results, ok := <-p.det.OutputResult:
+		case res, ok := <-p.det.Output():
 			if !ok {
 				return
 			}
 
-			var startTime time.Time
-			select {
-			case startTime = <-p.pendingTimestamps:
+			// A result for a frame no longer in flight belongs to a frame
+			// that expired or to an earlier run.
+			now := time.Now()
+			id := res.FrameID
+			rtt, ok := p.window.Complete(id, now)
+			if !ok {
+				continue
+			}
+
+			results := res.Detections
+			if results != nil {
+				if cfg := p.cfg.GetPostProcess(); !reflect.DeepEqual(cfg, postCfg) {
+					postCfg, post = cfg, NewPostProcessChain(cfg)
+				}
+				results = post.Process(results)
+			}
+
+			if tc := p.cfg.GetTracking(); !tc.Enabled {
+				tracker = nil
+			} else if results != nil {
+				if tracker == nil {
+					tracker = NewTracker(tc)
+				}
+				results = tracker.Update(results)
+			}
+
+			if results != nil {
+				zones.SetConfig(p.cfg.GetZones())
+				zones.Update(results)
+			}
+
+			p.mu.Lock()
+			p.nResults++
+			p.latency = rtt
+			if results != nil {
+				p.counts = zones.Counts()
+			}
+			p.mu.Unlock()
+
+			frame, released := p.held.Resolve(id, results, now)
+			p.emitSynced(released)
+
+			if results != nil {
+
 				p.mu.Lock()
-				p.Latency = time.Since(startTime)
+				source, started := p.source, p.startedAt
 				p.mu.Unlock()
+
+				p.dispatchResults(FrameResult{
+					ID:         id,
+					At:         now,
+					Source:     source,
+					RunStarted: started,
+					Frame:      frame,
+					Detections: results,
+					Counts:     zones.Counts(),
+				})
+
+				rules.SetConfig(p.cfg.GetAlerts().Rules, p.cfg.GetZones())
+				p.emitAlerts(rules.Evaluate(now, id, frame, results))
+			}
+
+			select {
+			case p.slotFree <- struct{}{}:
+			default:
+			}
+
+			select {
+			case p.detections <- results:
 			default:
 			}
 
+		case now := <-syncTicker.C:
+			maxDelay := time.Duration(p.cfg.GetSync().MaxDelayMs) * time.Millisecond
+			if maxDelay <= 0 {
+				maxDelay = defaultSyncMaxDelay
+			}
+			p.emitSynced(p.held.Expire(now, maxDelay))
+
+		case err := <-p.det.Errors():
+			// Replace an unread error, so the newest one is kept.
+			select {
+			case <-p.errs:
+			default:
+			}
 			select {
-			case p.OutDetectionsStream <- results:
+			case p.errs <- err:
 			default:
 			}
 
-		case <-p.StopChan:
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (p *Processor) Stop() {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if !p.IsActive {
+// emitSynced publishes frames released by the frame sync. They are only
+// passed on while the overlay is synced.
+func (p *Processor) emitSynced(frames []AnnotatedFrame) {
+	if len(frames) == 0 || p.cfg.GetSync().Mode != config.SyncExact {
 		return
 	}
 
-	close(p.StopChan)
+	for _, f := range frames {
+		p.mu.Lock()
+		p.syncDelay = f.Delay
+		if f.Missed {
+			p.nMissed++
+		}
+		p.mu.Unlock()
+
+		select {
+		case p.synced <- f:
+		default:
+		}
+	}
+}
+
+func (p *Processor) emitAlerts(alerts []Alert) {
+	for _, a := range alerts {
+		p.dispatchAlert(a)
+
+		select {
+		case p.alerts <- a:
+		default:
+		}
+	}
+}
+
+func (p *Processor) WindowStats() WindowStats {
+	return p.window.Stats()
+}
+
+func (p *Processor) DetectorStatus() ConnStatus {
+	return p.det.Status()
+}
+
+// UpdateSession forwards new session parameters to the detector. Detectors
+// without a configurable server session ignore them.
+func (p *Processor) UpdateSession(s config.SessionConfig) {
+	if sd, ok := detectorAs[SessionDetector](p.det); ok {
+		sd.UpdateSession(s)
+	}
+}
+
+func (p *Processor) Capabilities() (Capabilities, bool) {
+	if sd, ok := detectorAs[SessionDetector](p.det); ok {
+		return sd.Capabilities(), true
+	}
+	return Capabilities{}, false
+}
+
+// DetectorStats returns per-endpoint statistics when the detector is a pool,
+// and nil otherwise.
+func (p *Processor) DetectorStats() []EndpointStats {
+	if pool, ok := detectorAs[*DetectorPool](p.det); ok {
+		return pool.EndpointStats()
+	}
+	return nil
+}
 
-	p.IsActive = false
-	p.IsStreamerActive = false
+// EncodeStats returns upload metrics for detectors that send frames over the
+// network. The second value is false for in-process detectors.
+func (p *Processor) EncodeStats() (EncodeStats, bool) {
+	if r, ok := detectorAs[interface{ EncodeStats() EncodeStats }](p.det); ok {
+		return r.EncodeStats(), true
+	}
+	return EncodeStats{}, false
 }
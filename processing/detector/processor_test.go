@@ -0,0 +1,331 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// fakeStreamer produces small frames until stopped.
+type fakeStreamer struct {
+	frames chan image.Image
+	errs   chan error
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newFakeStreamer() *fakeStreamer {
+	return &fakeStreamer{
+		frames: make(chan image.Image, 4),
+		errs:   make(chan error, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (s *fakeStreamer) Start() error {
+	go func() {
+		defer close(s.done)
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		for {
+			select {
+			case s.frames <- img:
+			case <-s.stop:
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	return nil
+}
+
+func (s *fakeStreamer) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *fakeStreamer) FrameChan() <-chan image.Image { return s.frames }
+func (s *fakeStreamer) ErrorChan() <-chan error       { return s.errs }
+
+// echoDetector answers every frame with an empty result and every fourth
+// with an error as well.
+type echoDetector struct {
+	input  chan Frame
+	output chan Result
+	errs   chan error
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newEchoDetector() *echoDetector {
+	return &echoDetector{
+		input:  make(chan Frame, 5),
+		output: make(chan Result, 5),
+		errs:   make(chan error, 1),
+		stop:   make(chan struct{}),
+	}
+}
+
+func (d *echoDetector) Start() {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for {
+			select {
+			case f := <-d.input:
+				if f.ID%4 == 0 {
+					select {
+					case d.errs <- errors.New("injected"):
+					default:
+					}
+				}
+				select {
+				case d.output <- Result{FrameID: f.ID}:
+				case <-d.stop:
+					return
+				}
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (d *echoDetector) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+func (d *echoDetector) Input() chan<- Frame   { return d.input }
+func (d *echoDetector) Output() <-chan Result { return d.output }
+func (d *echoDetector) Errors() <-chan error  { return d.errs }
+func (d *echoDetector) Status() ConnStatus    { return ConnStatus{State: StateConnected} }
+
+func TestProcessorConcurrentControl(t *testing.T) {
+	det := newEchoDetector()
+	det.Start()
+	defer det.Stop()
+
+	p := NewProcessor(config.NewDefaultConfig(), det)
+
+	// Drain the outputs like the UI does.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case <-p.Frames():
+			case <-p.Detections():
+			case <-p.Synced():
+			case <-p.Errors():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				err := p.Start(ctx, newFakeStreamer())
+				if err != nil && !errors.Is(err, ErrProcessorRunning) {
+					t.Error(err)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				p.Stop()
+				p.Stop()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				st := p.Stats()
+				if st.State < ProcessorIdle || st.State > ProcessorStopping {
+					t.Errorf("bad state %v", st.State)
+				}
+				_ = p.State()
+				_ = p.Counts()
+				time.Sleep(100 * time.Microsecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	p.Stop()
+	if st := p.State(); st != ProcessorIdle {
+		t.Fatalf("state after Stop is %s", st)
+	}
+	select {
+	case <-p.Done():
+	default:
+		t.Fatal("Done not closed after Stop")
+	}
+
+	// A stopped processor starts again and produces results.
+	if err := p.Start(ctx, newFakeStreamer()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Stats().Results == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no results after restart")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// swapDetector answers frames in pairs, the second one first, with one
+// detection each. A frame left without a partner is answered after a while.
+type swapDetector struct {
+	*echoDetector
+}
+
+func newSwapDetector() *swapDetector {
+	d := &swapDetector{newEchoDetector()}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		answer := func(f Frame) bool {
+			select {
+			case d.output <- Result{FrameID: f.ID, Detections: []models.DetectionResult{{Class: "swap", Score: 0.9, Box: []float32{0.1, 0.1, 0.5, 0.5}}}}:
+				return true
+			case <-d.stop:
+				return false
+			}
+		}
+
+		var held *Frame
+		for {
+			select {
+			case f := <-d.input:
+				if held == nil {
+					held = &f
+					continue
+				}
+				if !answer(f) || !answer(*held) {
+					return
+				}
+				held = nil
+			case <-time.After(20 * time.Millisecond):
+				if held != nil && !answer(*held) {
+					return
+				}
+				held = nil
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+	return d
+}
+
+func TestProcessorSyncedOutOfOrder(t *testing.T) {
+	det := newSwapDetector()
+	defer det.Stop()
+
+	cfg := config.NewDefaultConfig()
+	cfg.SetSync(config.SyncConfig{Mode: config.SyncExact, MaxDelayMs: 2000})
+	p := NewProcessor(cfg, det)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := p.Start(ctx, newFakeStreamer()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	go func() {
+		for {
+			select {
+			case <-p.Frames():
+			case <-p.Detections():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var last uint64
+	timeout := time.After(5 * time.Second)
+	for n := 0; n < 20; n++ {
+		select {
+		case f := <-p.Synced():
+			if n > 0 && f.ID <= last {
+				t.Fatalf("frame %d released after frame %d", f.ID, last)
+			}
+			last = f.ID
+			if f.Missed || len(f.Detections) != 1 || f.Frame == nil {
+				t.Fatalf("frame %d released without its own results: %+v", f.ID, f)
+			}
+		case <-timeout:
+			t.Fatalf("only %d synced frames", n)
+		}
+	}
+}
+
+func TestProcessorPostProcessChange(t *testing.T) {
+	det := newSwapDetector()
+	defer det.Stop()
+
+	cfg := config.NewDefaultConfig()
+	cfg.SetSync(config.SyncConfig{Mode: config.SyncExact, MaxDelayMs: 2000})
+	p := NewProcessor(cfg, det)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := p.Start(ctx, newFakeStreamer()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	go func() {
+		for {
+			select {
+			case <-p.Frames():
+			case <-p.Detections():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Wait until detections come through, then filter their class out.
+	want := 1
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case f := <-p.Synced():
+			if f.Missed || len(f.Detections) != want {
+				continue
+			}
+			if want == 0 {
+				return
+			}
+			pp := cfg.GetPostProcess()
+			pp.Deny = []string{"swap"}
+			cfg.SetPostProcess(pp)
+			want = 0
+		case <-timeout:
+			t.Fatalf("no frame with %d detections", want)
+		}
+	}
+}
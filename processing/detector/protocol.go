@@ -0,0 +1,152 @@
+package processing
+
+import (
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// Control messages are exchanged as JSON objects in text frames, while frames
+// travel as binary messages. Servers answer with a typed envelope (results,
+// error or status) or, for older servers, a plain JSON array of results.
+// Until the server answers the hello, frames are sent as JPEG, so servers
+// that ignore the handshake keep working.
+const (
+	msgHello        = "hello"
+	msgBatch        = "batch"
+	msgSession      = "session"
+	msgCapabilities = "capabilities"
+	msgResults      = "results"
+	msgError        = "error"
+	msgStatus       = "status"
+)
+
+const (
+	ServerReady      = "ready"
+	ServerOverloaded = "overloaded"
+	ServerReloading  = "reloading"
+)
+
+type envelope struct {
+	Type string `json:"type"`
+}
+
+// helloRequest is sent right after connecting. Encodings lists every format
+// the client can produce, most preferred first.
+type helloRequest struct {
+	Type      string                 `json:"type"`
+	Encodings []config.FrameEncoding `json:"encodings"`
+	Quality   int                    `json:"quality,omitempty"`
+	MaxBatch  int                    `json:"max_batch,omitempty"`
+}
+
+// helloResponse carries the server's choice. A zero quality keeps the client
+// setting and a zero MaxBatch means the server does not accept batches.
+type helloResponse struct {
+	Type     string               `json:"type"`
+	Encoding config.FrameEncoding `json:"encoding"`
+	Quality  int                  `json:"quality,omitempty"`
+	MaxBatch int                  `json:"max_batch,omitempty"`
+}
+
+// batchFrameMagic prefixes a batched upload: a big-endian uint32 frame count
+// followed, per frame, by a uint64 frame ID, a uint32 payload length and the
+// frame encoded with the negotiated encoding.
+var batchFrameMagic = [4]byte{'B', 'T', 'C', 'H'}
+
+type batchItem struct {
+	FrameID uint64                   `json:"frame_id"`
+	Results []models.DetectionResult `json:"results"`
+	Error   *errorMessage            `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Type    string      `json:"type"`
+	Results []batchItem `json:"results"`
+}
+
+type resultsMessage struct {
+	Type    string                   `json:"type"`
+	FrameID *uint64                  `json:"frame_id,omitempty"`
+	Results []models.DetectionResult `json:"results"`
+}
+
+// errorMessage is a server-side failure. With a frame ID it replaces the
+// results of that frame; without one it concerns the session as a whole.
+type errorMessage struct {
+	Type    string  `json:"type"`
+	FrameID *uint64 `json:"frame_id,omitempty"`
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+}
+
+type statusMessage struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type sessionMessage struct {
+	Type       string   `json:"type"`
+	Model      string   `json:"model,omitempty"`
+	Classes    []string `json:"classes,omitempty"`
+	Confidence float32  `json:"confidence"`
+	IoU        float32  `json:"iou"`
+}
+
+func newSessionMessage(s config.SessionConfig) sessionMessage {
+	return sessionMessage{
+		Type:       msgSession,
+		Model:      s.Model,
+		Classes:    s.Classes,
+		Confidence: s.Confidence,
+		IoU:        s.IoU,
+	}
+}
+
+// Capabilities lists what the server can run. Classes belong to the model
+// currently loaded for the session; the server resends them after a model
+// switch.
+type Capabilities struct {
+	Models  []string `json:"models"`
+	Model   string   `json:"model"`
+	Classes []string `json:"classes"`
+}
+
+type capabilitiesMessage struct {
+	Type string `json:"type"`
+	Capabilities
+}
+
+var supportedEncodings = []config.FrameEncoding{
+	config.EncodingJPEG,
+	config.EncodingPNG,
+	config.EncodingRaw,
+}
+
+func newHelloRequest(preferred config.EncodingConfig, batch config.BatchConfig) helloRequest {
+	encodings := []config.FrameEncoding{config.EncodingJPEG}
+	if preferred.Format != "" {
+		encodings[0] = preferred.Format
+	}
+
+	for _, e := range supportedEncodings {
+		if e != encodings[0] {
+			encodings = append(encodings, e)
+		}
+	}
+
+	req := helloRequest{Type: msgHello, Encodings: encodings, Quality: preferred.Quality}
+	if batch.Size > 1 {
+		req.MaxBatch = batch.Size
+	}
+	return req
+}
+
+func isSupportedEncoding(e config.FrameEncoding) bool {
+	for _, s := range supportedEncodings {
+		if s == e {
+			return true
+		}
+	}
+	return false
+}
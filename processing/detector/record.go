@@ -0,0 +1,210 @@
+package processing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"vision/internal/models"
+)
+
+// recordFrameTimeout is how long a frame waits for its results before it is
+// left out of the recording.
+const recordFrameTimeout = 30 * time.Second
+
+// recordEntry is one line of a recording: a submitted frame and the results
+// the detector returned for it. Results are nil when the detector failed on
+// the frame; frames that never got results are not recorded.
+type recordEntry struct {
+	Seq     uint64                   `json:"seq"`
+	Hash    string                   `json:"hash"`
+	Time    time.Time                `json:"time"`
+	Results []models.DetectionResult `json:"results"`
+}
+
+// RecordingDetector wraps another detector and writes every frame hash
+// together with the results it produced to a JSON Lines file, which
+// ReplayDetector can serve later without the original backend.
+type RecordingDetector struct {
+	inner Detector
+
+	input  chan Frame
+	output chan Result
+
+	file *os.File
+	enc  *json.Encoder
+	buf  *bufio.Writer
+
+	pendingMu sync.Mutex
+	pending   map[uint64]recordEntry
+	seq       uint64
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func NewRecordingDetector(inner Detector, path string) (*RecordingDetector, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	buf := bufio.NewWriter(f)
+
+	return &RecordingDetector{
+		inner:   inner,
+		input:   make(chan Frame, 5),
+		output:  make(chan Result, 5),
+		file:    f,
+		buf:     buf,
+		enc:     json.NewEncoder(buf),
+		pending: make(map[uint64]recordEntry),
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+func (r *RecordingDetector) Start() {
+	r.startOnce.Do(func() {
+		r.inner.Start()
+
+		r.wg.Add(2)
+		go func() {
+			defer r.wg.Done()
+			r.forwardFrames()
+		}()
+		go func() {
+			defer r.wg.Done()
+			r.recordResults()
+		}()
+	})
+}
+
+func (r *RecordingDetector) Stop() {
+	r.stopOnce.Do(func() {
+		r.cancel()
+		r.wg.Wait()
+		r.inner.Stop()
+
+		if err := r.buf.Flush(); err != nil {
+			log.Println("Recording flush error:", err)
+		}
+		r.file.Close()
+	})
+}
+
+func (r *RecordingDetector) Input() chan<- Frame {
+	return r.input
+}
+
+func (r *RecordingDetector) Output() <-chan Result {
+	return r.output
+}
+
+func (r *RecordingDetector) Errors() <-chan error {
+	return r.inner.Errors()
+}
+
+func (r *RecordingDetector) Status() ConnStatus {
+	return r.inner.Status()
+}
+
+func (r *RecordingDetector) Unwrap() Detector {
+	return r.inner
+}
+
+func (r *RecordingDetector) forwardFrames() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case f := <-r.input:
+			entry := recordEntry{Seq: r.seq, Hash: frameHash(f.Image), Time: time.Now()}
+			r.seq++
+
+			// Queue the entry first: the result may arrive before the send
+			// below returns.
+			r.pendingMu.Lock()
+			r.pending[f.ID] = entry
+			r.pendingMu.Unlock()
+
+			select {
+			case r.inner.Input() <- f:
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (r *RecordingDetector) recordResults() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case res := <-r.inner.Output():
+			now := time.Now()
+
+			r.pendingMu.Lock()
+			entry, ok := r.pending[res.FrameID]
+			delete(r.pending, res.FrameID)
+			for id, e := range r.pending {
+				if now.Sub(e.Time) > recordFrameTimeout {
+					delete(r.pending, id)
+				}
+			}
+			r.pendingMu.Unlock()
+
+			if ok {
+				entry.Time = now
+				entry.Results = res.Detections
+				if err := r.enc.Encode(entry); err != nil {
+					log.Println("Recording write error:", err)
+				}
+			}
+
+			select {
+			case r.output <- res:
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// frameHash identifies a frame by its size and pixels, so the same video
+// decoded again yields the same hashes.
+func frameHash(img image.Image) string {
+	h := fnv.New64a()
+	b := img.Bounds()
+	fmt.Fprintf(h, "%dx%d:", b.Dx(), b.Dy())
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			off := rgba.PixOffset(b.Min.X, y)
+			h.Write(rgba.Pix[off : off+b.Dx()*4])
+		}
+	} else {
+		px := make([]byte, 4)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				cr, cg, cb, ca := img.At(x, y).RGBA()
+				px[0], px[1], px[2], px[3] = uint8(cr>>8), uint8(cg>>8), uint8(cb>>8), uint8(ca>>8)
+				h.Write(px)
+			}
+		}
+	}
+
+	return fmt.Sprintf("%016x", h.Sum64())
+}
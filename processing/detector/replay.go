@@ -0,0 +1,122 @@
+package processing
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// replayAnalyzer serves results from a recording made by RecordingDetector.
+// In hash mode frames seen several times get their recorded results in turn;
+// in sequence mode the n-th frame gets the n-th recorded result regardless of
+// its content.
+type replayAnalyzer struct {
+	mode config.ReplayMode
+
+	entries []recordEntry
+	byHash  map[string][]int
+	bySeq   map[uint64]int
+	served  map[string]int
+	seq     int
+
+	errs chan error
+}
+
+func NewReplayDetector(cfg config.ReplayConfig) (*LocalDetector, error) {
+	entries, err := loadRecording(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = config.ReplayByHash
+	case config.ReplayByHash, config.ReplayBySequence:
+	default:
+		return nil, fmt.Errorf("unknown replay mode: %s", cfg.Mode)
+	}
+
+	ra := &replayAnalyzer{
+		mode:    cfg.Mode,
+		entries: entries,
+		byHash:  make(map[string][]int),
+		bySeq:   make(map[uint64]int),
+		served:  make(map[string]int),
+		errs:    make(chan error, 10),
+	}
+	for i, e := range entries {
+		ra.byHash[e.Hash] = append(ra.byHash[e.Hash], i)
+		ra.bySeq[e.Seq] = i
+	}
+
+	d := newLocalDetector(ra)
+	d.errs = ra.errs
+	return d, nil
+}
+
+func loadRecording(path string) ([]recordEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer f.Close()
+
+	var entries []recordEntry
+
+	// A recording cut short, e.g. by a crash, ends in a partial entry which
+	// is left out; anything else that doesn't parse is an error.
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var e recordEntry
+		err := dec.Decode(&e)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("Recording %s ends in a partial entry, replaying the %d before it", path, len(entries))
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recording %s after %d entries: %w", path, len(entries), err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func (ra *replayAnalyzer) Analyze(img image.Image) []models.DetectionResult {
+	seq := ra.seq
+	ra.seq++
+
+	if ra.mode == config.ReplayBySequence {
+		if i, ok := ra.bySeq[uint64(seq)]; ok {
+			return ra.entries[i].Results
+		}
+		ra.report(fmt.Errorf("replay: recording has no frame %d", seq))
+		return nil
+	}
+
+	hash := frameHash(img)
+	idx := ra.byHash[hash]
+	if len(idx) == 0 {
+		ra.report(fmt.Errorf("replay: frame %d (%s) was not recorded", seq, hash))
+		return nil
+	}
+
+	n := ra.served[hash]
+	ra.served[hash] = n + 1
+	return ra.entries[idx[min(n, len(idx)-1)]].Results
+}
+
+func (ra *replayAnalyzer) report(err error) {
+	select {
+	case ra.errs <- err:
+	default:
+	}
+}
@@ -0,0 +1,139 @@
+package processing
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+func writeRecording(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rec.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func entryLine(t *testing.T, seq uint64, img image.Image, results []models.DetectionResult) string {
+	t.Helper()
+	data, err := json.Marshal(recordEntry{Seq: seq, Hash: frameHash(img), Time: time.Now(), Results: results})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data) + "\n"
+}
+
+func solidFrame(v uint8) *image.RGBA {
+	return testFrame(8, 8, color.RGBA{v, v, v, 255})
+}
+
+func TestLoadRecording(t *testing.T) {
+	full := entryLine(t, 0, solidFrame(0), []models.DetectionResult{{Class: "a"}})
+	second := entryLine(t, 1, solidFrame(1), nil)
+
+	for _, tc := range []struct {
+		name    string
+		lines   []string
+		entries int
+		err     string
+	}{
+		{"complete", []string{full, second}, 2, ""},
+		{"empty", nil, 0, ""},
+		{"truncated", []string{full, second[:len(second)/2]}, 1, ""},
+		{"corrupt", []string{full, "{not json}\n", second}, 0, "after 1 entries"},
+		{"wrong type", []string{full, `{"seq":"one"}` + "\n"}, 0, "after 1 entries"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := loadRecording(writeRecording(t, tc.lines...))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("err = %v, want one mentioning %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tc.entries {
+				t.Fatalf("got %d entries, want %d", len(entries), tc.entries)
+			}
+		})
+	}
+
+	if _, err := NewReplayDetector(config.ReplayConfig{Path: filepath.Join(t.TempDir(), "missing.jsonl")}); err == nil {
+		t.Error("replaying a missing file succeeded")
+	}
+}
+
+func TestReplayModes(t *testing.T) {
+	a, b, unknown := solidFrame(10), solidFrame(20), solidFrame(30)
+	dets := func(class string) []models.DetectionResult {
+		return []models.DetectionResult{{Class: class, Score: 0.5}}
+	}
+
+	// Frame a was seen twice with different results, b failed.
+	path := writeRecording(t,
+		entryLine(t, 0, a, dets("first")),
+		entryLine(t, 1, b, nil),
+		entryLine(t, 2, a, dets("second")),
+	)
+
+	for _, tc := range []struct {
+		mode   config.ReplayMode
+		frames []image.Image
+		want   []string // class of the single detection, "-" for a failed frame
+	}{
+		{config.ReplayByHash, []image.Image{a, b, a, a, unknown}, []string{"first", "-", "second", "second", "-"}},
+		{config.ReplayBySequence, []image.Image{unknown, unknown, unknown, unknown}, []string{"first", "-", "second", "-"}},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			d, err := NewReplayDetector(config.ReplayConfig{Path: path, Mode: tc.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Start()
+			defer d.Stop()
+
+			for i, img := range tc.frames {
+				d.Input() <- Frame{ID: uint64(i), Image: img}
+
+				var res Result
+				select {
+				case res = <-d.Output():
+				case <-time.After(5 * time.Second):
+					t.Fatalf("no result for frame %d", i)
+				}
+
+				got := "-"
+				if res.Detections != nil {
+					got = res.Detections[0].Class
+				}
+				if res.FrameID != uint64(i) || got != tc.want[i] {
+					t.Errorf("frame %d: got %d %s, want %s", i, res.FrameID, got, tc.want[i])
+				}
+			}
+
+			select {
+			case err := <-d.Errors():
+				if err == nil {
+					t.Error("nil error")
+				}
+			default:
+				t.Error("no error for the frame missing from the recording")
+			}
+		})
+	}
+
+	if _, err := NewReplayDetector(config.ReplayConfig{Path: path, Mode: "shuffle"}); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
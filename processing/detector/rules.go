@@ -0,0 +1,157 @@
+package processing
+
+import (
+	"image"
+	"log"
+	"reflect"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// defaultRuleCooldown applies to rules without a cooldown, which would
+// otherwise fire on every frame while they hold.
+const defaultRuleCooldown = 10 * time.Second
+
+// Alert is raised when a rule fires. Detections are those that satisfied the
+// rule, and Snapshot is the frame they were found on, when still available.
+type Alert struct {
+	Rule       string
+	At         time.Time
+	FrameID    uint64
+	Count      int
+	Detections []models.DetectionResult
+	Snapshot   image.Image
+}
+
+// RuleEngine evaluates the alert rules against the results of each frame. A
+// rule's duration is measured from the first frame on which its condition
+// held, and any frame on which it does not hold starts it over. A
+// RuleEngine is not safe for concurrent use.
+type RuleEngine struct {
+	cfg   []config.RuleConfig
+	zcfg  config.ZonesConfig
+	rules []config.RuleConfig
+	zones map[string][]config.Point
+
+	state map[string]*ruleState
+}
+
+type ruleState struct {
+	since time.Time
+	fired time.Time
+}
+
+func NewRuleEngine(rules []config.RuleConfig, zones config.ZonesConfig) *RuleEngine {
+	e := &RuleEngine{state: make(map[string]*ruleState)}
+	e.SetConfig(rules, zones)
+	return e
+}
+
+// SetConfig replaces the rules. Rules keep their timers as long as their name
+// stays the same. Rules without a name or with the name of an earlier rule
+// are ignored.
+func (e *RuleEngine) SetConfig(rules []config.RuleConfig, zones config.ZonesConfig) {
+	if reflect.DeepEqual(rules, e.cfg) && reflect.DeepEqual(zones, e.zcfg) {
+		return
+	}
+	e.cfg, e.zcfg = rules, zones
+
+	e.rules = nil
+	seen := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		switch {
+		case r.Name == "":
+			log.Println("Alert rule without a name ignored")
+		case seen[r.Name]:
+			log.Printf("Alert rule %q ignored: name already used", r.Name)
+		default:
+			seen[r.Name] = true
+			e.rules = append(e.rules, r)
+		}
+	}
+
+	e.zones = make(map[string][]config.Point, len(zones.Zones))
+	for _, z := range zones.Zones {
+		e.zones[z.Name] = z.Polygon
+	}
+
+	state := make(map[string]*ruleState, len(e.rules))
+	for _, r := range e.rules {
+		if s, ok := e.state[r.Name]; ok {
+			state[r.Name] = s
+		} else {
+			state[r.Name] = &ruleState{}
+		}
+	}
+	e.state = state
+}
+
+// Evaluate checks every rule against the detections made on frame id and
+// returns the alerts that fired.
+func (e *RuleEngine) Evaluate(now time.Time, id uint64, frame image.Image, dets []models.DetectionResult) []Alert {
+	var alerts []Alert
+
+	for _, r := range e.rules {
+		s := e.state[r.Name]
+
+		matched := e.match(r, dets)
+		if len(matched) < max(r.MinCount, 1) {
+			s.since = time.Time{}
+			continue
+		}
+		if s.since.IsZero() {
+			s.since = now
+		}
+
+		if now.Sub(s.since) < time.Duration(r.DurationMs)*time.Millisecond {
+			continue
+		}
+		cooldown := time.Duration(r.CooldownMs) * time.Millisecond
+		if cooldown <= 0 {
+			cooldown = defaultRuleCooldown
+		}
+		if !s.fired.IsZero() && now.Sub(s.fired) < cooldown {
+			continue
+		}
+		s.fired = now
+
+		alerts = append(alerts, Alert{
+			Rule:       r.Name,
+			At:         now,
+			FrameID:    id,
+			Count:      len(matched),
+			Detections: matched,
+			Snapshot:   frame,
+		})
+	}
+
+	return alerts
+}
+
+func (e *RuleEngine) match(r config.RuleConfig, dets []models.DetectionResult) []models.DetectionResult {
+	var zone []config.Point
+	if r.Zone != "" {
+		var ok bool
+		if zone, ok = e.zones[r.Zone]; !ok {
+			// A rule naming a zone that does not exist never fires.
+			return nil
+		}
+	}
+
+	var out []models.DetectionResult
+	for _, d := range dets {
+		if r.Class != "" && d.Class != r.Class {
+			continue
+		}
+		if d.Score < r.MinScore {
+			continue
+		}
+		if zone != nil && (len(d.Box) < 4 || !InPolygon(zone, CenterOf(d))) {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
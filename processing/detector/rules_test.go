@@ -0,0 +1,121 @@
+package processing
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+func firedRules(alerts []Alert) []string {
+	var names []string
+	for _, a := range alerts {
+		names = append(names, a.Rule)
+	}
+	return names
+}
+
+func scored(d models.DetectionResult, score float32) models.DetectionResult {
+	d.Score = score
+	return d
+}
+
+func TestRuleMatching(t *testing.T) {
+	e := NewRuleEngine([]config.RuleConfig{
+		{Name: "any"},
+		{Name: "car", Class: "car"},
+		{Name: "sure car", Class: "car", MinScore: 0.8},
+		{Name: "two people", Class: "person", MinCount: 2},
+		{Name: "left car", Class: "car", Zone: "left"},
+		{Name: "nowhere", Zone: "missing"},
+	}, config.ZonesConfig{Zones: []config.ZoneConfig{
+		{Name: "left", Polygon: []config.Point{pt(0, 0), pt(0.5, 0), pt(0.5, 1), pt(0, 1)}},
+	}})
+
+	rightCar := scored(at("car", 0, 0.7, 0.5), 0.5)
+	leftCar := scored(at("car", 0, 0.2, 0.5), 0.9)
+	person := at("person", 0, 0.7, 0.5)
+
+	// Frames an hour apart, so no rule is cooling down.
+	start := time.Now()
+	for i, tc := range []struct {
+		dets []models.DetectionResult
+		want []string
+	}{
+		{nil, nil},
+		{[]models.DetectionResult{rightCar}, []string{"any", "car"}},
+		{[]models.DetectionResult{leftCar}, []string{"any", "car", "sure car", "left car"}},
+		{[]models.DetectionResult{person}, []string{"any"}},
+		{[]models.DetectionResult{person, rightCar, person}, []string{"any", "car", "two people"}},
+	} {
+		alerts := e.Evaluate(start.Add(time.Duration(i)*time.Hour), uint64(i), nil, tc.dets)
+		if got := firedRules(alerts); !slices.Equal(got, tc.want) {
+			t.Errorf("frame %d: fired %v, want %v", i, got, tc.want)
+		}
+
+		for _, a := range alerts {
+			if a.Rule == "two people" && (a.Count != 2 || len(a.Detections) != 2 || a.FrameID != uint64(i)) {
+				t.Errorf("two people alert = %+v", a)
+			}
+		}
+	}
+}
+
+func TestRuleDurationAndCooldown(t *testing.T) {
+	e := NewRuleEngine([]config.RuleConfig{
+		{Name: "lingering", DurationMs: 1000, CooldownMs: 3000},
+		{Name: "default cooldown"},
+	}, config.ZonesConfig{})
+
+	seen := []models.DetectionResult{at("car", 0, 0.5, 0.5)}
+	start := time.Now()
+	for _, tc := range []struct {
+		at   time.Duration
+		dets []models.DetectionResult
+		want []string
+	}{
+		{0, seen, []string{"default cooldown"}},
+		{900 * time.Millisecond, seen, nil},
+		// Interrupted just before the duration is reached: start over.
+		{950 * time.Millisecond, nil, nil},
+		{time.Second, seen, nil},
+		{2 * time.Second, seen, []string{"lingering"}},
+		// Both rules hold, but are cooling down.
+		{4 * time.Second, seen, nil},
+		{5 * time.Second, seen, []string{"lingering"}},
+		{10 * time.Second, seen, []string{"lingering", "default cooldown"}},
+	} {
+		alerts := e.Evaluate(start.Add(tc.at), 0, nil, tc.dets)
+		if got := firedRules(alerts); !slices.Equal(got, tc.want) {
+			t.Errorf("at %v: fired %v, want %v", tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestRuleNames(t *testing.T) {
+	dets := []models.DetectionResult{at("car", 0, 0.5, 0.5)}
+	rules := []config.RuleConfig{
+		{Name: "car", Class: "car"},
+		{Name: "car", Class: "person"},
+		{Class: "car"},
+		{Name: "other", Class: "car"},
+	}
+	e := NewRuleEngine(rules, config.ZonesConfig{})
+
+	// Only the first rule of a name counts, and rules without one are
+	// ignored.
+	start := time.Now()
+	if got := firedRules(e.Evaluate(start, 0, nil, dets)); !slices.Equal(got, []string{"car", "other"}) {
+		t.Fatalf("fired %v", got)
+	}
+
+	// A rule keeps its cooldown through a config change that keeps its name,
+	// while a renamed one starts afresh.
+	rules = []config.RuleConfig{{Name: "car", Class: "car", MinScore: 0}, {Name: "renamed", Class: "car"}}
+	e.SetConfig(rules, config.ZonesConfig{})
+	if got := firedRules(e.Evaluate(start.Add(time.Second), 1, nil, dets)); !slices.Equal(got, []string{"renamed"}) {
+		t.Errorf("fired %v after SetConfig, want renamed", got)
+	}
+}
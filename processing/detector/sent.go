@@ -0,0 +1,111 @@
+package processing
+
+import (
+	"slices"
+	"sync"
+)
+
+// sentFrames maps the numbers frames carry on one connection to the IDs they
+// were submitted with. The server numbers single frames in the order they
+// arrive, starting at 0, while batched frames carry their number; either way
+// the number is echoed with the results.
+type sentFrames struct {
+	mu      sync.Mutex
+	next    uint64
+	batches uint64
+	pending []numberedFrame
+}
+
+type numberedFrame struct {
+	wire  uint64
+	id    uint64
+	batch uint64 // 0 for frames sent on their own
+}
+
+// add numbers frame id. It must be called before the frame is written, so
+// its results cannot arrive first.
+func (s *sentFrames) add(id uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wire := s.next
+	s.next++
+	s.pending = append(s.pending, numberedFrame{wire: wire, id: id})
+	return wire
+}
+
+// addBatch numbers frames ids uploaded together as one batch.
+func (s *sentFrames) addBatch(ids []uint64) []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.batches++
+	wires := make([]uint64, len(ids))
+	for i, id := range ids {
+		wires[i] = s.next
+		s.next++
+		s.pending = append(s.pending, numberedFrame{wire: wires[i], id: id, batch: s.batches})
+	}
+	return wires
+}
+
+// cancel forgets a frame that was numbered but never written. The number is
+// reused if it was the last one handed out, to stay in step with a server
+// that counts the frames it receives.
+func (s *sentFrames) cancel(wire uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = slices.DeleteFunc(s.pending, func(f numberedFrame) bool { return f.wire == wire })
+	if wire+1 == s.next {
+		s.next--
+	}
+}
+
+// take returns the ID of the frame numbered wire. Without a number, as from
+// servers that do not echo one, it takes the oldest frame awaiting results.
+func (s *sentFrames) take(wire *uint64) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+	if wire != nil {
+		i = slices.IndexFunc(s.pending, func(f numberedFrame) bool { return f.wire == *wire })
+	}
+	if i < 0 || i >= len(s.pending) {
+		return 0, false
+	}
+
+	id := s.pending[i].id
+	s.pending = slices.Delete(s.pending, i, i+1)
+	return id, true
+}
+
+// batchOf returns the batch the frame numbered wire was sent in, if it is
+// still awaiting results.
+func (s *sentFrames) batchOf(wire uint64) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.pending, func(f numberedFrame) bool { return f.wire == wire })
+	if i < 0 {
+		return 0, false
+	}
+	return s.pending[i].batch, true
+}
+
+// takeBatch returns the IDs of the frames of batch still awaiting results.
+func (s *sentFrames) takeBatch(batch uint64) []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []uint64
+	s.pending = slices.DeleteFunc(s.pending, func(f numberedFrame) bool {
+		if f.batch != batch {
+			return false
+		}
+		ids = append(ids, f.id)
+		return true
+	})
+	return ids
+}
@@ -0,0 +1,69 @@
+package processing
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSentFrames(t *testing.T) {
+	var s sentFrames
+
+	for _, id := range []uint64{10, 11, 12} {
+		s.add(id)
+	}
+
+	// A frame that was never written gives its number back.
+	wire := s.add(13)
+	s.cancel(wire)
+	if got := s.add(14); got != wire {
+		t.Fatalf("number after cancel = %d, want %d", got, wire)
+	}
+
+	wire1 := uint64(1)
+	if id, ok := s.take(&wire1); !ok || id != 11 {
+		t.Errorf("take(1) = %d, %v, want 11", id, ok)
+	}
+	if _, ok := s.take(&wire1); ok {
+		t.Error("frame 1 taken twice")
+	}
+
+	// Without a number, the oldest frame goes first.
+	if id, ok := s.take(nil); !ok || id != 10 {
+		t.Errorf("take(nil) = %d, %v, want 10", id, ok)
+	}
+
+	wires := s.addBatch([]uint64{20, 21, 22})
+	if !slices.Equal(wires, []uint64{4, 5, 6}) {
+		t.Fatalf("batch numbers = %v, want 4, 5, 6", wires)
+	}
+	if b, ok := s.batchOf(5); !ok || b == 0 {
+		t.Errorf("batchOf(5) = %d, %v", b, ok)
+	}
+	if b, ok := s.batchOf(3); !ok || b != 0 {
+		t.Errorf("batchOf(3) = %d, %v, want a single frame", b, ok)
+	}
+	if _, ok := s.batchOf(99); ok {
+		t.Error("batchOf found a frame never sent")
+	}
+
+	b, _ := s.batchOf(5)
+	if id, ok := s.take(&wires[0]); !ok || id != 20 {
+		t.Errorf("take(4) = %d, %v, want 20", id, ok)
+	}
+	if ids := s.takeBatch(b); !slices.Equal(ids, []uint64{21, 22}) {
+		t.Errorf("takeBatch = %v, want 21, 22", ids)
+	}
+
+	// Only the single frames are left.
+	var left []uint64
+	for {
+		id, ok := s.take(nil)
+		if !ok {
+			break
+		}
+		left = append(left, id)
+	}
+	if !slices.Equal(left, []uint64{12, 14}) {
+		t.Errorf("left over = %v, want 12, 14", left)
+	}
+}
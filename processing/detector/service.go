@@ -1,114 +1,596 @@
 package processing
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"image"
-	"image/jpeg"
 	"log"
-	"net/url"
+	"sync"
 	"time"
 
+	"vision/internal/config"
 	"vision/internal/models"
+)
+
+const (
+	pingInterval = 2 * time.Second
+	pongWait     = 3 * pingInterval
+	writeWait    = time.Second
 
-	"github.com/gorilla/websocket"
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
 )
 
 type RemoteDetector struct {
 	serverURL string
+	dial      dialFunc
+	encoding  config.EncodingConfig
+	batch     config.BatchConfig
+	legacy    bool
+
+	InputFrames  chan Frame
+	OutputResult chan Result
 
-	InputFrames  chan image.Image
-	OutputResult chan []models.DetectionResult
+	sessionChanged chan struct{}
+	errs           chan error
 
-	stopChan chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	stopOnce  sync.Once
+
+	mu         sync.RWMutex
+	status     ConnStatus
+	conns      uint64
+	lastPong   time.Time
+	serverBusy bool
+	negotiated config.EncodingConfig
+	batchSize  int
+	session    config.SessionConfig
+	caps       Capabilities
+
+	meter encodeMeter
 }
 
-func NewRemoteDetector(host string) *RemoteDetector {
-	u := url.URL{Scheme: "ws", Host: host, Path: "/ws"}
+func NewRemoteDetector(host string, cfg config.DetectorConfig) *RemoteDetector {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &RemoteDetector{
+		encoding:     cfg.Encoding,
+		batch:        cfg.Batch,
+		legacy:       cfg.Legacy,
+		InputFrames:  make(chan Frame, 5),
+		OutputResult: make(chan Result, max(5, cfg.Batch.Size)),
 
-	return &RemoteDetector{
-		serverURL:    u.String(),
-		InputFrames:  make(chan image.Image, 5),
-		OutputResult: make(chan []models.DetectionResult, 5),
-		stopChan:     make(chan struct{}),
+		sessionChanged: make(chan struct{}, 1),
+		errs:           make(chan error, 10),
+
+		ctx:     ctx,
+		cancel:  cancel,
+		status:  ConnStatus{State: StateDisconnected, Since: time.Now()},
+		session: cfg.Session,
 	}
+
+	d.serverURL, d.dial = newDialer(host, cfg, &d.meter)
+	return d
 }
 
 func (d *RemoteDetector) Start() {
-	go d.runLoop()
+	d.startOnce.Do(func() {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.runLoop(d.ctx)
+		}()
+	})
 }
 
+// Stop cancels the detector, closes the active connection and waits for every
+// goroutine it owns to exit. Frames still queued in InputFrames are discarded.
+// It is safe to call Stop more than once.
 func (d *RemoteDetector) Stop() {
-	close(d.stopChan)
+	d.stopOnce.Do(func() {
+		d.cancel()
+		d.wg.Wait()
+
+		for {
+			select {
+			case <-d.InputFrames:
+			default:
+				return
+			}
+		}
+	})
 }
 
-func (d *RemoteDetector) runLoop() {
-	var conn *websocket.Conn
-	var err error
+func (d *RemoteDetector) Input() chan<- Frame {
+	return d.InputFrames
+}
 
-	for {
-		select {
-		case <-d.stopChan:
-			return
-		default:
-		}
+func (d *RemoteDetector) Output() <-chan Result {
+	return d.OutputResult
+}
+
+// Errors reports server-side failures and protocol errors. Transport errors
+// are reflected in Status instead.
+func (d *RemoteDetector) Errors() <-chan error {
+	return d.errs
+}
 
-		log.Println("Connecting to detector server...", d.serverURL)
-		conn, _, err = websocket.DefaultDialer.Dial(d.serverURL, nil)
+func (d *RemoteDetector) Status() ConnStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.status
+}
+
+// UpdateSession stores new session parameters and sends them to the server
+// if connected. They are also resent on every reconnect.
+func (d *RemoteDetector) UpdateSession(s config.SessionConfig) {
+	d.mu.Lock()
+	d.session = s
+	d.mu.Unlock()
+
+	select {
+	case d.sessionChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (d *RemoteDetector) currentSession() config.SessionConfig {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.session
+}
+
+func (d *RemoteDetector) Capabilities() Capabilities {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.caps
+}
+
+func (d *RemoteDetector) EncodeStats() EncodeStats {
+	return d.meter.Snapshot()
+}
+
+func (d *RemoteDetector) negotiation() (config.EncodingConfig, int) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.negotiated, d.batchSize
+}
+
+// resetNegotiation falls back to unbatched JPEG for a fresh connection until
+// the server has answered the hello, and forgets any busy status.
+func (d *RemoteDetector) resetNegotiation() {
+	enc := config.EncodingConfig{Format: config.EncodingJPEG}
+	if d.encoding.Format == config.EncodingJPEG || d.encoding.Format == "" {
+		enc.Quality = d.encoding.Quality
+	}
+
+	d.mu.Lock()
+	d.negotiated = enc
+	d.batchSize = 1
+	d.serverBusy = false
+	d.mu.Unlock()
+}
+
+func (d *RemoteDetector) applyHello(resp helloResponse) error {
+	if !isSupportedEncoding(resp.Encoding) {
+		return fmt.Errorf("server selected unsupported encoding %q", resp.Encoding)
+	}
+
+	enc := config.EncodingConfig{Format: resp.Encoding, Quality: resp.Quality}
+	if enc.Quality == 0 {
+		enc.Quality = d.encoding.Quality
+	}
+
+	batchSize := 1
+	if resp.MaxBatch > 1 && d.batch.Size > 1 {
+		batchSize = min(resp.MaxBatch, d.batch.Size)
+	}
+
+	d.mu.Lock()
+	d.negotiated = enc
+	d.batchSize = batchSize
+	d.mu.Unlock()
+
+	log.Printf("Detector %s negotiated %s upload encoding, batch size %d", d.serverURL, enc.Format, batchSize)
+	return nil
+}
+
+func (d *RemoteDetector) setState(state ConnState, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err != nil {
+		d.status.LastError = err
+	}
+
+	if d.status.State != state {
+		d.status.State = state
+		d.status.Since = time.Now()
+	}
+}
+
+func (d *RemoteDetector) markAlive() {
+	d.mu.Lock()
+	d.lastPong = time.Now()
+	busy := d.serverBusy
+	d.mu.Unlock()
+
+	if !busy {
+		d.setState(StateConnected, nil)
+	}
+}
+
+func (d *RemoteDetector) sinceAlive() time.Duration {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return time.Since(d.lastPong)
+}
+
+// connections counts the connections made so far. Frames in flight on one
+// connection never get results on the next.
+func (d *RemoteDetector) connections() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.conns
+}
+
+func (d *RemoteDetector) runLoop(ctx context.Context) {
+	bo := newBackoff(minReconnectDelay, maxReconnectDelay)
+	defer d.setState(StateDisconnected, nil)
+
+	for ctx.Err() == nil {
+		d.setState(StateConnecting, nil)
+		conn, err := d.dial(ctx)
 
 		if err != nil {
-			log.Printf("Connection failed: %v. Retrying in 2s...", err)
-			time.Sleep(2 * time.Second)
+			if ctx.Err() != nil {
+				return
+			}
+
+			delay := bo.Next()
+			d.setState(StateDisconnected, err)
+			log.Printf("Connection failed: %v. Retrying in %v...", err, delay.Round(time.Millisecond))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
 			continue
 		}
 
 		log.Println("Connected to detection server!")
+		bo.Reset()
 
-		errChan := make(chan error, 1)
+		d.mu.Lock()
+		d.conns++
+		d.mu.Unlock()
 
-		go func() {
-			for {
-				select {
-				case <-d.stopChan:
-					return
-				case img := <-d.InputFrames:
-					var buf bytes.Buffer
-					if err := jpeg.Encode(&buf, img, nil); err != nil {
-						log.Println("JPEG encode error:", err)
-						continue
-					}
-
-					if err := conn.WriteMessage(websocket.BinaryMessage, buf.Bytes()); err != nil {
-						errChan <- err
-						return
-					}
-				}
+		err = d.serve(ctx, conn)
+		if ctx.Err() != nil {
+			return
+		}
+
+		d.setState(StateDisconnected, err)
+		log.Printf("Connection lost: %v", err)
+	}
+}
+
+// serve runs the reader and writer for a single connection. Both goroutines
+// are bound to a per-connection context, so whichever fails first tears the
+// other down and serve only returns once both have exited.
+func (d *RemoteDetector) serve(ctx context.Context, conn detectorConn) error {
+	connCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	d.resetNegotiation()
+
+	if !d.legacy {
+		if err := d.handshake(conn); err != nil {
+			conn.Close(false)
+			return err
+		}
+	}
+
+	d.markAlive()
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func() {
+		d.markAlive()
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	// Frames still awaiting results when the connection ends are lost.
+	sent := &sentFrames{}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		cancel(d.writeLoop(connCtx, conn, sent))
+	}()
+
+	go func() {
+		defer wg.Done()
+		cancel(d.readLoop(connCtx, conn, sent))
+	}()
+
+	<-connCtx.Done()
+
+	conn.Close(ctx.Err() != nil)
+	wg.Wait()
+	return context.Cause(connCtx)
+}
+
+// handshake negotiates the upload and sends the session settings. Legacy
+// servers, which only understand frames, go without.
+func (d *RemoteDetector) handshake(conn detectorConn) error {
+	if err := conn.WriteJSON(newHelloRequest(d.encoding, d.batch)); err != nil {
+		return err
+	}
+	if err := conn.WriteJSON(newSessionMessage(d.currentSession())); err != nil {
+		return err
+	}
+	return conn.WriteJSON(envelope{Type: msgCapabilities})
+}
+
+func (d *RemoteDetector) writeLoop(ctx context.Context, conn detectorConn, sent *sentFrames) error {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	var batch []Frame
+	var flush <-chan time.Time
+
+	flushTimer := time.NewTimer(0)
+	<-flushTimer.C
+	defer flushTimer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if d.sinceAlive() > 2*pingInterval {
+				d.setState(StateDegraded, nil)
 			}
-		}()
 
-		go func() {
-			for {
-				_, message, err := conn.ReadMessage()
+			if err := conn.Ping(); err != nil {
+				return err
+			}
+		case <-d.sessionChanged:
+			// Servers that only understand frames keep their settings.
+			if d.legacy {
+				continue
+			}
+			if err := conn.WriteJSON(newSessionMessage(d.currentSession())); err != nil {
+				return err
+			}
+		case f := <-d.InputFrames:
+			enc, batchSize := d.negotiation()
+
+			if batchSize <= 1 && len(batch) == 0 {
+				wire := sent.add(f.ID)
+				ok, err := conn.WriteFrame(f.Image, enc)
 				if err != nil {
-					errChan <- err
-					return
+					return err
 				}
-
-				var results []models.DetectionResult
-				if err := json.Unmarshal(message, &results); err != nil {
-					log.Println("JSON decode error:", err)
-					continue
+				if !ok {
+					sent.cancel(wire)
+					d.deliver(ctx, Result{FrameID: f.ID})
 				}
+				continue
+			}
+
+			batch = append(batch, f)
+			if len(batch) == 1 {
+				flushTimer.Reset(time.Duration(d.batch.MaxWaitMs) * time.Millisecond)
+				flush = flushTimer.C
+			}
 
-				select {
-				case d.OutputResult <- results:
-				default:
+			if len(batch) >= batchSize {
+				flushTimer.Stop()
+				flush = nil
+
+				if err := d.writeBatch(ctx, conn, sent, batch, enc); err != nil {
+					return err
 				}
+				batch = nil
 			}
-		}()
+		case <-flush:
+			flush = nil
+			enc, _ := d.negotiation()
 
-		err = <-errChan
-		log.Printf("Connection lost: %v", err)
-		conn.Close()
+			if err := d.writeBatch(ctx, conn, sent, batch, enc); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+}
+
+// writeBatch uploads frames as one batch, numbering them after the frames
+// sent before. Frames that could not be encoded get a failed result.
+func (d *RemoteDetector) writeBatch(ctx context.Context, conn detectorConn, sent *sentFrames, frames []Frame, enc config.EncodingConfig) error {
+	imgs := make([]image.Image, len(frames))
+	ids := make([]uint64, len(frames))
+	for i, f := range frames {
+		imgs[i] = f.Image
+		ids[i] = f.ID
+	}
+	wires := sent.addBatch(ids)
+
+	ok, err := conn.WriteBatch(imgs, wires, enc)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range frames {
+		if !ok[i] {
+			sent.cancel(wires[i])
+			d.deliver(ctx, Result{FrameID: f.ID})
+		}
 	}
+	return nil
+}
+
+func (d *RemoteDetector) readLoop(ctx context.Context, conn detectorConn, sent *sentFrames) error {
+	for {
+		message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		d.markAlive()
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		if isEnvelope(message) {
+			if err := d.handleEnvelope(ctx, message, sent); err != nil {
+				d.reportError(fmt.Errorf("detector %s: bad message: %w", d.serverURL, err))
+			}
+			continue
+		}
+
+		// A bare array answers the oldest frame, so a broken one still
+		// yields a failed result for it.
+		var results []models.DetectionResult
+		if err := json.Unmarshal(message, &results); err != nil {
+			d.reportError(fmt.Errorf("detector %s: bad results: %w", d.serverURL, err))
+			results = nil
+		}
+		if id, ok := sent.take(nil); ok {
+			d.deliver(ctx, Result{FrameID: id, Detections: results})
+		}
+	}
+}
+
+// deliver passes one frame's results on, waiting for room unless the
+// connection ends first.
+func (d *RemoteDetector) deliver(ctx context.Context, r Result) {
+	select {
+	case d.OutputResult <- r:
+	case <-ctx.Done():
+	}
+}
+
+// failFrame delivers a failed result for the frame a server error names, and
+// makes the error refer to the frame by its submitted ID.
+func (d *RemoteDetector) failFrame(ctx context.Context, sent *sentFrames, serr *ServerError) {
+	wire := serr.FrameID
+	if id, ok := sent.take(&wire); ok {
+		serr.FrameID = id
+		d.deliver(ctx, Result{FrameID: id})
+	}
+}
+
+func (d *RemoteDetector) reportError(err error) {
+	log.Println(err)
+
+	select {
+	case d.errs <- err:
+	default:
+	}
+}
+
+func isEnvelope(message []byte) bool {
+	for _, c := range message {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func (d *RemoteDetector) handleEnvelope(ctx context.Context, message []byte, sent *sentFrames) error {
+	var env envelope
+	if err := json.Unmarshal(message, &env); err != nil {
+		return err
+	}
+
+	switch env.Type {
+	case msgResults:
+		var msg resultsMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return err
+		}
+		if msg.Results == nil {
+			msg.Results = []models.DetectionResult{}
+		}
+		if id, ok := sent.take(msg.FrameID); ok {
+			d.deliver(ctx, Result{FrameID: id, Detections: msg.Results})
+		}
+		return nil
+	case msgError:
+		var msg errorMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return err
+		}
+
+		serr := newServerError(d.serverURL, msg)
+		if serr.PerFrame {
+			d.failFrame(ctx, sent, serr)
+		}
+		d.reportError(serr)
+		return nil
+	case msgStatus:
+		var msg statusMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return err
+		}
+		d.applyServerStatus(msg)
+		return nil
+	case msgHello:
+		var resp helloResponse
+		if err := json.Unmarshal(message, &resp); err != nil {
+			return err
+		}
+		return d.applyHello(resp)
+	case msgBatch:
+		var resp batchResponse
+		if err := json.Unmarshal(message, &resp); err != nil {
+			return err
+		}
+		d.deliverBatch(ctx, resp, sent)
+		return nil
+	case msgCapabilities:
+		var msg capabilitiesMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return err
+		}
+
+		d.mu.Lock()
+		d.caps = msg.Capabilities
+		d.mu.Unlock()
+		return nil
+	default:
+		return fmt.Errorf("unknown message type %q", env.Type)
+	}
+}
+
+// applyServerStatus keeps the connection Degraded while the server reports
+// it is overloaded or reloading its model.
+func (d *RemoteDetector) applyServerStatus(msg statusMessage) {
+	busy := msg.Status != ServerReady
+
+	d.mu.Lock()
+	d.serverBusy = busy
+	d.mu.Unlock()
+
+	if !busy {
+		log.Printf("Detector %s is ready", d.serverURL)
+		d.setState(StateConnected, nil)
+		return
+	}
+
+	err := &ServerError{Endpoint: d.serverURL, Code: msg.Status, Message: msg.Message}
+	d.setState(StateDegraded, err)
+	d.reportError(err)
 }
@@ -0,0 +1,285 @@
+package processing
+
+import (
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// serverKind is how a testServer treats control messages and frames.
+type serverKind int
+
+const (
+	answersHello    serverKind = iota
+	ignoresText                // reads and drops control messages
+	dropsOnText                // closes the connection, like a frames-only server
+	answersSlowly              // answers frames after slowReply
+	ignoresFrames              // never answers frames
+	dropsOnFrame               // closes the connection on the first frame
+	dropsFirstHello            // closes its first connection on the hello
+)
+
+const slowReply = 20 * time.Millisecond
+
+// testServer is a minimal detection server. It answers every binary frame
+// with empty results numbered like a real server would, and hands each
+// connection to the test so it can drop it. Connections and closes beyond
+// what the channels hold are not reported.
+type testServer struct {
+	*httptest.Server
+	conns  chan *websocket.Conn
+	closed chan struct{}
+
+	mu       sync.Mutex
+	messages [][]string // control message types, per connection
+}
+
+func newTestServer(t *testing.T, kind serverKind) *testServer {
+	t.Helper()
+
+	s := &testServer{
+		conns:  make(chan *websocket.Conn, 4),
+		closed: make(chan struct{}, 4),
+	}
+	upgrader := websocket.Upgrader{}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		s.mu.Lock()
+		n := len(s.messages)
+		s.messages = append(s.messages, nil)
+		s.mu.Unlock()
+
+		select {
+		case s.conns <- conn:
+		default:
+		}
+		defer func() {
+			select {
+			case s.closed <- struct{}{}:
+			default:
+			}
+		}()
+
+		var frames uint64
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var reply any
+			switch {
+			case typ == websocket.BinaryMessage:
+				switch kind {
+				case ignoresFrames:
+					continue
+				case dropsOnFrame:
+					return
+				case answersSlowly:
+					time.Sleep(slowReply)
+				}
+				id := frames
+				frames++
+				reply = resultsMessage{Type: msgResults, FrameID: &id}
+			case kind == dropsOnText:
+				return
+			case kind != ignoresText:
+				var env envelope
+				json.Unmarshal(msg, &env)
+				s.mu.Lock()
+				s.messages[n] = append(s.messages[n], env.Type)
+				s.mu.Unlock()
+
+				if env.Type != msgHello {
+					continue
+				}
+				if kind == dropsFirstHello && n == 0 {
+					return
+				}
+				reply = helloResponse{Type: msgHello, Encoding: "jpeg"}
+			default:
+				continue
+			}
+
+			data, _ := json.Marshal(reply)
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// received returns the control message types each connection got.
+func (s *testServer) received() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([][]string, len(s.messages))
+	for i, m := range s.messages {
+		out[i] = slices.Clone(m)
+	}
+	return out
+}
+
+func (s *testServer) host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func (s *testServer) accept(t *testing.T) *websocket.Conn {
+	t.Helper()
+	select {
+	case conn := <-s.conns:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("detector did not connect")
+		return nil
+	}
+}
+
+func waitState(t *testing.T, d *RemoteDetector, state ConnState) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Status().State != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("state is %s, want %s", d.Status().State, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func detectOne(t *testing.T, d *RemoteDetector, id uint64) {
+	t.Helper()
+	d.Input() <- Frame{ID: id, Image: image.NewRGBA(image.Rect(0, 0, 8, 8))}
+
+	select {
+	case res := <-d.Output():
+		if res.FrameID != id || res.Detections == nil {
+			t.Fatalf("got result %+v for frame %d", res, id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no result for frame %d", id)
+	}
+}
+
+func TestRemoteDetectorReconnect(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	srv := newTestServer(t, answersHello)
+	d := NewRemoteDetector(srv.host(), config.DetectorConfig{})
+	d.Start()
+
+	first := srv.accept(t)
+	waitState(t, d, StateConnected)
+	detectOne(t, d, 1)
+
+	// Drop the connection from the server side.
+	first.Close()
+	srv.accept(t)
+	waitState(t, d, StateConnected)
+	if n := d.connections(); n != 2 {
+		t.Fatalf("connections = %d, want 2", n)
+	}
+	detectOne(t, d, 2)
+
+	d.Stop()
+	d.Stop()
+
+	select {
+	case <-srv.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("socket still open after Stop")
+	}
+	if st := d.Status().State; st != StateDisconnected {
+		t.Fatalf("state after Stop is %s", st)
+	}
+
+	srv.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines left over:\n%s", runtime.NumGoroutine()-baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRemoteDetectorHelloIgnored(t *testing.T) {
+	srv := newTestServer(t, ignoresText)
+	d := NewRemoteDetector(srv.host(), config.DetectorConfig{})
+	d.Start()
+	defer d.Stop()
+
+	srv.accept(t)
+	waitState(t, d, StateConnected)
+	detectOne(t, d, 1)
+	detectOne(t, d, 2)
+
+	if enc, _ := d.negotiation(); enc.Format != config.EncodingJPEG {
+		t.Fatalf("encoding = %s, want jpeg", enc.Format)
+	}
+}
+
+func TestRemoteDetectorSessionSurvivesDrops(t *testing.T) {
+	// The first connection drops before the hello is answered, as on a
+	// network blip.
+	srv := newTestServer(t, dropsFirstHello)
+	d := NewRemoteDetector(srv.host(), config.DetectorConfig{})
+	d.Start()
+	defer d.Stop()
+
+	srv.accept(t)
+	srv.accept(t)
+	waitState(t, d, StateConnected)
+
+	d.UpdateSession(config.SessionConfig{Model: "large"})
+	detectOne(t, d, 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := srv.received()
+		if len(got) == 2 && slices.Equal(got[1], []string{msgHello, msgSession, msgCapabilities, msgSession}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server got %v, want a hello and both sessions on the last connection", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRemoteDetectorLegacy(t *testing.T) {
+	srv := newTestServer(t, dropsOnText)
+	d := NewRemoteDetector(srv.host(), config.DetectorConfig{Legacy: true})
+	d.Start()
+	defer d.Stop()
+
+	srv.accept(t)
+	waitState(t, d, StateConnected)
+	detectOne(t, d, 1)
+
+	select {
+	case <-srv.conns:
+		t.Fatal("legacy detector reconnected")
+	default:
+	}
+}
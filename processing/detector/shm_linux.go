@@ -0,0 +1,485 @@
+//go:build linux
+
+package processing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"vision/internal/config"
+
+	"golang.org/x/sys/unix"
+)
+
+// The shared-memory transport talks newline-delimited JSON over a Unix
+// socket: the usual control messages and result envelopes, plus the ones
+// below. Pixels never cross the socket. The client writes them as packed RGB
+// into a memfd ring, passed to the server with SCM_RIGHTS, and only names the
+// slot. A new ring message replaces the ring for every frame sent after it.
+// Since the socket has no control frames, heartbeats are JSON ping/pong.
+const (
+	msgRing  = "ring"
+	msgFrame = "frame"
+	msgPing  = "ping"
+	msgPong  = "pong"
+)
+
+const defaultShmSlots = 8
+
+type shmRingMessage struct {
+	Type     string `json:"type"`
+	Slots    int    `json:"slots"`
+	SlotSize int    `json:"slot_size"`
+}
+
+type shmFrame struct {
+	FrameID *uint64 `json:"frame_id,omitempty"`
+	Slot    int     `json:"slot"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+}
+
+type shmFrameMessage struct {
+	Type string `json:"type"`
+	shmFrame
+}
+
+type shmBatchMessage struct {
+	Type   string     `json:"type"`
+	Frames []shmFrame `json:"frames"`
+}
+
+func shmSupported() error {
+	return nil
+}
+
+// shmConn hands frames over through a shared ring of fixed-size slots. A
+// slot is only written again once the server has answered the frame it
+// holds. Answers may come in any order, so the reader frees slots by the
+// frame numbers the server echoes, numbered the way sentFrames does.
+type shmConn struct {
+	conn  *net.UnixConn
+	r     *bufio.Reader
+	meter *encodeMeter
+
+	slots int
+	freed chan struct{}
+
+	onPong func()
+
+	mu      sync.Mutex
+	free    []int
+	held    []heldSlot
+	wires   uint64
+	batches uint64
+	ringID  int
+
+	writeMu  sync.Mutex
+	ring     []byte
+	slotSize int
+	closed   bool
+}
+
+// heldSlot is a slot holding the frame numbered wire until it is answered.
+// Slots of a ring that has since been replaced are not freed again.
+type heldSlot struct {
+	wire   uint64
+	slot   int
+	batch  uint64 // 0 for frames sent on their own
+	ringID int
+}
+
+func dialShm(ctx context.Context, path string, cfg config.ShmConfig, meter *encodeMeter) (detectorConn, error) {
+	var dialer net.Dialer
+	c, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := cfg.Slots
+	if slots < 1 {
+		slots = defaultShmSlots
+	}
+
+	free := make([]int, slots)
+	for i := range free {
+		free[i] = i
+	}
+
+	return &shmConn{
+		conn:   c.(*net.UnixConn),
+		r:      bufio.NewReader(c),
+		meter:  meter,
+		slots:  slots,
+		freed:  make(chan struct{}, 1),
+		free:   free,
+		onPong: func() {},
+	}, nil
+}
+
+func (c *shmConn) WriteJSON(v any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeJSONLocked(v, nil)
+}
+
+func (c *shmConn) writeJSONLocked(v any, oob []byte) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if oob != nil {
+		_, _, err = c.conn.WriteMsgUnix(data, oob, nil)
+		return err
+	}
+	_, err = c.conn.Write(data)
+	return err
+}
+
+func (c *shmConn) WriteFrame(img image.Image, _ config.EncodingConfig) (bool, error) {
+	slots, err := c.reserve(1)
+	if err != nil {
+		return false, err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	frames, err := c.putLocked([]image.Image{img}, slots)
+	if err != nil {
+		c.unreserve(slots)
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.hold(c.wires, slots[0], 0)
+	c.wires++
+	c.mu.Unlock()
+
+	return true, c.writeJSONLocked(shmFrameMessage{Type: msgFrame, shmFrame: frames[0]}, nil)
+}
+
+func (c *shmConn) WriteBatch(images []image.Image, ids []uint64, _ config.EncodingConfig) ([]bool, error) {
+	slots, err := c.reserve(len(images))
+	if err != nil {
+		return nil, err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	frames, err := c.putLocked(images, slots)
+	if err != nil {
+		c.unreserve(slots)
+		return nil, err
+	}
+
+	msg := shmBatchMessage{Type: msgBatch, Frames: frames}
+	sent := make([]bool, len(images))
+
+	c.mu.Lock()
+	c.batches++
+	for i := range frames {
+		msg.Frames[i].FrameID = &ids[i]
+		c.hold(ids[i], slots[i], c.batches)
+		c.wires = ids[i] + 1
+		sent[i] = true
+	}
+	c.mu.Unlock()
+
+	return sent, c.writeJSONLocked(msg, nil)
+}
+
+// reserve takes n free slots, waiting for the server to answer the frames
+// holding them. A server that stops answering fails the write like a stalled
+// socket would.
+func (c *shmConn) reserve(n int) ([]int, error) {
+	if n > c.slots {
+		return nil, fmt.Errorf("batch of %d frames exceeds the %d ring slots", n, c.slots)
+	}
+
+	timeout := time.NewTimer(writeWait)
+	defer timeout.Stop()
+
+	for {
+		c.mu.Lock()
+		if len(c.free) >= n {
+			slots := slices.Clone(c.free[:n])
+			c.free = c.free[n:]
+			c.mu.Unlock()
+			return slots, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.freed:
+		case <-timeout.C:
+			return nil, errors.New("shared-memory ring is full")
+		}
+	}
+}
+
+// unreserve gives back slots that were reserved but never written.
+func (c *shmConn) unreserve(slots []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, slot := range slots {
+		c.freeLocked(slot)
+	}
+}
+
+func (c *shmConn) freeLocked(slot int) {
+	c.free = append(c.free, slot)
+	select {
+	case c.freed <- struct{}{}:
+	default:
+	}
+}
+
+// hold records that slot holds the frame numbered wire. c.mu must be held.
+func (c *shmConn) hold(wire uint64, slot int, batch uint64) {
+	c.held = append(c.held, heldSlot{wire: wire, slot: slot, batch: batch, ringID: c.ringID})
+}
+
+// answered frees the slot of the frame numbered wire or, without a number,
+// of the oldest frame awaiting results.
+func (c *shmConn) answered(wire *uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := 0
+	if wire != nil {
+		i = slices.IndexFunc(c.held, func(h heldSlot) bool { return h.wire == *wire })
+	}
+	if i < 0 || i >= len(c.held) {
+		return
+	}
+
+	h := c.held[i]
+	c.held = slices.Delete(c.held, i, i+1)
+	if h.ringID == c.ringID {
+		c.freeLocked(h.slot)
+	}
+}
+
+// answeredBatch frees the slots of every batch one of wires was sent in. A
+// batch response settles the whole batch: frames it leaves out are failed.
+func (c *shmConn) answeredBatch(wires []uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var batches []uint64
+	for _, h := range c.held {
+		if h.batch != 0 && slices.Contains(wires, h.wire) {
+			batches = append(batches, h.batch)
+		}
+	}
+
+	c.held = slices.DeleteFunc(c.held, func(h heldSlot) bool {
+		if !slices.Contains(batches, h.batch) {
+			return false
+		}
+		if h.ringID == c.ringID {
+			c.freeLocked(h.slot)
+		}
+		return true
+	})
+}
+
+// putLocked copies images into the reserved slots, first growing the ring if
+// the largest of them does not fit. The ring is grown before any frame is
+// copied, so a batch never straddles two rings.
+func (c *shmConn) putLocked(images []image.Image, slots []int) ([]shmFrame, error) {
+	if c.closed {
+		return nil, net.ErrClosed
+	}
+
+	size := 0
+	for _, img := range images {
+		b := img.Bounds()
+		size = max(size, b.Dx()*b.Dy()*3)
+	}
+
+	if size > c.slotSize {
+		if err := c.mapRingLocked(size, slots); err != nil {
+			return nil, err
+		}
+	}
+
+	frames := make([]shmFrame, len(images))
+	for i, img := range images {
+		start := time.Now()
+		b := img.Bounds()
+
+		copyRGB(c.ring[slots[i]*c.slotSize:], img)
+		c.meter.Observe(config.EncodingRaw, b.Dx()*b.Dy()*3, time.Since(start))
+
+		frames[i] = shmFrame{Slot: slots[i], Width: b.Dx(), Height: b.Dy()}
+	}
+	return frames, nil
+}
+
+// mapRingLocked replaces the ring with one of slotSize slots. Frames still
+// held in the old ring stay readable through the server's own mapping, so
+// every slot of the new ring is free but the reserved ones.
+func (c *shmConn) mapRingLocked(slotSize int, reserved []int) error {
+	fd, err := unix.MemfdCreate("vision-frames", unix.MFD_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("memfd_create: %w", err)
+	}
+	defer unix.Close(fd)
+
+	total := slotSize * c.slots
+	if err := unix.Ftruncate(fd, int64(total)); err != nil {
+		return fmt.Errorf("ftruncate: %w", err)
+	}
+
+	ring, err := unix.Mmap(fd, 0, total, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("mmap: %w", err)
+	}
+
+	msg := shmRingMessage{Type: msgRing, Slots: c.slots, SlotSize: slotSize}
+	if err := c.writeJSONLocked(msg, unix.UnixRights(fd)); err != nil {
+		unix.Munmap(ring)
+		return err
+	}
+
+	if c.ring != nil {
+		unix.Munmap(c.ring)
+	}
+	c.ring = ring
+	c.slotSize = slotSize
+
+	c.mu.Lock()
+	c.ringID++
+	c.free = c.free[:0]
+	for slot := range c.slots {
+		if !slices.Contains(reserved, slot) {
+			c.free = append(c.free, slot)
+		}
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// ReadMessage returns the next message from the server. Pongs are consumed
+// here, and every answered frame frees its slot.
+func (c *shmConn) ReadMessage() ([]byte, error) {
+	for {
+		line, err := c.r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		if !isEnvelope(line) {
+			c.answered(nil)
+			return line, nil
+		}
+
+		var msg struct {
+			Type    string  `json:"type"`
+			FrameID *uint64 `json:"frame_id"`
+		}
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return line, nil
+		}
+
+		switch msg.Type {
+		case msgPong:
+			c.onPong()
+			continue
+		case msgResults:
+			c.answered(msg.FrameID)
+		case msgError:
+			if msg.FrameID != nil {
+				c.answered(msg.FrameID)
+			}
+		case msgBatch:
+			var batch batchResponse
+			if err := json.Unmarshal(line, &batch); err == nil {
+				var wires []uint64
+				for _, item := range batch.Results {
+					wires = append(wires, item.FrameID)
+				}
+				c.answeredBatch(wires)
+			}
+		}
+		return line, nil
+	}
+}
+
+func (c *shmConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *shmConn) Ping() error {
+	return c.WriteJSON(envelope{Type: msgPing})
+}
+
+func (c *shmConn) SetPongHandler(h func()) {
+	c.onPong = h
+}
+
+// Close closes the socket before unmapping the ring, so a writer blocked on
+// the socket is released and none can be copying into the ring afterwards.
+func (c *shmConn) Close(bool) error {
+	err := c.conn.Close()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.ring != nil {
+		unix.Munmap(c.ring)
+		c.ring = nil
+	}
+	c.closed = true
+	return err
+}
+
+// copyRGB packs img into dst as 8-bit RGB rows, the layout of raw uploads.
+func copyRGB(dst []byte, img image.Image) {
+	b := img.Bounds()
+	i := 0
+
+	var pix []byte
+	var stride, offset int
+
+	switch m := img.(type) {
+	case *image.RGBA:
+		pix, stride, offset = m.Pix, m.Stride, m.PixOffset(b.Min.X, b.Min.Y)
+	case *image.NRGBA:
+		pix, stride, offset = m.Pix, m.Stride, m.PixOffset(b.Min.X, b.Min.Y)
+	}
+
+	if pix != nil {
+		for y := 0; y < b.Dy(); y++ {
+			row := pix[offset+y*stride:]
+			for x := 0; x < b.Dx(); x++ {
+				copy(dst[i:i+3], row[x*4:x*4+3])
+				i += 3
+			}
+		}
+		return
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			dst[i], dst[i+1], dst[i+2] = uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+			i += 3
+		}
+	}
+}
@@ -0,0 +1,224 @@
+//go:build linux
+
+package processing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"vision/internal/config"
+
+	"golang.org/x/sys/unix"
+)
+
+// shmPeer is the server end of a shared-memory connection. It maps the
+// rings it is sent and reads frames straight out of them.
+type shmPeer struct {
+	t    *testing.T
+	conn *net.UnixConn
+	buf  []byte
+	fds  []int
+
+	ring     []byte
+	slotSize int
+}
+
+type shmPeerMessage struct {
+	Type     string     `json:"type"`
+	SlotSize int        `json:"slot_size"`
+	Slots    int        `json:"slots"`
+	Frames   []shmFrame `json:"frames"`
+	shmFrame
+}
+
+func newShmPair(t *testing.T, slots int) (*shmConn, *shmPeer) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "shm.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := dialShm(context.Background(), path, config.ShmConfig{Slots: slots}, &encodeMeter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &shmPeer{t: t, conn: server}
+	t.Cleanup(func() {
+		conn.Close(false)
+		server.Close()
+		if p.ring != nil {
+			unix.Munmap(p.ring)
+		}
+	})
+	return conn.(*shmConn), p
+}
+
+// next returns the next frame or batch message, mapping any ring sent first.
+func (p *shmPeer) next() shmPeerMessage {
+	p.t.Helper()
+
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			data, oob := make([]byte, 4096), make([]byte, unix.CmsgSpace(4))
+			n, oobn, _, _, err := p.conn.ReadMsgUnix(data, oob)
+			if err != nil {
+				p.t.Fatal(err)
+			}
+			if oobn > 0 {
+				msgs, _ := unix.ParseSocketControlMessage(oob[:oobn])
+				for _, m := range msgs {
+					fds, _ := unix.ParseUnixRights(&m)
+					p.fds = append(p.fds, fds...)
+				}
+			}
+			p.buf = append(p.buf, data[:n]...)
+			continue
+		}
+
+		var msg shmPeerMessage
+		if err := json.Unmarshal(p.buf[:i], &msg); err != nil {
+			p.t.Fatal(err)
+		}
+		p.buf = p.buf[i+1:]
+
+		if msg.Type != msgRing {
+			return msg
+		}
+
+		fd := p.fds[0]
+		p.fds = p.fds[1:]
+		ring, err := unix.Mmap(fd, 0, msg.Slots*msg.SlotSize, unix.PROT_READ, unix.MAP_SHARED)
+		unix.Close(fd)
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		if p.ring != nil {
+			unix.Munmap(p.ring)
+		}
+		p.ring, p.slotSize = ring, msg.SlotSize
+	}
+}
+
+// pixel returns the first pixel of slot.
+func (p *shmPeer) pixel(slot int) [3]byte {
+	return [3]byte(p.ring[slot*p.slotSize:])
+}
+
+func (p *shmPeer) send(msg string) {
+	p.t.Helper()
+	if _, err := p.conn.Write([]byte(msg + "\n")); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// answer sends msg and has the client read it.
+func answer(t *testing.T, c *shmConn, p *shmPeer, msg string) {
+	t.Helper()
+	p.send(msg)
+	if _, err := c.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShmSlotsFreedByFrame(t *testing.T) {
+	c, p := newShmPair(t, 2)
+	red := testFrame(4, 4, color.RGBA{255, 0, 0, 255})
+	green := testFrame(4, 4, color.RGBA{0, 255, 0, 255})
+	blue := testFrame(4, 4, color.RGBA{0, 0, 255, 255})
+
+	if _, err := c.WriteFrame(red, config.EncodingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	first := p.next()
+	if _, err := c.WriteFrame(green, config.EncodingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	second := p.next()
+
+	// The second frame is answered first: only its slot may be reused.
+	answer(t, c, p, `{"type":"results","frame_id":1,"results":[]}`)
+
+	if _, err := c.WriteFrame(blue, config.EncodingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	third := p.next()
+	if third.Slot != second.Slot {
+		t.Errorf("third frame went to slot %d, want the answered slot %d", third.Slot, second.Slot)
+	}
+	if got := p.pixel(first.Slot); got != [3]byte{255, 0, 0} {
+		t.Errorf("unanswered frame overwritten with %v", got)
+	}
+
+	// Both slots are held, so the ring is full.
+	if _, err := c.WriteFrame(red, config.EncodingConfig{}); err == nil || !strings.Contains(err.Error(), "full") {
+		t.Fatalf("write to a full ring: %v", err)
+	}
+
+	// A bare array answers the oldest frame.
+	answer(t, c, p, `[]`)
+	if _, err := c.WriteFrame(green, config.EncodingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if fourth := p.next(); fourth.Slot != first.Slot {
+		t.Errorf("fourth frame went to slot %d, want %d", fourth.Slot, first.Slot)
+	}
+}
+
+func TestShmBatchSlots(t *testing.T) {
+	c, p := newShmPair(t, 3)
+	frames := []image.Image{
+		testFrame(2, 2, color.RGBA{1, 0, 0, 255}),
+		testFrame(2, 2, color.RGBA{2, 0, 0, 255}),
+	}
+
+	if _, err := c.WriteBatch(frames, []uint64{0, 1}, config.EncodingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A larger frame grows the ring before anything is copied, so the whole
+	// batch lands in the new ring.
+	frames = append(frames, testFrame(8, 8, color.RGBA{3, 0, 0, 255}))
+	answer(t, c, p, `{"type":"results","frame_id":0,"results":[]}`)
+	answer(t, c, p, `{"type":"results","frame_id":1,"results":[]}`)
+	if _, err := c.WriteBatch(frames, []uint64{2, 3, 4}, config.EncodingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	p.next()
+	batch := p.next()
+	for i, f := range batch.Frames {
+		if got := p.pixel(f.Slot); got[0] != byte(i+1) || f.FrameID == nil || *f.FrameID != uint64(i+2) {
+			t.Errorf("batch frame %d: id %v, pixel %v", i, f.FrameID, got)
+		}
+	}
+
+	// A response missing frames settles the whole batch.
+	answer(t, c, p, `{"type":"batch","results":[{"frame_id":3,"results":[]}]}`)
+	if len(c.free) != 3 || len(c.held) != 0 {
+		t.Errorf("free %v, held %+v after the batch response", c.free, c.held)
+	}
+
+	// Slots reserved for a write that fails are given back.
+	c.Close(false)
+	if _, err := c.WriteFrame(frames[0], config.EncodingConfig{}); err == nil {
+		t.Fatal("write after Close succeeded")
+	}
+	if len(c.free) != 3 {
+		t.Errorf("free %v after a failed write", c.free)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package processing
+
+import (
+	"context"
+	"errors"
+
+	"vision/internal/config"
+)
+
+var errShmUnsupported = errors.New("the shm detector transport is only available on linux")
+
+func shmSupported() error {
+	return errShmUnsupported
+}
+
+func dialShm(context.Context, string, config.ShmConfig, *encodeMeter) (detectorConn, error) {
+	return nil, errShmUnsupported
+}
@@ -0,0 +1,77 @@
+package processing
+
+import (
+	"image"
+	"slices"
+	"time"
+
+	"vision/internal/models"
+)
+
+// FrameResult is what the processor learned about one frame. Frame is the
+// image the detections were made on, or nil when it is no longer held.
+// Source names the streamer of the run, as config.Config.SourceName, and
+// RunStarted tells runs apart; track IDs are only unique within a run.
+type FrameResult struct {
+	ID         uint64
+	At         time.Time
+	Source     string
+	RunStarted time.Time
+	Frame      image.Image
+	Detections []models.DetectionResult
+	Counts     ZoneCounts
+}
+
+// Sink receives the results of every frame the detector processed and every
+// alert. Both are called from the processor's results goroutine, so a sink
+// must hand slow work such as network or disk I/O to its own goroutine.
+type Sink interface {
+	HandleResults(r FrameResult)
+	HandleAlert(a Alert)
+}
+
+// FrameSink is implemented by sinks that also want every captured frame,
+// including those the detector never sees. HandleFrame is called from the
+// capture goroutine and must not block.
+type FrameSink interface {
+	HandleFrame(id uint64, frame image.Image)
+}
+
+// AddSink registers s for all following results, across runs.
+func (p *Processor) AddSink(s Sink) {
+	p.sinkMu.Lock()
+	defer p.sinkMu.Unlock()
+	p.sinks = append(p.sinks, s)
+}
+
+func (p *Processor) RemoveSink(s Sink) {
+	p.sinkMu.Lock()
+	defer p.sinkMu.Unlock()
+	p.sinks = slices.DeleteFunc(p.sinks, func(x Sink) bool { return x == s })
+}
+
+func (p *Processor) dispatchResults(r FrameResult) {
+	p.sinkMu.RLock()
+	defer p.sinkMu.RUnlock()
+	for _, s := range p.sinks {
+		s.HandleResults(r)
+	}
+}
+
+func (p *Processor) dispatchFrame(id uint64, frame image.Image) {
+	p.sinkMu.RLock()
+	defer p.sinkMu.RUnlock()
+	for _, s := range p.sinks {
+		if fs, ok := s.(FrameSink); ok {
+			fs.HandleFrame(id, frame)
+		}
+	}
+}
+
+func (p *Processor) dispatchAlert(a Alert) {
+	p.sinkMu.RLock()
+	defer p.sinkMu.RUnlock()
+	for _, s := range p.sinks {
+		s.HandleAlert(a)
+	}
+}
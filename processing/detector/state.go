@@ -0,0 +1,33 @@
+package processing
+
+import "time"
+
+type ConnState int
+
+const (
+	StateDisconnected ConnState = iota
+	StateConnecting
+	StateConnected
+	StateDegraded
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "Connecting"
+	case StateConnected:
+		return "Connected"
+	case StateDegraded:
+		return "Degraded"
+	default:
+		return "Disconnected"
+	}
+}
+
+// ConnStatus is a snapshot of the detector connection. LastError keeps the
+// most recent failure even after the connection has recovered.
+type ConnStatus struct {
+	State     ConnState
+	LastError error
+	Since     time.Time
+}
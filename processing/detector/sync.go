@@ -0,0 +1,112 @@
+package processing
+
+import (
+	"image"
+	"slices"
+	"sync"
+	"time"
+
+	"vision/internal/models"
+)
+
+const defaultSyncMaxDelay = 500 * time.Millisecond
+
+// AnnotatedFrame is a frame together with the detections made on that very
+// frame. Missed is set when its results never came or came too late, in
+// which case Detections is nil.
+type AnnotatedFrame struct {
+	ID         uint64
+	Frame      image.Image
+	Detections []models.DetectionResult
+	Missed     bool
+
+	// Delay is how long the frame was held back waiting for its results.
+	Delay time.Duration
+}
+
+// frameSync holds frames sent to the detector until their results arrive.
+// Results may come in any order, but frames leave in capture order: a frame
+// is released once it and every older frame have their results, or once it
+// has waited longer than maxDelay.
+type frameSync struct {
+	mu      sync.Mutex
+	pending []heldFrame
+}
+
+type heldFrame struct {
+	id    uint64
+	frame image.Image
+	at    time.Time
+
+	resolved bool
+	results  []models.DetectionResult
+}
+
+func (s *frameSync) Hold(id uint64, frame image.Image, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, heldFrame{id: id, frame: frame, at: now})
+}
+
+// Drop forgets frame id, which was held but never reached the detector.
+func (s *frameSync) Drop(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.pending); n > 0 && s.pending[n-1].id == id {
+		s.pending = s.pending[:n-1]
+	}
+}
+
+func (s *frameSync) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = nil
+}
+
+// Resolve pairs results with frame id, nil results marking the frame as
+// failed. It returns that frame, or nil if it is no longer held, along with
+// the frames now released.
+func (s *frameSync) Resolve(id uint64, results []models.DetectionResult, now time.Time) (image.Image, []AnnotatedFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.pending, func(f heldFrame) bool { return f.id == id })
+	if i < 0 {
+		return nil, nil
+	}
+	s.pending[i].resolved = true
+	s.pending[i].results = results
+
+	frame := s.pending[i].frame
+	return frame, s.release(now, 0)
+}
+
+// Expire releases frames that have waited longer than maxDelay, and the
+// resolved frames behind them.
+func (s *frameSync) Expire(now time.Time, maxDelay time.Duration) []AnnotatedFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.release(now, maxDelay)
+}
+
+// release takes resolved frames off the front, and with a maxDelay also
+// those that waited longer.
+func (s *frameSync) release(now time.Time, maxDelay time.Duration) []AnnotatedFrame {
+	var out []AnnotatedFrame
+	for len(s.pending) > 0 {
+		f := s.pending[0]
+		if !f.resolved && (maxDelay <= 0 || now.Sub(f.at) <= maxDelay) {
+			break
+		}
+		s.pending = s.pending[1:]
+
+		af := AnnotatedFrame{ID: f.id, Frame: f.frame, Delay: now.Sub(f.at)}
+		if f.results != nil {
+			af.Detections = f.results
+		} else {
+			af.Missed = true
+		}
+		out = append(out, af)
+	}
+	return out
+}
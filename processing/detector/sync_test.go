@@ -0,0 +1,96 @@
+package processing
+
+import (
+	"image"
+	"slices"
+	"testing"
+	"time"
+
+	"vision/internal/models"
+)
+
+func releasedIDs(frames []AnnotatedFrame) []uint64 {
+	var ids []uint64
+	for _, f := range frames {
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+func TestFrameSyncOutOfOrder(t *testing.T) {
+	var s frameSync
+	start := time.Now()
+	frames := map[uint64]image.Image{}
+
+	for id := range uint64(4) {
+		frames[id] = image.NewRGBA(image.Rect(0, 0, 1, 1+int(id)))
+		s.Hold(id, frames[id], start)
+	}
+	dets := func(class string) []models.DetectionResult {
+		return []models.DetectionResult{{Class: class}}
+	}
+
+	// Frame 2 answered first: it waits for 0 and 1.
+	frame, released := s.Resolve(2, dets("two"), start.Add(10*time.Millisecond))
+	if frame != frames[2] {
+		t.Error("Resolve(2) returned the wrong frame")
+	}
+	if len(released) != 0 {
+		t.Fatalf("released %v ahead of older frames", releasedIDs(released))
+	}
+
+	_, released = s.Resolve(0, dets("zero"), start.Add(20*time.Millisecond))
+	if !slices.Equal(releasedIDs(released), []uint64{0}) {
+		t.Fatalf("released %v, want 0", releasedIDs(released))
+	}
+
+	// A failed frame is released as missed, along with the frame behind it.
+	_, released = s.Resolve(1, nil, start.Add(30*time.Millisecond))
+	if !slices.Equal(releasedIDs(released), []uint64{1, 2}) {
+		t.Fatalf("released %v, want 1, 2", releasedIDs(released))
+	}
+	if !released[0].Missed || released[0].Detections != nil {
+		t.Errorf("failed frame = %+v", released[0])
+	}
+	if r := released[1]; r.Missed || r.Detections[0].Class != "two" || r.Frame != frames[2] || r.Delay != 30*time.Millisecond {
+		t.Errorf("frame 2 = %+v", r)
+	}
+
+	if frame, released := s.Resolve(2, dets("again"), start.Add(40*time.Millisecond)); frame != nil || released != nil {
+		t.Error("frame 2 resolved twice")
+	}
+}
+
+func TestFrameSyncExpire(t *testing.T) {
+	var s frameSync
+	start := time.Now()
+	const maxDelay = 500 * time.Millisecond
+
+	s.Hold(1, nil, start)
+	s.Hold(2, nil, start.Add(100*time.Millisecond))
+	s.Hold(3, nil, start.Add(200*time.Millisecond))
+	s.Resolve(2, []models.DetectionResult{}, start.Add(150*time.Millisecond))
+
+	if released := s.Expire(start.Add(maxDelay), maxDelay); len(released) != 0 {
+		t.Fatalf("released %v before maxDelay", releasedIDs(released))
+	}
+
+	// Frame 1 expires, frame 2 already has its results, frame 3 waits on.
+	released := s.Expire(start.Add(maxDelay+time.Millisecond), maxDelay)
+	if !slices.Equal(releasedIDs(released), []uint64{1, 2}) {
+		t.Fatalf("released %v, want 1, 2", releasedIDs(released))
+	}
+	if !released[0].Missed || released[1].Missed {
+		t.Errorf("released %+v", released)
+	}
+
+	// Results for an expired frame find nothing.
+	if frame, released := s.Resolve(1, []models.DetectionResult{}, start.Add(time.Second)); frame != nil || released != nil {
+		t.Error("expired frame resolved")
+	}
+
+	s.Drop(3)
+	if released := s.Expire(start.Add(time.Hour), maxDelay); len(released) != 0 {
+		t.Errorf("dropped frame released: %v", releasedIDs(released))
+	}
+}
@@ -0,0 +1,213 @@
+package processing
+
+import (
+	"sort"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// Noise levels of the box filters, in normalised frame units squared. A box
+// edge jitters by about 1% of the frame between detections.
+const (
+	trackMeasureNoise  = 1e-4
+	trackProcessNoise  = 1e-5
+	trackInitPosVar    = 1e-3
+	trackInitVelVar    = 1e-2
+	trackMinBoxSize    = 1e-3
+	defaultTrackMaxAge = 5
+)
+
+// Tracker follows objects across frames in the manner of SORT: every track
+// predicts its box with a constant-velocity Kalman filter, detections are
+// matched greedily to the predictions by IoU, and unmatched detections start
+// new tracks. Only confirmed tracks hand out IDs. A Tracker is not safe for
+// concurrent use.
+type Tracker struct {
+	cfg    config.TrackingConfig
+	tracks []*track
+	nextID uint64
+}
+
+type track struct {
+	id    uint64
+	class string
+
+	// cx, cy, w and h are filtered independently, each with its velocity.
+	kf [4]kalman1D
+
+	hits  int
+	stale int
+}
+
+func NewTracker(cfg config.TrackingConfig) *Tracker {
+	if cfg.MaxAge < 1 {
+		cfg.MaxAge = defaultTrackMaxAge
+	}
+	return &Tracker{cfg: cfg, nextID: 1}
+}
+
+// Update advances every track by one frame and returns dets with the IDs of
+// the confirmed tracks they belong to. dets itself is not modified.
+func (t *Tracker) Update(dets []models.DetectionResult) []models.DetectionResult {
+	out := make([]models.DetectionResult, len(dets))
+	copy(out, dets)
+
+	predicted := make([][4]float32, len(t.tracks))
+	for i, tr := range t.tracks {
+		predicted[i] = tr.predict()
+	}
+
+	type pair struct {
+		track, det int
+		iou        float32
+	}
+
+	var pairs []pair
+	for ti, tr := range t.tracks {
+		for di, d := range out {
+			if d.Class != tr.class || len(d.Box) < 4 {
+				continue
+			}
+			if iou := boxIoU(predicted[ti], boxOf(d)); iou >= t.cfg.IoU {
+				pairs = append(pairs, pair{ti, di, iou})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].iou > pairs[j].iou })
+
+	trackUsed := make([]bool, len(t.tracks))
+	detUsed := make([]bool, len(out))
+
+	for _, p := range pairs {
+		if trackUsed[p.track] || detUsed[p.det] {
+			continue
+		}
+		trackUsed[p.track], detUsed[p.det] = true, true
+
+		tr := t.tracks[p.track]
+		tr.correct(boxOf(out[p.det]))
+
+		if tr.id == 0 && tr.hits >= t.cfg.MinHits {
+			tr.id = t.nextID
+			t.nextID++
+		}
+		out[p.det].TrackID = tr.id
+	}
+
+	kept := t.tracks[:0]
+	for i, tr := range t.tracks {
+		if !trackUsed[i] {
+			tr.stale++
+		}
+		if tr.stale <= t.cfg.MaxAge {
+			kept = append(kept, tr)
+		}
+	}
+	t.tracks = kept
+
+	for di, d := range out {
+		if detUsed[di] || len(d.Box) < 4 {
+			continue
+		}
+
+		tr := newTrack(d.Class, boxOf(d))
+		if t.cfg.MinHits <= 1 {
+			tr.id = t.nextID
+			t.nextID++
+			out[di].TrackID = tr.id
+		}
+		t.tracks = append(t.tracks, tr)
+	}
+
+	return out
+}
+
+func newTrack(class string, box [4]float32) *track {
+	tr := &track{class: class, hits: 1}
+	for i, v := range toCenter(box) {
+		tr.kf[i] = kalman1D{x: v, p00: trackInitPosVar, p11: trackInitVelVar}
+	}
+	return tr
+}
+
+func (tr *track) predict() [4]float32 {
+	var c [4]float32
+	for i := range tr.kf {
+		tr.kf[i].predict(trackProcessNoise)
+		c[i] = tr.kf[i].x
+	}
+	c[2] = max(c[2], trackMinBoxSize)
+	c[3] = max(c[3], trackMinBoxSize)
+	return fromCenter(c)
+}
+
+func (tr *track) correct(box [4]float32) {
+	for i, v := range toCenter(box) {
+		tr.kf[i].correct(v, trackMeasureNoise)
+	}
+	tr.hits++
+	tr.stale = 0
+}
+
+// kalman1D is a constant-velocity Kalman filter over a position x and its
+// velocity v per frame, with covariance [[p00, p01], [p10, p11]].
+type kalman1D struct {
+	x, v               float32
+	p00, p01, p10, p11 float32
+}
+
+func (k *kalman1D) predict(q float32) {
+	k.x += k.v
+
+	p00 := k.p00 + k.p01 + k.p10 + k.p11 + q
+	p01 := k.p01 + k.p11
+	p10 := k.p10 + k.p11
+	p11 := k.p11 + q
+
+	k.p00, k.p01, k.p10, k.p11 = p00, p01, p10, p11
+}
+
+func (k *kalman1D) correct(z, r float32) {
+	s := k.p00 + r
+	k0, k1 := k.p00/s, k.p10/s
+
+	y := z - k.x
+	k.x += k0 * y
+	k.v += k1 * y
+
+	p00 := (1 - k0) * k.p00
+	p01 := (1 - k0) * k.p01
+	p10 := k.p10 - k1*k.p00
+	p11 := k.p11 - k1*k.p01
+
+	k.p00, k.p01, k.p10, k.p11 = p00, p01, p10, p11
+}
+
+// boxOf returns a detection box as [y1, x1, y2, x2].
+func boxOf(d models.DetectionResult) [4]float32 {
+	return [4]float32{d.Box[0], d.Box[1], d.Box[2], d.Box[3]}
+}
+
+func toCenter(b [4]float32) [4]float32 {
+	return [4]float32{(b[1] + b[3]) / 2, (b[0] + b[2]) / 2, b[3] - b[1], b[2] - b[0]}
+}
+
+func fromCenter(c [4]float32) [4]float32 {
+	return [4]float32{c[1] - c[3]/2, c[0] - c[2]/2, c[1] + c[3]/2, c[0] + c[2]/2}
+}
+
+func boxIoU(a, b [4]float32) float32 {
+	y1, x1 := max(a[0], b[0]), max(a[1], b[1])
+	y2, x2 := min(a[2], b[2]), min(a[3], b[3])
+	if y2 <= y1 || x2 <= x1 {
+		return 0
+	}
+
+	inter := (y2 - y1) * (x2 - x1)
+	union := (a[2]-a[0])*(a[3]-a[1]) + (b[2]-b[0])*(b[3]-b[1]) - inter
+	if union <= 0 {
+		return 0
+	}
+	return inter / union
+}
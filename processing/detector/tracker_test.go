@@ -0,0 +1,110 @@
+package processing
+
+import (
+	"testing"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// moving returns a 0.1 by 0.2 box whose left edge is at x and top at y.
+func moving(class string, x, y float32) models.DetectionResult {
+	return models.DetectionResult{Class: class, Score: 0.9, Box: []float32{y, x, y + 0.2, x + 0.1}}
+}
+
+func newTestTracker() *Tracker {
+	return NewTracker(config.TrackingConfig{Enabled: true, MaxAge: 3, MinHits: 3, IoU: 0.3})
+}
+
+func TestTrackerSurvivesGap(t *testing.T) {
+	tr := newTestTracker()
+	x := float32(0.1)
+	step := func(visible bool) uint64 {
+		x += 0.02
+		if !visible {
+			tr.Update(nil)
+			return 0
+		}
+		return tr.Update([]models.DetectionResult{moving("car", x, 0.4)})[0].TrackID
+	}
+
+	// Unconfirmed until the third hit.
+	for i := range 2 {
+		if id := step(true); id != 0 {
+			t.Fatalf("frame %d: track %d confirmed early", i, id)
+		}
+	}
+	id := step(true)
+	if id == 0 {
+		t.Fatal("track not confirmed after MinHits")
+	}
+
+	// Missed for MaxAge frames, the track coasts on its velocity and picks
+	// the object up again further along.
+	for range 3 {
+		step(false)
+	}
+	if got := step(true); got != id {
+		t.Fatalf("track %d after a gap, want %d", got, id)
+	}
+
+	// One frame more than MaxAge drops it, and the object comes back as a
+	// new track, which never reuses an old ID.
+	for range 4 {
+		step(false)
+	}
+	for range 3 {
+		if got := step(true); got == id {
+			t.Fatalf("dropped track %d reused", id)
+		}
+	}
+	if got := step(true); got == 0 || got <= id {
+		t.Fatalf("new track %d, want a fresh ID above %d", got, id)
+	}
+}
+
+func TestTrackerCrossing(t *testing.T) {
+	tr := newTestTracker()
+
+	// Two cars drive past each other, one slightly lower, and a person
+	// stands where they cross.
+	var left, right uint64
+	for i := range 30 {
+		d := float32(i) * 0.02
+		out := tr.Update([]models.DetectionResult{
+			moving("car", 0.1+d, 0.4),
+			moving("car", 0.8-d, 0.45),
+			moving("person", 0.45, 0.4),
+		})
+
+		if i < 3 {
+			continue
+		}
+		if left == 0 {
+			left, right = out[0].TrackID, out[1].TrackID
+			if left == 0 || right == 0 || left == right || out[2].TrackID == left || out[2].TrackID == right {
+				t.Fatalf("frame %d: tracks %d, %d, %d", i, out[0].TrackID, out[1].TrackID, out[2].TrackID)
+			}
+			continue
+		}
+		if out[0].TrackID != left || out[1].TrackID != right {
+			t.Fatalf("frame %d: tracks %d, %d, want %d, %d", i, out[0].TrackID, out[1].TrackID, left, right)
+		}
+	}
+}
+
+func TestTrackerInput(t *testing.T) {
+	tr := NewTracker(config.TrackingConfig{MinHits: 1, IoU: 0.3})
+	dets := []models.DetectionResult{moving("car", 0.1, 0.1), {Class: "car"}}
+
+	out := tr.Update(dets)
+	if dets[0].TrackID != 0 {
+		t.Error("Update modified its input")
+	}
+	if out[0].TrackID == 0 {
+		t.Error("MinHits 1 did not confirm at once")
+	}
+	if out[1].TrackID != 0 {
+		t.Error("detection without a box tracked")
+	}
+}
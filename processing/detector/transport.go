@@ -0,0 +1,172 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"log"
+	"net/url"
+	"time"
+
+	"vision/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// detectorConn is a single connection to a detection server. Control
+// messages and results are the same JSON envelopes on every transport; only
+// the way frames travel differs.
+type detectorConn interface {
+	WriteJSON(v any) error
+
+	// WriteFrame uploads one frame, WriteBatch several frames numbered
+	// with ids. Both report which frames were sent; a frame that cannot be
+	// encoded is skipped without failing the connection.
+	WriteFrame(img image.Image, enc config.EncodingConfig) (bool, error)
+	WriteBatch(frames []image.Image, ids []uint64, enc config.EncodingConfig) ([]bool, error)
+
+	ReadMessage() ([]byte, error)
+	SetReadDeadline(t time.Time) error
+
+	Ping() error
+	SetPongHandler(h func())
+
+	// Close tears the connection down, telling the server first when
+	// graceful is set.
+	Close(graceful bool) error
+}
+
+type dialFunc func(ctx context.Context) (detectorConn, error)
+
+// newDialer returns the server address used in logs and errors, and how to
+// connect to it.
+func newDialer(host string, cfg config.DetectorConfig, meter *encodeMeter) (string, dialFunc) {
+	switch cfg.Transport {
+	case config.TransportShm:
+		return "unix://" + host, func(ctx context.Context) (detectorConn, error) {
+			return dialShm(ctx, host, cfg.Shm, meter)
+		}
+	default:
+		u := url.URL{Scheme: "ws", Host: host, Path: "/ws"}
+		return u.String(), func(ctx context.Context) (detectorConn, error) {
+			conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
+			if err != nil {
+				return nil, err
+			}
+			return &wsConn{conn: conn, meter: meter}, nil
+		}
+	}
+}
+
+func checkTransport(t config.DetectorTransport) error {
+	switch t {
+	case config.TransportWebSocket, "":
+		return nil
+	case config.TransportShm:
+		return shmSupported()
+	default:
+		return fmt.Errorf("unknown detector transport: %s", t)
+	}
+}
+
+// wsConn sends frames as binary WebSocket messages, encoded with the format
+// negotiated in the hello.
+type wsConn struct {
+	conn  *websocket.Conn
+	meter *encodeMeter
+}
+
+func (c *wsConn) WriteJSON(v any) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteJSON(v)
+}
+
+func (c *wsConn) WriteFrame(img image.Image, enc config.EncodingConfig) (bool, error) {
+	data, err := c.encode(img, enc)
+	if err != nil {
+		log.Printf("Frame encode error (%s): %v", enc.Format, err)
+		return false, nil
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return true, c.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// WriteBatch encodes frames into a single batched upload, each with its ID so
+// the response can be matched to it.
+func (c *wsConn) WriteBatch(frames []image.Image, ids []uint64, enc config.EncodingConfig) ([]bool, error) {
+	var buf bytes.Buffer
+	var header [12]byte
+	sent := make([]bool, len(frames))
+	count := 0
+
+	buf.Write(batchFrameMagic[:])
+	buf.Write(header[:4])
+
+	for i, img := range frames {
+		data, err := c.encode(img, enc)
+		if err != nil {
+			log.Printf("Frame encode error (%s): %v", enc.Format, err)
+			continue
+		}
+
+		binary.BigEndian.PutUint64(header[:8], ids[i])
+		binary.BigEndian.PutUint32(header[8:], uint32(len(data)))
+		buf.Write(header[:])
+		buf.Write(data)
+
+		sent[i] = true
+		count++
+	}
+
+	if count == 0 {
+		return sent, nil
+	}
+
+	msg := buf.Bytes()
+	binary.BigEndian.PutUint32(msg[len(batchFrameMagic):], uint32(count))
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return sent, c.conn.WriteMessage(websocket.BinaryMessage, msg)
+}
+
+func (c *wsConn) encode(img image.Image, enc config.EncodingConfig) ([]byte, error) {
+	start := time.Now()
+	data, err := encodeFrame(img, enc)
+	if err != nil {
+		return nil, err
+	}
+
+	c.meter.Observe(enc.Format, len(data), time.Since(start))
+	return data, nil
+}
+
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	_, message, err := c.conn.ReadMessage()
+	return message, err
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *wsConn) Ping() error {
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+}
+
+func (c *wsConn) SetPongHandler(h func()) {
+	c.conn.SetPongHandler(func(string) error {
+		h()
+		return nil
+	})
+}
+
+func (c *wsConn) Close(graceful bool) error {
+	if graceful {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	}
+	return c.conn.Close()
+}
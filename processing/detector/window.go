@@ -0,0 +1,166 @@
+package processing
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	windowRTTAlpha     = 0.2
+	windowMinRTTWindow = 10 * time.Second
+	windowMinTimeout   = 2 * time.Second
+)
+
+type WindowStats struct {
+	Sent     uint64
+	Dropped  uint64
+	Expired  uint64
+	InFlight int
+	Window   int
+	RTT      time.Duration
+	Rate     float64
+}
+
+// frameWindow limits how many frames may be waiting on the detector. The
+// window starts at its maximum and shrinks by one whenever the round-trip
+// time climbs well above the best one seen recently, a sign that frames are
+// queueing on the server. It grows again while round trips stay fast.
+// Results are matched to frames by ID.
+type frameWindow struct {
+	mu sync.Mutex
+
+	max  int
+	size int
+
+	inFlight []sentFrame
+
+	srtt        time.Duration
+	minRTT      time.Duration
+	minRTTSince time.Time
+
+	sent    uint64
+	dropped uint64
+	expired uint64
+}
+
+type sentFrame struct {
+	id uint64
+	at time.Time
+}
+
+func newFrameWindow(max int) *frameWindow {
+	if max < 1 {
+		max = 1
+	}
+	return &frameWindow{max: max, size: max}
+}
+
+// Reset forgets frames in flight, e.g. after the processor was restarted.
+func (w *frameWindow) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inFlight = nil
+}
+
+func (w *frameWindow) HasSlot() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.inFlight) < w.size
+}
+
+// Sent records frame id as handed to the detector. It must be called before
+// the frame is actually sent, so its result cannot arrive first; Unsend takes
+// it back if sending fails.
+func (w *frameWindow) Sent(id uint64, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inFlight = append(w.inFlight, sentFrame{id: id, at: now})
+	w.sent++
+}
+
+func (w *frameWindow) Unsend(id uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n := len(w.inFlight); n > 0 && w.inFlight[n-1].id == id {
+		w.inFlight = w.inFlight[:n-1]
+		w.sent--
+	}
+}
+
+func (w *frameWindow) Dropped() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dropped++
+}
+
+// Complete takes frame id out of flight and returns its round-trip time. It
+// returns false for a frame that is not in flight, such as one that expired.
+func (w *frameWindow) Complete(id uint64, now time.Time) (time.Duration, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	i := slices.IndexFunc(w.inFlight, func(f sentFrame) bool { return f.id == id })
+	if i < 0 {
+		return 0, false
+	}
+
+	rtt := now.Sub(w.inFlight[i].at)
+	w.inFlight = slices.Delete(w.inFlight, i, i+1)
+
+	if w.srtt == 0 {
+		w.srtt = rtt
+	} else {
+		w.srtt += time.Duration(windowRTTAlpha * float64(rtt-w.srtt))
+	}
+
+	if w.minRTT == 0 || rtt < w.minRTT || now.Sub(w.minRTTSince) > windowMinRTTWindow {
+		w.minRTT = rtt
+		w.minRTTSince = now
+	}
+
+	switch {
+	case w.srtt > 2*w.minRTT && w.size > 1:
+		w.size--
+	case w.srtt < w.minRTT*3/2 && w.size < w.max:
+		w.size++
+	}
+
+	return rtt, true
+}
+
+// Expire gives up on frames whose results are overdue, so a detector that
+// silently loses frames cannot stall the window.
+func (w *frameWindow) Expire(now time.Time) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	timeout := max(windowMinTimeout, 4*w.srtt)
+
+	n := 0
+	for n < len(w.inFlight) && now.Sub(w.inFlight[n].at) > timeout {
+		n++
+	}
+
+	w.inFlight = w.inFlight[n:]
+	w.expired += uint64(n)
+	return n
+}
+
+func (w *frameWindow) Stats() WindowStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := WindowStats{
+		Sent:     w.sent,
+		Dropped:  w.dropped,
+		Expired:  w.expired,
+		InFlight: len(w.inFlight),
+		Window:   w.size,
+		RTT:      w.srtt,
+	}
+	if w.srtt > 0 {
+		s.Rate = float64(w.size) / w.srtt.Seconds()
+	}
+	return s
+}
@@ -0,0 +1,119 @@
+package processing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrameWindowCompleteByID(t *testing.T) {
+	w := newFrameWindow(3)
+	start := time.Now()
+
+	for id := range uint64(3) {
+		w.Sent(id, start)
+	}
+	if w.HasSlot() {
+		t.Fatal("full window has a slot")
+	}
+
+	// Results may come back in any order.
+	if rtt, ok := w.Complete(2, start.Add(30*time.Millisecond)); !ok || rtt != 30*time.Millisecond {
+		t.Errorf("Complete(2) = %v, %v", rtt, ok)
+	}
+	if rtt, ok := w.Complete(0, start.Add(40*time.Millisecond)); !ok || rtt != 40*time.Millisecond {
+		t.Errorf("Complete(0) = %v, %v", rtt, ok)
+	}
+	if _, ok := w.Complete(2, start.Add(50*time.Millisecond)); ok {
+		t.Error("frame 2 completed twice")
+	}
+	if _, ok := w.Complete(7, start.Add(50*time.Millisecond)); ok {
+		t.Error("frame never sent completed")
+	}
+
+	if s := w.Stats(); s.InFlight != 1 || s.Sent != 3 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// Only the newest frame can be taken back.
+	w.Sent(3, start)
+	w.Unsend(1)
+	w.Unsend(3)
+	if s := w.Stats(); s.InFlight != 1 || s.Sent != 3 {
+		t.Errorf("stats after Unsend = %+v", s)
+	}
+}
+
+func TestFrameWindowExpire(t *testing.T) {
+	w := newFrameWindow(4)
+	start := time.Now()
+
+	w.Sent(1, start)
+	w.Sent(2, start.Add(time.Second))
+	w.Sent(3, start.Add(2*time.Second))
+
+	// Nothing is overdue before windowMinTimeout.
+	if n := w.Expire(start.Add(windowMinTimeout)); n != 0 {
+		t.Fatalf("expired %d frames early", n)
+	}
+
+	if n := w.Expire(start.Add(windowMinTimeout + 1500*time.Millisecond)); n != 2 {
+		t.Fatalf("expired %d frames, want 2", n)
+	}
+	if _, ok := w.Complete(1, start.Add(4*time.Second)); ok {
+		t.Error("expired frame completed")
+	}
+	if _, ok := w.Complete(3, start.Add(4*time.Second)); !ok {
+		t.Error("frame in flight did not complete")
+	}
+	if s := w.Stats(); s.Expired != 2 || s.InFlight != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// A slow detector stretches the timeout to four round trips.
+	w = newFrameWindow(4)
+	for id := range uint64(5) {
+		w.Sent(id, start)
+		w.Complete(id, start.Add(time.Second))
+	}
+	w.Sent(9, start)
+	if n := w.Expire(start.Add(3 * time.Second)); n != 0 {
+		t.Errorf("expired %d frames within four round trips", n)
+	}
+	if n := w.Expire(start.Add(5 * time.Second)); n != 1 {
+		t.Errorf("expired %d frames, want 1", n)
+	}
+}
+
+func TestFrameWindowAdapts(t *testing.T) {
+	w := newFrameWindow(4)
+	now := time.Now()
+	id := uint64(0)
+
+	roundTrip := func(rtt time.Duration) {
+		w.Sent(id, now)
+		now = now.Add(rtt)
+		w.Complete(id, now)
+		id++
+	}
+
+	roundTrip(10 * time.Millisecond)
+	if s := w.Stats(); s.Window != 4 {
+		t.Fatalf("window = %d, want 4 to start with", s.Window)
+	}
+
+	// Round trips well above the best one shrink the window, down to one.
+	for range 20 {
+		roundTrip(100 * time.Millisecond)
+	}
+	if s := w.Stats(); s.Window != 1 {
+		t.Fatalf("window = %d after slow round trips, want 1", s.Window)
+	}
+
+	// Fast ones let it grow back to its maximum.
+	for range 40 {
+		roundTrip(10 * time.Millisecond)
+	}
+	if s := w.Stats(); s.Window != 4 {
+		t.Fatalf("window = %d after fast round trips, want 4", s.Window)
+	}
+}
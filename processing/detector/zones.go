@@ -0,0 +1,216 @@
+package processing
+
+import (
+	"reflect"
+	"slices"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+// A track's last position is forgotten after this many updates without it,
+// so a reappearing ID does not count a crossing across the gap.
+const zoneTrackForget = 30
+
+type ZoneCount struct {
+	Name      string
+	Occupancy int
+	ByClass   map[string]int
+}
+
+type LineCount struct {
+	Name string
+	In   uint64
+	Out  uint64
+}
+
+// ZoneCounts is a snapshot of the zone occupancy in the last frame and of
+// the line crossings since the counter was created, in config order.
+type ZoneCounts struct {
+	Zones []ZoneCount
+	Lines []LineCount
+}
+
+func (c ZoneCounts) Zone(name string) (ZoneCount, bool) {
+	for _, z := range c.Zones {
+		if z.Name == name {
+			return z, true
+		}
+	}
+	return ZoneCount{}, false
+}
+
+func (c ZoneCounts) Line(name string) (LineCount, bool) {
+	for _, l := range c.Lines {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return LineCount{}, false
+}
+
+// ZoneCounter places detections in the configured zones by their center
+// point and counts tracked objects crossing the counting lines. Crossings
+// need track IDs; untracked detections only count towards occupancy. A
+// ZoneCounter is not safe for concurrent use.
+type ZoneCounter struct {
+	cfg config.ZonesConfig
+
+	occupancy []ZoneCount
+	crossings map[string]*LineCount
+
+	last  map[uint64]trackPosition
+	frame uint64
+}
+
+type trackPosition struct {
+	at    config.Point
+	frame uint64
+}
+
+func NewZoneCounter(cfg config.ZonesConfig) *ZoneCounter {
+	zc := &ZoneCounter{
+		crossings: make(map[string]*LineCount),
+		last:      make(map[uint64]trackPosition),
+	}
+	zc.SetConfig(cfg)
+	return zc
+}
+
+// SetConfig replaces the zones and lines. Lines keep their totals as long as
+// their name stays the same. An unchanged config is left alone.
+func (zc *ZoneCounter) SetConfig(cfg config.ZonesConfig) {
+	if reflect.DeepEqual(cfg, zc.cfg) {
+		return
+	}
+	zc.cfg = cfg
+
+	crossings := make(map[string]*LineCount, len(cfg.Lines))
+	for _, l := range cfg.Lines {
+		if c, ok := zc.crossings[l.Name]; ok {
+			crossings[l.Name] = c
+		} else {
+			crossings[l.Name] = &LineCount{Name: l.Name}
+		}
+	}
+	zc.crossings = crossings
+
+	zc.occupancy = nil
+}
+
+// Update counts the detections of one frame.
+func (zc *ZoneCounter) Update(dets []models.DetectionResult) {
+	zc.frame++
+
+	zc.occupancy = make([]ZoneCount, len(zc.cfg.Zones))
+	for i, z := range zc.cfg.Zones {
+		zc.occupancy[i] = ZoneCount{Name: z.Name, ByClass: make(map[string]int)}
+	}
+
+	for _, d := range dets {
+		if len(d.Box) < 4 {
+			continue
+		}
+		c := CenterOf(d)
+
+		for i, z := range zc.cfg.Zones {
+			if classMatches(z.Classes, d.Class) && InPolygon(z.Polygon, c) {
+				zc.occupancy[i].Occupancy++
+				zc.occupancy[i].ByClass[d.Class]++
+			}
+		}
+
+		if d.TrackID == 0 {
+			continue
+		}
+
+		if prev, ok := zc.last[d.TrackID]; ok {
+			for _, l := range zc.cfg.Lines {
+				if !classMatches(l.Classes, d.Class) {
+					continue
+				}
+				switch crossing(l.A, l.B, prev.at, c) {
+				case 1:
+					zc.crossings[l.Name].In++
+				case -1:
+					zc.crossings[l.Name].Out++
+				}
+			}
+		}
+		zc.last[d.TrackID] = trackPosition{at: c, frame: zc.frame}
+	}
+
+	for id, pos := range zc.last {
+		if zc.frame-pos.frame > zoneTrackForget {
+			delete(zc.last, id)
+		}
+	}
+}
+
+func (zc *ZoneCounter) Counts() ZoneCounts {
+	var counts ZoneCounts
+
+	for i, z := range zc.cfg.Zones {
+		zone := ZoneCount{Name: z.Name, ByClass: map[string]int{}}
+		if i < len(zc.occupancy) {
+			zone.Occupancy = zc.occupancy[i].Occupancy
+			for class, n := range zc.occupancy[i].ByClass {
+				zone.ByClass[class] = n
+			}
+		}
+		counts.Zones = append(counts.Zones, zone)
+	}
+
+	for _, l := range zc.cfg.Lines {
+		counts.Lines = append(counts.Lines, *zc.crossings[l.Name])
+	}
+
+	return counts
+}
+
+// CenterOf returns the center of a detection box.
+func CenterOf(d models.DetectionResult) config.Point {
+	return config.Point{X: (d.Box[1] + d.Box[3]) / 2, Y: (d.Box[0] + d.Box[2]) / 2}
+}
+
+// InPolygon reports whether p lies inside poly, using the even-odd rule.
+func InPolygon(poly []config.Point, p config.Point) bool {
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// crossing returns 1 when the move from p to q crosses the line a→b from its
+// left to its right, -1 for the opposite direction and 0 otherwise.
+func crossing(a, b, p, q config.Point) int {
+	sp, sq := side(a, b, p), side(a, b, q)
+	if sp == 0 || sq == 0 || (sp > 0) == (sq > 0) {
+		return 0
+	}
+
+	// The movement must also pass between a and b, not beside the line.
+	sa, sb := side(p, q, a), side(p, q, b)
+	if (sa > 0) == (sb > 0) {
+		return 0
+	}
+
+	if sq > 0 {
+		return 1
+	}
+	return -1
+}
+
+// side is positive when p lies to the right of a→b as seen in the image,
+// where y grows downwards.
+func side(a, b, p config.Point) float32 {
+	return (b.X-a.X)*(p.Y-a.Y) - (b.Y-a.Y)*(p.X-a.X)
+}
+
+func classMatches(classes []string, class string) bool {
+	return len(classes) == 0 || slices.Contains(classes, class)
+}
@@ -0,0 +1,145 @@
+package processing
+
+import (
+	"testing"
+
+	"vision/internal/config"
+	"vision/internal/models"
+)
+
+func pt(x, y float32) config.Point {
+	return config.Point{X: x, Y: y}
+}
+
+// at returns a small box of class centered on x, y.
+func at(class string, track uint64, x, y float32) models.DetectionResult {
+	return models.DetectionResult{Class: class, TrackID: track, Box: []float32{y - 0.05, x - 0.05, y + 0.05, x + 0.05}}
+}
+
+func TestInPolygon(t *testing.T) {
+	square := []config.Point{pt(0.2, 0.2), pt(0.6, 0.2), pt(0.6, 0.6), pt(0.2, 0.6)}
+	// An L with its notch in the top right.
+	ell := []config.Point{pt(0, 0), pt(0.5, 0), pt(0.5, 0.5), pt(1, 0.5), pt(1, 1), pt(0, 1)}
+
+	for _, tc := range []struct {
+		name string
+		poly []config.Point
+		p    config.Point
+		want bool
+	}{
+		{"square inside", square, pt(0.4, 0.4), true},
+		{"square left", square, pt(0.1, 0.4), false},
+		{"square below", square, pt(0.4, 0.7), false},
+		{"ell arm", ell, pt(0.25, 0.25), true},
+		{"ell foot", ell, pt(0.75, 0.75), true},
+		{"ell notch", ell, pt(0.75, 0.25), false},
+		{"too few points", square[:2], pt(0.4, 0.2), false},
+		{"empty", nil, pt(0.4, 0.4), false},
+	} {
+		if got := InPolygon(tc.poly, tc.p); got != tc.want {
+			t.Errorf("%s: InPolygon = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCrossing(t *testing.T) {
+	// A horizontal line drawn left to right: its right side, as seen in the
+	// image, is below it.
+	a, b := pt(0.2, 0.5), pt(0.8, 0.5)
+
+	for _, tc := range []struct {
+		name string
+		p, q config.Point
+		want int
+	}{
+		{"downwards", pt(0.5, 0.4), pt(0.5, 0.6), 1},
+		{"upwards", pt(0.5, 0.6), pt(0.5, 0.4), -1},
+		{"diagonal", pt(0.3, 0.3), pt(0.7, 0.7), 1},
+		{"beside the line", pt(0.9, 0.4), pt(0.9, 0.6), 0},
+		{"onto the line", pt(0.5, 0.4), pt(0.5, 0.5), 0},
+		{"along the line", pt(0.3, 0.5), pt(0.7, 0.5), 0},
+		{"same side", pt(0.3, 0.4), pt(0.7, 0.3), 0},
+	} {
+		if got := crossing(a, b, tc.p, tc.q); got != tc.want {
+			t.Errorf("%s: crossing = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	// Drawing the line the other way swaps in and out.
+	if got := crossing(b, a, pt(0.5, 0.4), pt(0.5, 0.6)); got != -1 {
+		t.Errorf("reversed line: crossing = %d, want -1", got)
+	}
+}
+
+func TestZoneCounterEnterLeave(t *testing.T) {
+	zc := NewZoneCounter(config.ZonesConfig{
+		Zones: []config.ZoneConfig{
+			{Name: "door", Polygon: []config.Point{pt(0.3, 0), pt(0.7, 0), pt(0.7, 1), pt(0.3, 1)}},
+			{Name: "door cars", Polygon: []config.Point{pt(0.3, 0), pt(0.7, 0), pt(0.7, 1), pt(0.3, 1)}, Classes: []string{"car"}},
+		},
+		Lines: []config.LineConfig{{Name: "gate", A: pt(0.5, 1), B: pt(0.5, 0), Classes: []string{"person"}}},
+	})
+
+	occupancy := func() (int, int) {
+		c := zc.Counts()
+		door, _ := c.Zone("door")
+		cars, _ := c.Zone("door cars")
+		return door.Occupancy, cars.Occupancy
+	}
+	gate := func() LineCount {
+		l, _ := zc.Counts().Line("gate")
+		return l
+	}
+
+	// A person walks right through the door zone and over the gate, which
+	// points up, so walking right crosses it from left to right.
+	wantDoor := []int{0, 0, 1, 1, 1, 0, 0}
+	for i, x := range []float32{0.1, 0.2, 0.35, 0.45, 0.55, 0.8, 0.9} {
+		zc.Update([]models.DetectionResult{at("person", 1, x, 0.5)})
+		if door, cars := occupancy(); door != wantDoor[i] || cars != 0 {
+			t.Errorf("x = %.2f: occupancy %d, %d, want %d, 0", x, door, cars, wantDoor[i])
+		}
+	}
+	if g := gate(); g.In != 1 || g.Out != 0 {
+		t.Errorf("after walking in: %+v", g)
+	}
+
+	// Walking back counts out. A car and an untracked person take the same
+	// route without counting.
+	for _, x := range []float32{0.6, 0.4, 0.1} {
+		zc.Update([]models.DetectionResult{
+			at("person", 1, x, 0.5),
+			at("car", 2, x, 0.5),
+			at("person", 0, x, 0.5),
+		})
+	}
+	if g := gate(); g.In != 1 || g.Out != 1 {
+		t.Errorf("after walking out: %+v", g)
+	}
+
+	// Occupancy is per frame; the car is counted by both zones.
+	zc.Update([]models.DetectionResult{at("car", 2, 0.5, 0.5), at("person", 3, 0.5, 0.2)})
+	if door, cars := occupancy(); door != 2 || cars != 1 {
+		t.Errorf("occupancy %d, %d, want 2, 1", door, cars)
+	}
+	zc.Update(nil)
+	if door, cars := occupancy(); door != 0 || cars != 0 {
+		t.Errorf("empty frame: occupancy %d, %d", door, cars)
+	}
+
+	// A track that went missing for too long is not counted across the gap.
+	zc.Update([]models.DetectionResult{at("person", 4, 0.4, 0.5)})
+	for range zoneTrackForget + 1 {
+		zc.Update(nil)
+	}
+	zc.Update([]models.DetectionResult{at("person", 4, 0.6, 0.5)})
+	if g := gate(); g.In != 1 {
+		t.Errorf("crossing counted across a gap: %+v", g)
+	}
+
+	// Totals survive a config change that keeps the line's name.
+	zc.SetConfig(config.ZonesConfig{Lines: []config.LineConfig{{Name: "gate", A: pt(0.5, 0), B: pt(0.5, 1)}}})
+	if g := gate(); g.In != 1 || g.Out != 1 {
+		t.Errorf("after SetConfig: %+v", g)
+	}
+}
@@ -0,0 +1,4 @@
+package history
+
+// The pure-Go SQLite driver keeps the build free of cgo.
+import _ "modernc.org/sqlite"
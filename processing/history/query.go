@@ -0,0 +1,213 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"vision/internal/models"
+)
+
+const defaultQueryLimit = 500
+
+// Filter narrows a query. Zero fields match everything; Class matches the
+// rule name for alerts. Results are returned newest first, at most Limit of
+// them.
+type Filter struct {
+	Class    string
+	Source   string
+	From     time.Time
+	To       time.Time
+	MinScore float32
+	Limit    int
+}
+
+type Detection struct {
+	At     time.Time
+	Source string
+	Frame  uint64
+	models.DetectionResult
+}
+
+// Track is one tracked object over its lifetime.
+type Track struct {
+	ID        uint64
+	Source    string
+	Class     string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	MaxScore  float32
+	Frames    int
+}
+
+type Alert struct {
+	At         time.Time
+	Source     string
+	Rule       string
+	Frame      uint64
+	Count      int
+	Detections []models.DetectionResult
+}
+
+// where builds the WHERE clause for f over the given time column, and the
+// name of the column Class is matched against.
+func (f Filter) where(tsCol, classCol string, withScore bool) (string, []any) {
+	var conds []string
+	var args []any
+
+	if f.Class != "" {
+		conds = append(conds, classCol+" = ?")
+		args = append(args, f.Class)
+	}
+	if f.Source != "" {
+		conds = append(conds, "source = ?")
+		args = append(args, f.Source)
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, tsCol+" >= ?")
+		args = append(args, f.From.UnixMilli())
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, tsCol+" < ?")
+		args = append(args, f.To.UnixMilli())
+	}
+	if withScore && f.MinScore > 0 {
+		conds = append(conds, "score >= ?")
+		args = append(args, f.MinScore)
+	}
+
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 {
+		return defaultQueryLimit
+	}
+	return f.Limit
+}
+
+func (s *Store) Detections(ctx context.Context, f Filter) ([]Detection, error) {
+	where, args := f.where("ts", "class", true)
+	rows, err := s.db.QueryContext(ctx, `SELECT ts, source, frame, class, score, y1, x1, y2, x2, track_id
+		FROM detections`+where+` ORDER BY ts DESC, id DESC LIMIT ?`, append(args, f.limit())...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Detection
+	for rows.Next() {
+		var d Detection
+		var ts int64
+		var box [4]sql.NullFloat64
+		var track sql.NullInt64
+
+		if err := rows.Scan(&ts, &d.Source, &d.Frame, &d.Class, &d.Score,
+			&box[0], &box[1], &box[2], &box[3], &track); err != nil {
+			return nil, err
+		}
+
+		d.At = time.UnixMilli(ts)
+		if box[0].Valid {
+			d.Box = []float32{float32(box[0].Float64), float32(box[1].Float64), float32(box[2].Float64), float32(box[3].Float64)}
+		}
+		d.TrackID = uint64(track.Int64)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// Tracks returns the tracked objects seen within the filter's time range.
+func (s *Store) Tracks(ctx context.Context, f Filter) ([]Track, error) {
+	where, args := f.where("last_seen", "class", false)
+	if f.MinScore > 0 {
+		if where == "" {
+			where = " WHERE max_score >= ?"
+		} else {
+			where += " AND max_score >= ?"
+		}
+		args = append(args, f.MinScore)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT track_id, source, class, first_seen, last_seen, max_score, frames
+		FROM tracks`+where+` ORDER BY last_seen DESC LIMIT ?`, append(args, f.limit())...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Track
+	for rows.Next() {
+		var t Track
+		var first, last int64
+		if err := rows.Scan(&t.ID, &t.Source, &t.Class, &first, &last, &t.MaxScore, &t.Frames); err != nil {
+			return nil, err
+		}
+		t.FirstSeen, t.LastSeen = time.UnixMilli(first), time.UnixMilli(last)
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) Alerts(ctx context.Context, f Filter) ([]Alert, error) {
+	where, args := f.where("ts", "rule", false)
+	rows, err := s.db.QueryContext(ctx, `SELECT ts, source, rule, frame, count, detections
+		FROM alerts`+where+` ORDER BY ts DESC, id DESC LIMIT ?`, append(args, f.limit())...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Alert
+	for rows.Next() {
+		var a Alert
+		var ts int64
+		var dets string
+		if err := rows.Scan(&ts, &a.Source, &a.Rule, &a.Frame, &a.Count, &dets); err != nil {
+			return nil, err
+		}
+		a.At = time.UnixMilli(ts)
+		if err := json.Unmarshal([]byte(dets), &a.Detections); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// LastSeen returns when a detection matching f was last recorded, and false
+// if there is none.
+func (s *Store) LastSeen(ctx context.Context, f Filter) (time.Time, bool, error) {
+	where, args := f.where("ts", "class", true)
+
+	var ts sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT max(ts) FROM detections`+where, args...).Scan(&ts)
+	if err != nil || !ts.Valid {
+		return time.Time{}, false, err
+	}
+	return time.UnixMilli(ts.Int64), true, nil
+}
+
+// Classes returns every class on record, for filter choices.
+func (s *Store) Classes(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT class FROM detections ORDER BY class`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
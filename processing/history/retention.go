@@ -0,0 +1,112 @@
+package history
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// pruneChunk is the share of the detections deleted per round while the
+// database is over its size limit.
+const pruneChunk = 0.1
+
+// prune applies the retention limits. Freed pages are handed back to the
+// file system, so the file shrinks along with the data.
+func (s *Store) prune() {
+	hc := s.cfg.GetHistory()
+	ctx := context.Background()
+
+	if hc.MaxAgeHours > 0 {
+		cutoff := time.Now().Add(-time.Duration(hc.MaxAgeHours) * time.Hour).UnixMilli()
+		if err := s.deleteBefore(ctx, cutoff); err != nil {
+			log.Print("History prune error:", err)
+			return
+		}
+	}
+
+	if hc.MaxSizeMB > 0 {
+		limit := int64(hc.MaxSizeMB) << 20
+		for {
+			size, err := s.dataSize(ctx)
+			if err != nil {
+				log.Print("History prune error:", err)
+				return
+			}
+			if size <= limit {
+				break
+			}
+
+			cutoff, ok, err := s.chunkCutoff(ctx)
+			if err != nil {
+				log.Print("History prune error:", err)
+				return
+			}
+			if !ok {
+				break
+			}
+			if err := s.deleteBefore(ctx, cutoff); err != nil {
+				log.Print("History prune error:", err)
+				return
+			}
+		}
+	}
+
+	if _, err := s.db.ExecContext(ctx, "PRAGMA incremental_vacuum"); err != nil {
+		log.Print("History prune error:", err)
+	}
+}
+
+func (s *Store) deleteBefore(ctx context.Context, cutoff int64) error {
+	for _, q := range []string{
+		"DELETE FROM detections WHERE ts < ?",
+		"DELETE FROM tracks WHERE last_seen < ?",
+		"DELETE FROM alerts WHERE ts < ?",
+	} {
+		if _, err := s.db.ExecContext(ctx, q, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataSize is the space taken by live pages, not counting free ones.
+func (s *Store) dataSize(ctx context.Context) (int64, error) {
+	var pages, free, pageSize int64
+	if err := s.db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := s.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free); err != nil {
+		return 0, err
+	}
+	if err := s.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return (pages - free) * pageSize, nil
+}
+
+// chunkCutoff returns the time before which the oldest pruneChunk of the
+// detections lie, and false when there is nothing left to delete.
+func (s *Store) chunkCutoff(ctx context.Context) (int64, bool, error) {
+	var n int64
+	if err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM detections").Scan(&n); err != nil {
+		return 0, false, err
+	}
+	if n == 0 {
+		return 0, false, nil
+	}
+
+	offset := max(int64(float64(n)*pruneChunk), 1)
+
+	var ts int64
+	err := s.db.QueryRowContext(ctx, "SELECT ts FROM detections ORDER BY ts LIMIT 1 OFFSET ?", min(offset, n-1)).Scan(&ts)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Delete at least the oldest timestamp, so every round makes progress.
+	var oldest int64
+	if err := s.db.QueryRowContext(ctx, "SELECT min(ts) FROM detections").Scan(&oldest); err != nil {
+		return 0, false, err
+	}
+	return max(ts, oldest+1), true, nil
+}
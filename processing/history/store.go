@@ -0,0 +1,297 @@
+// Package history keeps detections, tracks and alerts in an SQLite database
+// so they can be looked up after the fact.
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+)
+
+const (
+	storeQueueSize     = 1024
+	storeFlushInterval = 500 * time.Millisecond
+	storeFlushBatch    = 256
+	storePruneInterval = 5 * time.Minute
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS detections (
+	id       INTEGER PRIMARY KEY,
+	ts       INTEGER NOT NULL,
+	source   TEXT    NOT NULL,
+	frame    INTEGER NOT NULL,
+	class    TEXT    NOT NULL,
+	score    REAL    NOT NULL,
+	y1       REAL, x1 REAL, y2 REAL, x2 REAL,
+	run      INTEGER NOT NULL,
+	track_id INTEGER
+);
+CREATE INDEX IF NOT EXISTS detections_ts ON detections (ts);
+CREATE INDEX IF NOT EXISTS detections_class_ts ON detections (class, ts);
+CREATE INDEX IF NOT EXISTS detections_source_ts ON detections (source, ts);
+
+CREATE TABLE IF NOT EXISTS tracks (
+	run        INTEGER NOT NULL,
+	track_id   INTEGER NOT NULL,
+	source     TEXT    NOT NULL,
+	class      TEXT    NOT NULL,
+	first_seen INTEGER NOT NULL,
+	last_seen  INTEGER NOT NULL,
+	max_score  REAL    NOT NULL,
+	frames     INTEGER NOT NULL,
+	PRIMARY KEY (run, track_id)
+);
+CREATE INDEX IF NOT EXISTS tracks_last_seen ON tracks (last_seen);
+CREATE INDEX IF NOT EXISTS tracks_class_last_seen ON tracks (class, last_seen);
+CREATE INDEX IF NOT EXISTS tracks_source_last_seen ON tracks (source, last_seen);
+
+CREATE TABLE IF NOT EXISTS alerts (
+	id         INTEGER PRIMARY KEY,
+	ts         INTEGER NOT NULL,
+	source     TEXT    NOT NULL,
+	rule       TEXT    NOT NULL,
+	frame      INTEGER NOT NULL,
+	count      INTEGER NOT NULL,
+	detections TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS alerts_ts ON alerts (ts);
+CREATE INDEX IF NOT EXISTS alerts_rule_ts ON alerts (rule, ts);
+`
+
+// Store records results and alerts as a processor sink and answers queries
+// about them. Writes are batched in transactions by a background goroutine;
+// queries may run concurrently with them.
+type Store struct {
+	db  *sql.DB
+	cfg *config.Config
+
+	queue chan record
+	stop  chan struct{}
+	done  chan struct{}
+
+	// source is the source of the newest results, which alerts are filed
+	// under.
+	source atomic.Value
+
+	dropped atomic.Uint64
+}
+
+// record is either a frame's results or an alert.
+type record struct {
+	results *processing.FrameResult
+	alert   *processing.Alert
+	source  string
+}
+
+var _ processing.Sink = (*Store)(nil)
+
+// Open opens or creates the database at the configured path. Retention is
+// read from cfg on every pass, so it can be changed while running.
+func Open(cfg *config.Config) (*Store, error) {
+	hc := cfg.GetHistory()
+
+	// A single connection serialises writers and readers, which SQLite
+	// would do anyway, and keeps the pragmas in effect for every query.
+	db, err := sql.Open("sqlite", hc.Path)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{
+		"PRAGMA auto_vacuum = INCREMENTAL",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		"PRAGMA busy_timeout = 5000",
+		schema,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("history: %w", err)
+		}
+	}
+
+	s := &Store{
+		db:    db,
+		cfg:   cfg,
+		queue: make(chan record, storeQueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	s.source.Store("")
+
+	go s.run()
+	return s, nil
+}
+
+func (s *Store) HandleResults(r processing.FrameResult) {
+	s.source.Store(r.Source)
+
+	// Images are not stored, so don't keep them alive in the queue.
+	r.Frame = nil
+	s.enqueue(record{results: &r})
+}
+
+func (s *Store) HandleAlert(a processing.Alert) {
+	a.Snapshot = nil
+	s.enqueue(record{alert: &a, source: s.source.Load().(string)})
+}
+
+// Dropped returns how many records were lost because the writer fell behind.
+func (s *Store) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close writes out the queued records and closes the database.
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.db.Close()
+}
+
+func (s *Store) enqueue(r record) {
+	select {
+	case s.queue <- r:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *Store) run() {
+	defer close(s.done)
+
+	flush := time.NewTicker(storeFlushInterval)
+	defer flush.Stop()
+
+	prune := time.NewTicker(storePruneInterval)
+	defer prune.Stop()
+
+	s.prune()
+
+	var batch []record
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.write(batch); err != nil {
+			log.Print("History write error:", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case r := <-s.queue:
+			batch = append(batch, r)
+			if len(batch) >= storeFlushBatch {
+				write()
+			}
+
+		case <-flush.C:
+			write()
+
+		case <-prune.C:
+			write()
+			s.prune()
+
+		case <-s.stop:
+			for {
+				select {
+				case r := <-s.queue:
+					batch = append(batch, r)
+				default:
+					write()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Store) write(batch []record) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insDet, err := tx.PrepareContext(ctx, `INSERT INTO detections
+		(ts, source, frame, class, score, y1, x1, y2, x2, run, track_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insDet.Close()
+
+	upTrack, err := tx.PrepareContext(ctx, `INSERT INTO tracks
+		(run, track_id, source, class, first_seen, last_seen, max_score, frames)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 1)
+		ON CONFLICT (run, track_id) DO UPDATE SET
+			last_seen = excluded.last_seen,
+			max_score = max(max_score, excluded.max_score),
+			frames = frames + 1`)
+	if err != nil {
+		return err
+	}
+	defer upTrack.Close()
+
+	insAlert, err := tx.PrepareContext(ctx, `INSERT INTO alerts
+		(ts, source, rule, frame, count, detections) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insAlert.Close()
+
+	for _, rec := range batch {
+		if r := rec.results; r != nil {
+			ts, run := r.At.UnixMilli(), r.RunStarted.UnixMilli()
+
+			for _, d := range r.Detections {
+				var box [4]any
+				for i := range box {
+					if i < len(d.Box) {
+						box[i] = d.Box[i]
+					}
+				}
+
+				var track any
+				if d.TrackID != 0 {
+					track = d.TrackID
+				}
+
+				if _, err := insDet.ExecContext(ctx, ts, r.Source, r.ID, d.Class, d.Score,
+					box[0], box[1], box[2], box[3], run, track); err != nil {
+					return err
+				}
+
+				if d.TrackID != 0 {
+					if _, err := upTrack.ExecContext(ctx, run, d.TrackID, r.Source, d.Class, ts, ts, d.Score); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if a := rec.alert; a != nil {
+			dets, err := json.Marshal(a.Detections)
+			if err != nil {
+				return err
+			}
+			if _, err := insAlert.ExecContext(ctx, a.At.UnixMilli(), rec.source, a.Rule, a.FrameID, a.Count, string(dets)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,258 @@
+package history
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+	processing "vision/processing/detector"
+)
+
+func openTestStore(t *testing.T, hc config.HistoryConfig) *Store {
+	t.Helper()
+
+	hc.Enabled = true
+	hc.Path = filepath.Join(t.TempDir(), "history.db")
+	cfg := config.NewDefaultConfig()
+	cfg.SetHistory(hc)
+
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func frameResult(at time.Time, source string, id uint64, dets ...models.DetectionResult) record {
+	return record{results: &processing.FrameResult{
+		ID:         id,
+		At:         at,
+		Source:     source,
+		RunStarted: at.Add(-time.Hour).Truncate(time.Hour),
+		Detections: dets,
+	}}
+}
+
+func det(class string, score float32, track uint64) models.DetectionResult {
+	return models.DetectionResult{Class: class, Score: score, Box: []float32{0.1, 0.2, 0.3, 0.4}, TrackID: track}
+}
+
+func TestStoreQueries(t *testing.T) {
+	s := openTestStore(t, config.HistoryConfig{})
+	ctx := context.Background()
+	base := time.Now().Truncate(time.Second)
+
+	err := s.write([]record{
+		frameResult(base, "cam1", 1, det("person", 0.9, 7), det("car", 0.4, 0)),
+		frameResult(base.Add(time.Second), "cam1", 2, det("person", 0.95, 7)),
+		frameResult(base.Add(2*time.Second), "cam2", 3, det("person", 0.5, 0)),
+		{alert: &processing.Alert{Rule: "crowd", At: base.Add(time.Second), FrameID: 2, Count: 1,
+			Detections: []models.DetectionResult{det("person", 0.95, 7)}}, source: "cam1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dets, err := s.Detections(ctx, Filter{Class: "person"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dets) != 3 || dets[0].Frame != 3 || dets[2].Frame != 1 {
+		t.Fatalf("person detections = %+v, want frames 3, 2, 1", dets)
+	}
+	if d := dets[2]; d.Source != "cam1" || d.TrackID != 7 || len(d.Box) != 4 || !d.At.Equal(base) {
+		t.Errorf("oldest detection = %+v", d)
+	}
+
+	for _, tc := range []struct {
+		name string
+		f    Filter
+		want int
+	}{
+		{"source", Filter{Source: "cam2"}, 1},
+		{"min score", Filter{MinScore: 0.6}, 2},
+		{"from", Filter{From: base.Add(time.Second)}, 2},
+		{"to", Filter{To: base.Add(time.Second)}, 2},
+		{"limit", Filter{Limit: 1}, 1},
+	} {
+		dets, err := s.Detections(ctx, tc.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dets) != tc.want {
+			t.Errorf("%s: got %d detections, want %d", tc.name, len(dets), tc.want)
+		}
+	}
+
+	tracks, err := s.Tracks(ctx, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 1 {
+		t.Fatalf("tracks = %+v, want one", tracks)
+	}
+	if tr := tracks[0]; tr.ID != 7 || tr.Frames != 2 || tr.MaxScore != 0.95 ||
+		!tr.FirstSeen.Equal(base) || !tr.LastSeen.Equal(base.Add(time.Second)) {
+		t.Errorf("track = %+v", tr)
+	}
+
+	alerts, err := s.Alerts(ctx, Filter{Class: "crowd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 || alerts[0].Source != "cam1" || alerts[0].Frame != 2 || len(alerts[0].Detections) != 1 {
+		t.Errorf("alerts = %+v", alerts)
+	}
+
+	last, ok, err := s.LastSeen(ctx, Filter{Class: "car"})
+	if err != nil || !ok || !last.Equal(base) {
+		t.Errorf("car last seen = %v, %v, %v", last, ok, err)
+	}
+	if _, ok, err := s.LastSeen(ctx, Filter{Class: "dog"}); err != nil || ok {
+		t.Errorf("dog last seen = %v, %v", ok, err)
+	}
+
+	classes, err := s.Classes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classes) != 2 || classes[0] != "car" || classes[1] != "person" {
+		t.Errorf("classes = %v", classes)
+	}
+}
+
+func TestStoreQueuedWrites(t *testing.T) {
+	s := openTestStore(t, config.HistoryConfig{})
+
+	frame := processing.FrameResult{At: time.Now(), Source: "cam", Detections: []models.DetectionResult{det("dog", 0.8, 0)}}
+	s.HandleResults(frame)
+	s.HandleAlert(processing.Alert{Rule: "dog", At: time.Now()})
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s not written", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("detection", func() bool {
+		dets, _ := s.Detections(context.Background(), Filter{})
+		return len(dets) == 1
+	})
+	waitFor("alert", func() bool {
+		alerts, _ := s.Alerts(context.Background(), Filter{})
+		return len(alerts) == 1 && alerts[0].Source == "cam"
+	})
+}
+
+func TestStorePruneByAge(t *testing.T) {
+	s := openTestStore(t, config.HistoryConfig{MaxAgeHours: 24})
+	ctx := context.Background()
+	now := time.Now()
+
+	err := s.write([]record{
+		frameResult(now.Add(-48*time.Hour), "cam", 1, det("person", 0.9, 1)),
+		frameResult(now, "cam", 2, det("person", 0.9, 2)),
+		{alert: &processing.Alert{Rule: "old", At: now.Add(-48 * time.Hour)}, source: "cam"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.prune()
+
+	dets, err := s.Detections(ctx, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dets) != 1 || dets[0].Frame != 2 {
+		t.Errorf("detections after prune = %+v", dets)
+	}
+	if tracks, _ := s.Tracks(ctx, Filter{}); len(tracks) != 1 || tracks[0].ID != 2 {
+		t.Errorf("tracks after prune = %+v", tracks)
+	}
+	if alerts, _ := s.Alerts(ctx, Filter{}); len(alerts) != 0 {
+		t.Errorf("alerts after prune = %+v", alerts)
+	}
+}
+
+func TestStoreChunkCutoff(t *testing.T) {
+	s := openTestStore(t, config.HistoryConfig{})
+	ctx := context.Background()
+
+	if _, ok, err := s.chunkCutoff(ctx); err != nil || ok {
+		t.Fatalf("empty store: ok = %v, err = %v", ok, err)
+	}
+
+	base := time.UnixMilli(1_000_000)
+	var batch []record
+	for i := range 100 {
+		batch = append(batch, frameResult(base.Add(time.Duration(i)*time.Millisecond), "cam", uint64(i), det("car", 0.5, 0)))
+	}
+	if err := s.write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest tenth goes per round.
+	cutoff, ok, err := s.chunkCutoff(ctx)
+	if err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	if want := base.Add(10 * time.Millisecond).UnixMilli(); cutoff != want {
+		t.Errorf("cutoff = %d, want %d", cutoff, want)
+	}
+
+	// Detections sharing one timestamp still make progress.
+	s2 := openTestStore(t, config.HistoryConfig{})
+	if err := s2.write([]record{frameResult(base, "cam", 1, det("car", 0.5, 0), det("car", 0.5, 0))}); err != nil {
+		t.Fatal(err)
+	}
+	cutoff, ok, err = s2.chunkCutoff(ctx)
+	if err != nil || !ok || cutoff <= base.UnixMilli() {
+		t.Errorf("cutoff = %d, %v, %v; want past %d", cutoff, ok, err, base.UnixMilli())
+	}
+}
+
+func TestStorePruneBySize(t *testing.T) {
+	s := openTestStore(t, config.HistoryConfig{MaxSizeMB: 1})
+	ctx := context.Background()
+
+	base := time.Now().Add(-time.Hour)
+	var batch []record
+	for i := range 4000 {
+		batch = append(batch, frameResult(base.Add(time.Duration(i)*time.Millisecond), "camera-with-a-long-name", uint64(i),
+			det("person", 0.5, 0), det("bicycle", 0.5, 0)))
+	}
+	if err := s.write(batch); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := s.dataSize(ctx); size <= 1<<20 {
+		t.Fatalf("test data is only %d bytes", size)
+	}
+
+	s.prune()
+
+	size, err := s.dataSize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size > 1<<20 {
+		t.Errorf("data size after prune = %d, want at most %d", size, 1<<20)
+	}
+
+	// The newest records are kept.
+	dets, err := s.Detections(ctx, Filter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dets) != 1 || dets[0].Frame != 3999 {
+		t.Errorf("newest detection after prune = %+v", dets)
+	}
+}
@@ -0,0 +1,79 @@
+// Package sink forwards detection results and alerts from the processor to
+// external systems.
+package sink
+
+import (
+	"time"
+
+	"vision/internal/models"
+	processing "vision/processing/detector"
+)
+
+const (
+	EventDetections = "detections"
+	EventAlert      = "alert"
+)
+
+// Event is the JSON document sinks publish for a frame's results or for an
+// alert. Rule and Count are only set for alerts, Zones and Lines only for
+// results.
+type Event struct {
+	Event      string                   `json:"event"`
+	Time       time.Time                `json:"time"`
+	FrameID    uint64                   `json:"frame_id"`
+	Source     string                   `json:"source,omitempty"`
+	Rule       string                   `json:"rule,omitempty"`
+	Count      int                      `json:"count,omitempty"`
+	Detections []models.DetectionResult `json:"detections"`
+	Zones      map[string]int           `json:"zones,omitempty"`
+	Lines      map[string]LineEvent     `json:"lines,omitempty"`
+}
+
+type LineEvent struct {
+	In  uint64 `json:"in"`
+	Out uint64 `json:"out"`
+}
+
+func NewResultsEvent(r processing.FrameResult) Event {
+	e := Event{
+		Event:      EventDetections,
+		Time:       r.At,
+		FrameID:    r.ID,
+		Source:     r.Source,
+		Detections: r.Detections,
+	}
+
+	if len(r.Counts.Zones) > 0 {
+		e.Zones = make(map[string]int, len(r.Counts.Zones))
+		for _, z := range r.Counts.Zones {
+			e.Zones[z.Name] = z.Occupancy
+		}
+	}
+	if len(r.Counts.Lines) > 0 {
+		e.Lines = make(map[string]LineEvent, len(r.Counts.Lines))
+		for _, l := range r.Counts.Lines {
+			e.Lines[l.Name] = LineEvent{In: l.In, Out: l.Out}
+		}
+	}
+
+	if e.Detections == nil {
+		e.Detections = []models.DetectionResult{}
+	}
+	return e
+}
+
+func NewAlertEvent(a processing.Alert) Event {
+	e := Event{
+		Event:      EventAlert,
+		Time:       a.At,
+		FrameID:    a.FrameID,
+		Rule:       a.Rule,
+		Count:      a.Count,
+		Detections: a.Detections,
+	}
+
+	if e.Detections == nil {
+		e.Detections = []models.DetectionResult{}
+	}
+	return e
+}
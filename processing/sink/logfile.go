@@ -0,0 +1,316 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+	processing "vision/processing/detector"
+)
+
+const (
+	logQueueSize     = 1024
+	logFlushInterval = time.Second
+	logFilePrefix    = "detections-"
+	logTimeFormat    = "20060102-150405.000"
+)
+
+var csvHeader = []string{"time", "frame", "source", "class", "score", "y1", "x1", "y2", "x2", "track_id"}
+
+// DetectionLog writes one record per frame to rotating JSONL or CSV files.
+// A JSONL line holds a frame with all its detections. CSV has a row per
+// detection instead, and a frame without detections gets a single row with
+// the detection columns left empty, so every frame is on record.
+//
+// The settings are read from the config for every frame, so logging can be
+// switched on and off or moved to another directory while running.
+type DetectionLog struct {
+	cfg   *config.Config
+	queue chan processing.FrameResult
+	stop  chan struct{}
+	done  chan struct{}
+
+	dropped atomic.Uint64
+
+	// Owned by the writer goroutine.
+	cur  config.DetectionLogConfig
+	file *os.File
+	buf  *bufio.Writer
+	out  *countingWriter
+	csv  *csv.Writer
+}
+
+type logRecord struct {
+	Time       time.Time                `json:"time"`
+	Frame      uint64                   `json:"frame"`
+	Source     string                   `json:"source"`
+	Detections []models.DetectionResult `json:"detections"`
+}
+
+var _ processing.Sink = (*DetectionLog)(nil)
+
+func NewDetectionLog(cfg *config.Config) *DetectionLog {
+	l := &DetectionLog{
+		cfg:   cfg,
+		queue: make(chan processing.FrameResult, logQueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *DetectionLog) HandleResults(r processing.FrameResult) {
+	if !l.cfg.GetDetectionLog().Enabled {
+		return
+	}
+
+	// The frame is not logged, so don't keep it alive in the queue.
+	r.Frame = nil
+
+	select {
+	case l.queue <- r:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+func (l *DetectionLog) HandleAlert(processing.Alert) {}
+
+// Dropped returns how many frames were not logged because the writer fell
+// behind.
+func (l *DetectionLog) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Close writes out the queued records and closes the current file.
+func (l *DetectionLog) Close() {
+	close(l.stop)
+	<-l.done
+}
+
+func (l *DetectionLog) run() {
+	defer close(l.done)
+	defer l.closeFile()
+
+	flush := time.NewTicker(logFlushInterval)
+	defer flush.Stop()
+
+	for {
+		select {
+		case r := <-l.queue:
+			l.write(r)
+
+		case <-flush.C:
+			if !l.cfg.GetDetectionLog().Enabled {
+				l.closeFile()
+			} else if l.buf != nil {
+				l.flush()
+			}
+
+		case <-l.stop:
+			for {
+				select {
+				case r := <-l.queue:
+					l.write(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *DetectionLog) write(r processing.FrameResult) {
+	lc := l.cfg.GetDetectionLog()
+	if !lc.Enabled {
+		l.closeFile()
+		return
+	}
+	if lc.Format != config.LogCSV {
+		lc.Format = config.LogJSONL
+	}
+
+	rotate := l.file == nil || lc.Dir != l.cur.Dir || lc.Format != l.cur.Format ||
+		(lc.MaxSizeMB > 0 && l.out.n >= int64(lc.MaxSizeMB)<<20)
+	if rotate {
+		if err := l.open(lc); err != nil {
+			log.Print("Detection log error:", err)
+			return
+		}
+	}
+
+	var err error
+	if lc.Format == config.LogCSV {
+		err = l.writeCSV(r)
+	} else {
+		err = l.writeJSONL(r)
+	}
+	if err != nil {
+		log.Print("Detection log error:", err)
+		l.closeFile()
+	}
+}
+
+func (l *DetectionLog) writeJSONL(r processing.FrameResult) error {
+	rec := logRecord{Time: r.At, Frame: r.ID, Source: r.Source, Detections: r.Detections}
+	if rec.Detections == nil {
+		rec.Detections = []models.DetectionResult{}
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	_, err = l.out.Write(data)
+	return err
+}
+
+func (l *DetectionLog) writeCSV(r processing.FrameResult) error {
+	prefix := []string{r.At.Format(time.RFC3339Nano), strconv.FormatUint(r.ID, 10), r.Source}
+
+	if len(r.Detections) == 0 {
+		return l.writeRow(append(prefix, "", "", "", "", "", "", ""))
+	}
+
+	for _, d := range r.Detections {
+		row := append(prefix[:3:3], d.Class, formatFloat(d.Score))
+		for i := range 4 {
+			if i < len(d.Box) {
+				row = append(row, formatFloat(d.Box[i]))
+			} else {
+				row = append(row, "")
+			}
+		}
+		if d.TrackID != 0 {
+			row = append(row, strconv.FormatUint(d.TrackID, 10))
+		} else {
+			row = append(row, "")
+		}
+
+		if err := l.writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *DetectionLog) writeRow(row []string) error {
+	if err := l.csv.Write(row); err != nil {
+		return err
+	}
+	l.csv.Flush()
+	return l.csv.Error()
+}
+
+// open starts a new file and prunes the oldest ones. A file started in the
+// same millisecond as another gets a _2, _3 and so on suffix, which sorts
+// after the name without one.
+func (l *DetectionLog) open(lc config.DetectionLogConfig) error {
+	l.closeFile()
+
+	if err := os.MkdirAll(lc.Dir, 0755); err != nil {
+		return err
+	}
+
+	base := filepath.Join(lc.Dir, logFilePrefix+time.Now().Format(logTimeFormat))
+	ext := "." + string(lc.Format)
+	f, err := os.OpenFile(base+ext, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	for i := 2; os.IsExist(err); i++ {
+		f, err = os.OpenFile(fmt.Sprintf("%s_%d%s", base, i, ext), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	l.cur = lc
+	l.file = f
+	l.buf = bufio.NewWriterSize(f, 64<<10)
+	l.out = &countingWriter{w: l.buf}
+
+	if lc.Format == config.LogCSV {
+		l.csv = csv.NewWriter(l.out)
+		if err := l.writeRow(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	l.prune(lc)
+	return nil
+}
+
+func (l *DetectionLog) flush() {
+	if err := l.buf.Flush(); err != nil {
+		log.Print("Detection log error:", err)
+	}
+}
+
+func (l *DetectionLog) closeFile() {
+	if l.file == nil {
+		return
+	}
+
+	l.flush()
+	l.file.Close()
+	l.file, l.buf, l.out, l.csv = nil, nil, nil, nil
+}
+
+// prune removes the oldest log files beyond MaxFiles. File names sort by
+// the time they were started.
+func (l *DetectionLog) prune(lc config.DetectionLogConfig) {
+	if lc.MaxFiles <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(lc.Dir)
+	if err != nil {
+		return
+	}
+
+	var names []string
+	for _, e := range entries {
+		n := e.Name()
+		if strings.HasPrefix(n, logFilePrefix) && (strings.HasSuffix(n, ".jsonl") || strings.HasSuffix(n, ".csv")) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	for len(names) > lc.MaxFiles {
+		if err := os.Remove(filepath.Join(lc.Dir, names[0])); err != nil {
+			log.Print("Detection log error:", err)
+		}
+		names = names[1:]
+	}
+}
+
+// countingWriter counts the bytes written through it, which is the size of
+// the current file once the buffer below it is flushed.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func formatFloat(v float32) string {
+	return strconv.FormatFloat(float64(v), 'f', -1, 32)
+}
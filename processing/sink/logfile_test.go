@@ -0,0 +1,192 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+	processing "vision/processing/detector"
+)
+
+func newTestLog(t *testing.T, lc config.DetectionLogConfig) (*DetectionLog, string) {
+	t.Helper()
+	lc.Enabled = true
+	lc.Dir = t.TempDir()
+
+	cfg := config.NewDefaultConfig()
+	cfg.SetDetectionLog(lc)
+	return NewDetectionLog(cfg), lc.Dir
+}
+
+func logFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, filepath.Join(dir, e.Name()))
+	}
+	return paths
+}
+
+func logFrames() []processing.FrameResult {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []processing.FrameResult{
+		{ID: 1, At: at, Source: "cam", Detections: []models.DetectionResult{
+			{Class: "car", Score: 0.5, Box: []float32{0.1, 0.2, 0.3, 0.4}, TrackID: 7},
+			{Class: "dog", Score: 0.25},
+		}},
+		{ID: 2, At: at.Add(time.Second), Source: "cam"},
+	}
+}
+
+func TestDetectionLogJSONL(t *testing.T) {
+	l, dir := newTestLog(t, config.DetectionLogConfig{Format: config.LogJSONL})
+	for _, r := range logFrames() {
+		l.HandleResults(r)
+	}
+	l.Close()
+
+	files := logFiles(t, dir)
+	if len(files) != 1 || !strings.HasSuffix(files[0], ".jsonl") {
+		t.Fatalf("files = %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var recs []logRecord
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec logRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+
+	if len(recs) != 2 {
+		t.Fatalf("%d records, want 2", len(recs))
+	}
+	if r := recs[0]; r.Frame != 1 || r.Source != "cam" || len(r.Detections) != 2 || r.Detections[0].TrackID != 7 {
+		t.Errorf("first record = %+v", r)
+	}
+	if r := recs[1]; r.Frame != 2 || r.Detections == nil || len(r.Detections) != 0 {
+		t.Errorf("frame without detections = %+v", r)
+	}
+}
+
+func TestDetectionLogCSV(t *testing.T) {
+	l, dir := newTestLog(t, config.DetectionLogConfig{Format: config.LogCSV})
+	for _, r := range logFrames() {
+		l.HandleResults(r)
+	}
+	l.Close()
+
+	files := logFiles(t, dir)
+	if len(files) != 1 || !strings.HasSuffix(files[0], ".csv") {
+		t.Fatalf("files = %v", files)
+	}
+	rows := readCSV(t, files[0])
+
+	want := [][]string{
+		csvHeader,
+		{"2024-05-01T12:00:00Z", "1", "cam", "car", "0.5", "0.1", "0.2", "0.3", "0.4", "7"},
+		{"2024-05-01T12:00:00Z", "1", "cam", "dog", "0.25", "", "", "", "", ""},
+		{"2024-05-01T12:00:01Z", "2", "cam", "", "", "", "", "", "", ""},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("%d rows, want %d: %v", len(rows), len(want), rows)
+	}
+	for i := range want {
+		if !slices.Equal(rows[i], want[i]) {
+			t.Errorf("row %d = %v, want %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return rows
+}
+
+func TestDetectionLogRotation(t *testing.T) {
+	l, dir := newTestLog(t, config.DetectionLogConfig{Format: config.LogCSV, MaxSizeMB: 1, MaxFiles: 3})
+
+	// Every frame fills a file on its own, so each one after the first
+	// starts a new file.
+	big := strings.Repeat("x", 1<<20)
+	for id := range uint64(5) {
+		l.HandleResults(processing.FrameResult{ID: id, At: time.Now(), Source: big})
+	}
+	l.Close()
+
+	files := logFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("%d files kept, want 3: %v", len(files), files)
+	}
+
+	// The newest files are kept, each with a header and its own frame.
+	for i, path := range files {
+		rows := readCSV(t, path)
+		if len(rows) != 2 || !slices.Equal(rows[0], csvHeader) {
+			t.Errorf("%s holds %d rows", filepath.Base(path), len(rows))
+			continue
+		}
+		if want := []string{"2", "3", "4"}[i]; rows[1][1] != want {
+			t.Errorf("%s holds frame %s, want %s", filepath.Base(path), rows[1][1], want)
+		}
+	}
+}
+
+func TestDetectionLogNameCollision(t *testing.T) {
+	lc := config.DetectionLogConfig{Enabled: true, Dir: t.TempDir(), Format: config.LogCSV}
+
+	// Log files taken for every millisecond to come, as if rotated just now.
+	taken := map[string]bool{}
+	now := time.Now()
+	for ms := range 100 {
+		name := filepath.Join(lc.Dir, logFilePrefix+now.Add(time.Duration(ms)*time.Millisecond).Format(logTimeFormat)+".csv")
+		if err := os.WriteFile(name, []byte("earlier\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		taken[name] = true
+	}
+
+	l := &DetectionLog{}
+	if err := l.open(lc); err != nil {
+		t.Fatal(err)
+	}
+	name := l.file.Name()
+	l.closeFile()
+
+	if taken[name] {
+		t.Fatalf("reopened %s", filepath.Base(name))
+	}
+	if rows := readCSV(t, name); len(rows) != 1 || !slices.Equal(rows[0], csvHeader) {
+		t.Errorf("new file holds %v", rows)
+	}
+}
@@ -0,0 +1,394 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	mqttQueueSize      = 256
+	mqttRetryInterval  = 5 * time.Second
+	mqttPublishTimeout = 5 * time.Second
+	mqttDisconnectWait = 250
+)
+
+// MQTT publishes events to a broker. Topics, relative to the prefix:
+//
+//	status                    retained "online" or "offline"
+//	detections                every frame's results, as an Event
+//	alerts                    every alert, as an Event
+//	alert/<rule>              every alert of one rule, for Home Assistant
+//	zone/<zone>/occupancy     retained, published when it changes
+//	line/<line>/in, .../out   retained, published when they change
+//
+// Messages are published from a background goroutine, which holds them
+// while the broker is unreachable; they are dropped when the queue is full.
+// The client reconnects on its own; the status topic falls back to "offline"
+// through the will message when the connection is lost. Names that slug to
+// the same topic level get a numeric suffix, in config order.
+type MQTT struct {
+	cfg  *config.Config
+	mc   config.MQTTConfig
+	node string
+
+	client    mqtt.Client
+	queue     chan mqttMessage
+	connected chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+
+	// Last queued payload of each count topic, only touched by
+	// HandleResults.
+	counts map[string]string
+
+	dropped atomic.Uint64
+}
+
+type mqttMessage struct {
+	topic    string
+	payload  []byte
+	retained bool
+}
+
+var _ processing.Sink = (*MQTT)(nil)
+
+// NewMQTT starts connecting to the broker in cfg. It does not wait for the
+// connection: events published before it is up are sent once it is.
+func NewMQTT(cfg *config.Config) (*MQTT, error) {
+	mc := cfg.GetMQTT()
+	if mc.Broker == "" {
+		return nil, errors.New("mqtt: no broker configured")
+	}
+	if mc.ClientID == "" {
+		mc.ClientID = "vision"
+	}
+	if mc.TopicPrefix == "" {
+		mc.TopicPrefix = "vision"
+	}
+	if mc.DiscoveryPrefix == "" {
+		mc.DiscoveryPrefix = "homeassistant"
+	}
+	if mc.QoS > 2 {
+		return nil, fmt.Errorf("mqtt: invalid QoS %d", mc.QoS)
+	}
+
+	m := &MQTT{
+		cfg:       cfg,
+		mc:        mc,
+		node:      slug(mc.ClientID),
+		queue:     make(chan mqttMessage, mqttQueueSize),
+		connected: make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		counts:    make(map[string]string),
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(mc.Broker).
+		SetClientID(mc.ClientID).
+		SetUsername(mc.Username).
+		SetPassword(mc.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(mqttRetryInterval).
+		SetWill(m.topic("status"), "offline", mc.QoS, true).
+		SetOnConnectHandler(m.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Print("MQTT connection lost:", err)
+		})
+
+	m.client = mqtt.NewClient(opts)
+	m.client.Connect()
+
+	go m.run()
+	return m, nil
+}
+
+func (m *MQTT) HandleResults(r processing.FrameResult) {
+	if m.mc.Detections {
+		m.publishJSON(m.topic("detections"), NewResultsEvent(r), false)
+	}
+	if !m.mc.Counts {
+		return
+	}
+
+	zoneNames := make([]string, len(r.Counts.Zones))
+	for i, z := range r.Counts.Zones {
+		zoneNames[i] = z.Name
+	}
+	zoneSlugs := uniqueSlugs(zoneNames)
+	for _, z := range r.Counts.Zones {
+		m.publishCount(m.topic("zone", zoneSlugs[z.Name], "occupancy"), uint64(z.Occupancy))
+	}
+
+	lineNames := make([]string, len(r.Counts.Lines))
+	for i, l := range r.Counts.Lines {
+		lineNames[i] = l.Name
+	}
+	lineSlugs := uniqueSlugs(lineNames)
+	for _, l := range r.Counts.Lines {
+		m.publishCount(m.topic("line", lineSlugs[l.Name], "in"), l.In)
+		m.publishCount(m.topic("line", lineSlugs[l.Name], "out"), l.Out)
+	}
+}
+
+// publishCount publishes a retained count when it differs from the last one
+// queued for topic.
+func (m *MQTT) publishCount(topic string, n uint64) {
+	payload := fmt.Sprint(n)
+	if last, ok := m.counts[topic]; ok && last == payload {
+		return
+	}
+	if m.publish(topic, []byte(payload), true) {
+		m.counts[topic] = payload
+	}
+}
+
+func (m *MQTT) HandleAlert(a processing.Alert) {
+	if !m.mc.Alerts {
+		return
+	}
+
+	e := NewAlertEvent(a)
+	m.publishJSON(m.topic("alerts"), e, false)
+
+	if m.mc.Discovery {
+		m.publishJSON(m.topic("alert", m.ruleSlugs()[a.Rule]), struct {
+			EventType string `json:"event_type"`
+			Event
+		}{"alert", e}, false)
+	}
+}
+
+// Dropped returns how many messages were lost to a full queue.
+func (m *MQTT) Dropped() uint64 {
+	return m.dropped.Load()
+}
+
+// Close publishes the offline status and disconnects.
+func (m *MQTT) Close() {
+	close(m.stop)
+	<-m.done
+
+	if m.client.IsConnectionOpen() {
+		t := m.client.Publish(m.topic("status"), m.mc.QoS, true, "offline")
+		t.WaitTimeout(mqttPublishTimeout)
+	}
+	m.client.Disconnect(mqttDisconnectWait)
+}
+
+func (m *MQTT) run() {
+	defer close(m.done)
+
+	for {
+		select {
+		case msg := <-m.queue:
+			// Hold the queue until the broker is back rather than let
+			// every message time out.
+			for !m.client.IsConnectionOpen() {
+				select {
+				case <-m.connected:
+				case <-time.After(mqttRetryInterval):
+				case <-m.stop:
+					return
+				}
+			}
+
+			t := m.client.Publish(msg.topic, m.mc.QoS, msg.retained, msg.payload)
+			if !t.WaitTimeout(mqttPublishTimeout) {
+				log.Printf("MQTT publish to %s timed out", msg.topic)
+			} else if err := t.Error(); err != nil {
+				log.Printf("MQTT publish to %s failed: %v", msg.topic, err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// onConnect runs on every (re)connect. It announces the client as online and
+// refreshes the discovery entities, which picks up zones and rules changed
+// since the last connect.
+func (m *MQTT) onConnect(c mqtt.Client) {
+	c.Publish(m.topic("status"), m.mc.QoS, true, "online")
+
+	select {
+	case m.connected <- struct{}{}:
+	default:
+	}
+
+	if !m.mc.Discovery {
+		return
+	}
+
+	for topic, payload := range m.discoveryPayloads() {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			continue
+		}
+		c.Publish(topic, m.mc.QoS, true, data)
+	}
+}
+
+type haDevice struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+	Model       string   `json:"model,omitempty"`
+}
+
+// haEntity is a Home Assistant MQTT discovery payload.
+type haEntity struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	Unit              string   `json:"unit_of_measurement,omitempty"`
+	StateClass        string   `json:"state_class,omitempty"`
+	EventTypes        []string `json:"event_types,omitempty"`
+	Icon              string   `json:"icon,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+func (m *MQTT) discoveryPayloads() map[string]haEntity {
+	device := haDevice{
+		Identifiers: []string{m.node},
+		Name:        m.mc.ClientID,
+		Model:       "Vision detector",
+	}
+
+	entity := func(name, id, state string) haEntity {
+		return haEntity{
+			Name:              name,
+			UniqueID:          m.node + "_" + id,
+			StateTopic:        state,
+			AvailabilityTopic: m.topic("status"),
+			Device:            device,
+		}
+	}
+
+	out := make(map[string]haEntity)
+	discovery := func(component, id string) string {
+		return strings.Join([]string{m.mc.DiscoveryPrefix, component, m.node, id, "config"}, "/")
+	}
+
+	if m.mc.Counts {
+		zones := m.cfg.GetZones()
+
+		zoneNames := make([]string, len(zones.Zones))
+		for i, z := range zones.Zones {
+			zoneNames[i] = z.Name
+		}
+		zoneSlugs := uniqueSlugs(zoneNames)
+		for _, z := range zones.Zones {
+			id := "zone_" + zoneSlugs[z.Name]
+			e := entity(z.Name+" occupancy", id, m.topic("zone", zoneSlugs[z.Name], "occupancy"))
+			e.Unit, e.StateClass, e.Icon = "objects", "measurement", "mdi:account-group"
+			out[discovery("sensor", id)] = e
+		}
+
+		lineNames := make([]string, len(zones.Lines))
+		for i, l := range zones.Lines {
+			lineNames[i] = l.Name
+		}
+		lineSlugs := uniqueSlugs(lineNames)
+		for _, l := range zones.Lines {
+			for _, dir := range []string{"in", "out"} {
+				id := "line_" + lineSlugs[l.Name] + "_" + dir
+				e := entity(l.Name+" "+dir, id, m.topic("line", lineSlugs[l.Name], dir))
+				e.Unit, e.StateClass, e.Icon = "objects", "total_increasing", "mdi:counter"
+				out[discovery("sensor", id)] = e
+			}
+		}
+	}
+
+	if m.mc.Alerts {
+		ruleSlugs := m.ruleSlugs()
+		for _, r := range m.cfg.GetAlerts().Rules {
+			id := "alert_" + ruleSlugs[r.Name]
+			e := entity(r.Name, id, m.topic("alert", ruleSlugs[r.Name]))
+			e.EventTypes, e.Icon = []string{"alert"}, "mdi:alert"
+			out[discovery("event", id)] = e
+		}
+	}
+
+	return out
+}
+
+func (m *MQTT) publishJSON(topic string, v any, retained bool) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Print("MQTT encode error:", err)
+		return
+	}
+	m.publish(topic, data, retained)
+}
+
+// publish queues a message and reports whether there was room for it.
+func (m *MQTT) publish(topic string, payload []byte, retained bool) bool {
+	select {
+	case m.queue <- mqttMessage{topic: topic, payload: payload, retained: retained}:
+		return true
+	default:
+		m.dropped.Add(1)
+		return false
+	}
+}
+
+func (m *MQTT) ruleSlugs() map[string]string {
+	rules := m.cfg.GetAlerts().Rules
+	names := make([]string, len(rules))
+	for i, r := range rules {
+		names[i] = r.Name
+	}
+	return uniqueSlugs(names)
+}
+
+func (m *MQTT) topic(parts ...string) string {
+	return m.mc.TopicPrefix + "/" + strings.Join(parts, "/")
+}
+
+// slug turns a name into a topic level and Home Assistant object ID.
+func slug(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// uniqueSlugs maps each name to its slug, suffixing _2, _3 and so on to the
+// slugs of later names that would collide with an earlier one.
+func uniqueSlugs(names []string) map[string]string {
+	out := make(map[string]string, len(names))
+	taken := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		if _, ok := out[name]; ok {
+			continue
+		}
+		s := slug(name)
+		for n := 2; taken[s]; n++ {
+			s = fmt.Sprintf("%s_%d", slug(name), n)
+		}
+		taken[s] = true
+		out[name] = s
+	}
+	return out
+}
@@ -0,0 +1,208 @@
+package sink
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// testBroker is an in-process MQTT broker recording every message published
+// to it.
+type testBroker struct {
+	srv       *broker.Server
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	messages []packets.Packet
+}
+
+func startBroker(t *testing.T, addr string) *testBroker {
+	t.Helper()
+
+	b := &testBroker{srv: broker.New(&broker.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})}
+	if err := b.srv.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.srv.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: addr})); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.srv.Serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := b.srv.Subscribe("#", 1, func(_ *broker.Client, _ packets.Subscription, pk packets.Packet) {
+		b.mu.Lock()
+		b.messages = append(b.messages, pk)
+		b.mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(b.close)
+	return b
+}
+
+func (b *testBroker) close() {
+	b.closeOnce.Do(func() { b.srv.Close() })
+}
+
+// payloads returns what was published to topic, oldest first.
+func (b *testBroker) payloads(topic string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var out []string
+	for _, pk := range b.messages {
+		if pk.TopicName == topic {
+			out = append(out, string(pk.Payload))
+		}
+	}
+	return out
+}
+
+func (b *testBroker) waitPayload(t *testing.T, topic, want string) {
+	t.Helper()
+	waitFor(t, topic+" = "+want, func() bool {
+		got := b.payloads(topic)
+		return len(got) > 0 && got[len(got)-1] == want
+	})
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func newTestMQTT(t *testing.T, addr string) *MQTT {
+	t.Helper()
+
+	cfg := config.NewDefaultConfig()
+	mc := cfg.GetMQTT()
+	mc.Broker = "tcp://" + addr
+	mc.ClientID = "vision-test"
+	cfg.SetMQTT(mc)
+
+	cfg.SetZones(config.ZonesConfig{
+		Zones: []config.ZoneConfig{{Name: "Zone A"}, {Name: "zone-a"}},
+		Lines: []config.LineConfig{{Name: "Door"}},
+	})
+	cfg.SetAlerts(config.AlertsConfig{Rules: []config.RuleConfig{{Name: "crowd"}}})
+
+	m, err := NewMQTT(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	return m
+}
+
+func testCounts(a, b int, in uint64) processing.FrameResult {
+	return processing.FrameResult{Counts: processing.ZoneCounts{
+		Zones: []processing.ZoneCount{{Name: "Zone A", Occupancy: a}, {Name: "zone-a", Occupancy: b}},
+		Lines: []processing.LineCount{{Name: "Door", In: in}},
+	}}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	addr := freeAddr(t)
+	b := startBroker(t, addr)
+	m := newTestMQTT(t, addr)
+
+	b.waitPayload(t, "vision/status", "online")
+
+	// Colliding names get their own topics and entities.
+	for _, entity := range []string{
+		"sensor/vision_test/zone_zone_a",
+		"sensor/vision_test/zone_zone_a_2",
+		"sensor/vision_test/line_door_in",
+		"sensor/vision_test/line_door_out",
+		"event/vision_test/alert_crowd",
+	} {
+		topic := "homeassistant/" + entity + "/config"
+		waitFor(t, topic, func() bool { return len(b.payloads(topic)) == 1 })
+	}
+
+	m.HandleResults(testCounts(1, 2, 3))
+	m.HandleResults(testCounts(1, 2, 3))
+	m.HandleResults(testCounts(1, 4, 3))
+
+	b.waitPayload(t, "vision/zone/zone_a_2/occupancy", "4")
+	b.waitPayload(t, "vision/line/door/in", "3")
+
+	// Unchanged counts are not published again.
+	if got := b.payloads("vision/zone/zone_a/occupancy"); len(got) != 1 || got[0] != "1" {
+		t.Errorf("zone_a occupancy published %v", got)
+	}
+	if got := b.payloads("vision/zone/zone_a_2/occupancy"); strings.Join(got, ",") != "2,4" {
+		t.Errorf("zone_a_2 occupancy published %v", got)
+	}
+
+	m.HandleAlert(processing.Alert{Rule: "crowd", At: time.Now(), Count: 3})
+	waitFor(t, "the alert", func() bool {
+		return len(b.payloads("vision/alerts")) == 1 && len(b.payloads("vision/alert/crowd")) == 1
+	})
+
+	if n := m.Dropped(); n != 0 {
+		t.Errorf("dropped %d messages", n)
+	}
+}
+
+func TestMQTTHoldsMessagesWhileDisconnected(t *testing.T) {
+	addr := freeAddr(t)
+	b := startBroker(t, addr)
+	m := newTestMQTT(t, addr)
+	b.waitPayload(t, "vision/status", "online")
+
+	b.close()
+	waitFor(t, "the connection to drop", func() bool { return !m.client.IsConnectionOpen() })
+
+	// Queued while the broker is down, and sent in order once it is back.
+	m.HandleResults(testCounts(1, 0, 0))
+	m.HandleResults(testCounts(2, 0, 0))
+	m.HandleResults(testCounts(3, 0, 0))
+
+	b = startBroker(t, addr)
+	b.waitPayload(t, "vision/zone/zone_a/occupancy", "3")
+
+	if got := b.payloads("vision/zone/zone_a/occupancy"); strings.Join(got, ",") != "1,2,3" {
+		t.Errorf("occupancy published %v", got)
+	}
+	if n := m.Dropped(); n != 0 {
+		t.Errorf("dropped %d messages", n)
+	}
+}
+
+func TestUniqueSlugs(t *testing.T) {
+	got := uniqueSlugs([]string{"Zone A", "zone-a", "zone_a", "Zone A", "Lobby"})
+	want := map[string]string{"Zone A": "zone_a", "zone-a": "zone_a_2", "zone_a": "zone_a_3", "Lobby": "lobby"}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for name, s := range want {
+		if got[name] != s {
+			t.Errorf("slug of %q = %q, want %q", name, got[name], s)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// delivery is one request to make: body to be POSTed to URL.
+type delivery struct {
+	URL   string          `json:"url"`
+	Event string          `json:"event"`
+	Body  json.RawMessage `json:"body"`
+}
+
+// diskQueue keeps deliveries that could not be made yet in a directory, one
+// JSON file each, named so that they sort in the order they were queued.
+// Files are written under a temporary name and renamed, so a crash never
+// leaves a partial entry behind. Past max entries, the oldest are removed.
+type diskQueue struct {
+	dir string
+	max int
+
+	mu    sync.Mutex
+	seq   uint64
+	paths []string
+}
+
+// newDiskQueue opens dir, keeping the entries left in it by an earlier run.
+func newDiskQueue(dir string, max int) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &diskQueue{dir: dir, max: max}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			q.paths = append(q.paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(q.paths)
+	return q, nil
+}
+
+// Push queues d and returns how many of the oldest entries were removed to
+// stay within max.
+func (q *diskQueue) Push(d delivery) (int, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return 0, err
+	}
+
+	q.mu.Lock()
+	q.seq++
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), q.seq%1000000)
+	q.mu.Unlock()
+
+	path := filepath.Join(q.dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, _ := slices.BinarySearch(q.paths, path)
+	q.paths = slices.Insert(q.paths, i, path)
+
+	pruned := 0
+	for q.max > 0 && len(q.paths) > q.max {
+		if err := os.Remove(q.paths[0]); err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+		q.paths = q.paths[1:]
+		pruned++
+	}
+	return pruned, nil
+}
+
+// List returns the queued entries, oldest first.
+func (q *diskQueue) List() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return slices.Clone(q.paths)
+}
+
+func (q *diskQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.paths)
+}
+
+func (q *diskQueue) Load(path string) (delivery, error) {
+	var d delivery
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return d, err
+	}
+	err = json.Unmarshal(data, &d)
+	return d, err
+}
+
+// Remove drops an entry. An entry pruned meanwhile is already gone.
+func (q *diskQueue) Remove(path string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i, found := slices.BinarySearch(q.paths, path)
+	if !found {
+		return nil
+	}
+	q.paths = slices.Delete(q.paths, i, i+1)
+	return os.Remove(path)
+}
@@ -0,0 +1,354 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+	"vision/processing/annotate"
+	processing "vision/processing/detector"
+)
+
+const (
+	recordingTimeFormat = "20060102-150405.000"
+	defaultRecordingFPS = 24
+
+	// staleFrameAge is how long the last frame keeps being repeated when no
+	// new ones arrive; after that recording pauses until the stream resumes.
+	staleFrameAge = 2 * time.Second
+
+	// encoderCloseTimeout is how long FFmpeg gets to finish a file before
+	// it is killed.
+	encoderCloseTimeout = 10 * time.Second
+
+	// encoderWriteTimeout is how long FFmpeg may take to accept a frame
+	// before it is considered stuck and killed, and encoderStopGrace how
+	// long once recording is stopped.
+	encoderWriteTimeout = 5 * time.Second
+	encoderStopGrace    = time.Second
+)
+
+// Recorder encodes the frames the Processor emits, with the latest
+// detections drawn in, into video files using FFmpeg. With config.SyncExact
+// it records the frames the detector ran on, so boxes always match what is
+// under them, otherwise every captured frame. Frames are written at a fixed
+// rate, repeating the last one as needed, so the video plays back in real
+// time whatever the capture and detection rates are.
+type Recorder struct {
+	cfg  *config.Config
+	errs chan error
+
+	mu    sync.Mutex
+	frame image.Image
+	at    time.Time
+	dets  []models.DetectionResult
+	seq   uint64
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+var (
+	_ processing.Sink      = (*Recorder)(nil)
+	_ processing.FrameSink = (*Recorder)(nil)
+)
+
+func NewRecorder(cfg *config.Config) *Recorder {
+	return &Recorder{
+		cfg:  cfg,
+		errs: make(chan error, 1),
+	}
+}
+
+func (r *Recorder) HandleFrame(_ uint64, frame image.Image) {
+	if r.cfg.GetSync().Mode == config.SyncExact {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frame, r.at = frame, time.Now()
+	r.seq++
+}
+
+func (r *Recorder) HandleResults(res processing.FrameResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dets = res.Detections
+	if r.cfg.GetSync().Mode == config.SyncExact && res.Frame != nil {
+		r.frame, r.at = res.Frame, time.Now()
+	}
+	r.seq++
+}
+
+func (r *Recorder) HandleAlert(processing.Alert) {}
+
+// Start begins recording into a new file. The settings are read once, so
+// changes apply to the next recording.
+func (r *Recorder) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running() {
+		return nil
+	}
+
+	rc := r.cfg.GetRecorder()
+	if err := os.MkdirAll(rc.Dir, 0755); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("recorder: %w", err)
+	}
+
+	fps := rc.FPS
+	if fps <= 0 {
+		fps = int(r.cfg.GetFPS())
+	}
+	if fps <= 0 {
+		fps = defaultRecordingFPS
+	}
+
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.run(rc, fps, r.stop, r.done)
+	return nil
+}
+
+// Stop finishes the current file and waits for FFmpeg to write it out.
+func (r *Recorder) Stop() {
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (r *Recorder) Recording() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running()
+}
+
+func (r *Recorder) running() bool {
+	if r.done == nil {
+		return false
+	}
+	select {
+	case <-r.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Errors reports recordings that stopped because FFmpeg failed.
+func (r *Recorder) Errors() <-chan error {
+	return r.errs
+}
+
+func (r *Recorder) Close() {
+	r.Stop()
+}
+
+func (r *Recorder) run(rc config.RecorderConfig, fps int, stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(time.Second / time.Duration(fps))
+	defer ticker.Stop()
+
+	segment := time.Duration(rc.SegmentMinutes) * time.Minute
+
+	var enc *encoder
+	var annotated *image.RGBA
+	var seq uint64
+
+	fail := func(err error) {
+		log.Print("Recorder error:", err)
+		select {
+		case r.errs <- err:
+		default:
+		}
+	}
+
+	finish := func() error {
+		if enc == nil {
+			return nil
+		}
+		err := enc.close()
+		enc = nil
+		return err
+	}
+
+	for {
+		select {
+		case <-stop:
+			if err := finish(); err != nil {
+				fail(err)
+			}
+			return
+
+		case now := <-ticker.C:
+			r.mu.Lock()
+			frame, at, dets, cur := r.frame, r.at, r.dets, r.seq
+			r.mu.Unlock()
+
+			if frame == nil || now.Sub(at) > staleFrameAge {
+				continue
+			}
+			if annotated == nil || cur != seq {
+				annotated = annotate.Frame(frame, dets)
+				seq = cur
+			}
+
+			size := annotated.Bounds().Size()
+			if enc != nil && (enc.size != size || (segment > 0 && now.Sub(enc.started) >= segment)) {
+				if err := finish(); err != nil {
+					fail(err)
+					return
+				}
+			}
+			if enc == nil {
+				var err error
+				if enc, err = startEncoder(rc, fps, size); err != nil {
+					fail(err)
+					return
+				}
+			}
+
+			if err := enc.write(annotated.Pix, stop); err != nil {
+				// A broken pipe means FFmpeg quit, and it says why.
+				if ferr := finish(); ferr != nil {
+					err = ferr
+				}
+				fail(err)
+				return
+			}
+		}
+	}
+}
+
+// encoder is one FFmpeg process writing one file.
+type encoder struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  bytes.Buffer
+	path    string
+	size    image.Point
+	started time.Time
+}
+
+func startEncoder(rc config.RecorderConfig, fps int, size image.Point) (*encoder, error) {
+	ext := "mp4"
+	if rc.Container == "mkv" {
+		ext = "mkv"
+	}
+
+	enc := &encoder{
+		path:    filepath.Join(rc.Dir, "recording-"+time.Now().Format(recordingTimeFormat)+"."+ext),
+		size:    size,
+		started: time.Now(),
+	}
+
+	codec := rc.Codec
+	if codec == "" {
+		codec = "libx264"
+	}
+
+	args := []string{
+		"-f", "rawvideo",
+		"-pix_fmt", "rgba",
+		"-s", fmt.Sprintf("%dx%d", size.X, size.Y),
+		"-framerate", fmt.Sprint(fps),
+		"-i", "-",
+		// Most encoders need even dimensions for yuv420p.
+		"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2",
+		"-c:v", codec,
+		"-pix_fmt", "yuv420p",
+	}
+	if rc.Bitrate != "" {
+		args = append(args, "-b:v", rc.Bitrate)
+	}
+	if ext == "mp4" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, "-y", enc.path)
+
+	enc.cmd = exec.Command("ffmpeg", args...)
+	enc.cmd.Stderr = &enc.stderr
+
+	stdin, err := enc.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	enc.stdin = stdin
+
+	if err := enc.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ffmpeg start error: %w. Details: %s", err, enc.stderr.String())
+	}
+	return enc, nil
+}
+
+// write hands one frame to FFmpeg. An FFmpeg that stops reading would block
+// the write forever, so it is killed when the write stalls or is still
+// pending shortly after stop fires, which fails the write.
+func (e *encoder) write(pix []byte, stop <-chan struct{}) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := e.stdin.Write(pix)
+		done <- err
+	}()
+
+	timeout := time.NewTimer(encoderWriteTimeout)
+	defer timeout.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timeout.C:
+	case <-stop:
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(encoderStopGrace):
+		}
+	}
+
+	e.cmd.Process.Kill()
+	<-done
+	return fmt.Errorf("ffmpeg stopped reading frames for %s and was killed", e.path)
+}
+
+// close ends the input and waits for FFmpeg to finish the file, killing it
+// if it hangs.
+func (e *encoder) close() error {
+	e.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- e.cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("ffmpeg error: %w. Details: %s", err, e.stderr.String())
+		}
+		return nil
+	case <-time.After(encoderCloseTimeout):
+		e.cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("ffmpeg did not finish %s within %v and was killed. Details: %s", e.path, encoderCloseTimeout, e.stderr.String())
+	}
+}
@@ -0,0 +1,115 @@
+package sink
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+)
+
+// fakeFFmpeg puts an ffmpeg running script first on PATH.
+func fakeFFmpeg(t *testing.T, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func newTestRecorder(t *testing.T) (*Recorder, string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	cfg := config.NewDefaultConfig()
+	cfg.SetRecorder(config.RecorderConfig{Dir: dir, Container: "mkv", FPS: 50})
+	r := NewRecorder(cfg)
+	t.Cleanup(r.Close)
+	return r, dir
+}
+
+// stopWithin fails the test if Stop hangs.
+func stopWithin(t *testing.T, r *Recorder, d time.Duration) {
+	t.Helper()
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(d):
+		t.Fatal("Stop hangs")
+	}
+}
+
+func TestRecorderWritesFrames(t *testing.T) {
+	// Copies the raw frames into the output file, the last argument.
+	fakeFFmpeg(t, `for out; do :; done; exec cat > "$out"`)
+	r, dir := newTestRecorder(t)
+
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	frame := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	r.HandleFrame(1, frame)
+
+	var path string
+	waitFor(t, "frames to be written", func() bool {
+		matches, _ := filepath.Glob(filepath.Join(dir, "recording-*.mkv"))
+		if len(matches) != 1 {
+			return false
+		}
+		path = matches[0]
+		info, err := os.Stat(path)
+		return err == nil && info.Size() >= 3*int64(len(frame.Pix))
+	})
+	stopWithin(t, r, 5*time.Second)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size()%int64(len(frame.Pix)) != 0 {
+		t.Errorf("recording holds %d bytes, not whole %d byte frames", info.Size(), len(frame.Pix))
+	}
+	select {
+	case err := <-r.Errors():
+		t.Errorf("recording failed: %v", err)
+	default:
+	}
+	if r.Recording() {
+		t.Error("still recording after Stop")
+	}
+}
+
+func TestRecorderStopsStuckEncoder(t *testing.T) {
+	// Never reads its input, so a frame larger than the pipe buffer blocks.
+	fakeFFmpeg(t, `exec sleep 60`)
+	r, _ := newTestRecorder(t)
+
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r.HandleFrame(1, image.NewRGBA(image.Rect(0, 0, 640, 480)))
+	time.Sleep(200 * time.Millisecond)
+
+	stopWithin(t, r, encoderStopGrace+5*time.Second)
+
+	select {
+	case err := <-r.Errors():
+		if err == nil {
+			t.Error("nil error")
+		}
+	default:
+		t.Error("no error for the killed encoder")
+	}
+}
@@ -0,0 +1,313 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+	"vision/processing/annotate"
+	processing "vision/processing/detector"
+)
+
+const (
+	snapshotQueueSize  = 8
+	snapshotTimeFormat = "20060102-150405.000"
+)
+
+// SnapshotMeta is the sidecar JSON written next to each snapshot. Image is
+// the full frame file, if one was saved, and each detection names its crop.
+type SnapshotMeta struct {
+	Time       time.Time        `json:"time"`
+	Frame      uint64           `json:"frame"`
+	Source     string           `json:"source"`
+	Trigger    string           `json:"trigger"`
+	Image      string           `json:"image,omitempty"`
+	Annotated  bool             `json:"annotated"`
+	Detections []SnapshotObject `json:"detections"`
+}
+
+type SnapshotObject struct {
+	models.DetectionResult
+	Crop string `json:"crop,omitempty"`
+}
+
+// Snapshots saves images of frames with detections of the configured
+// classes. File names hold the time, class, score and track ID of the
+// detection they are about, e.g. 20250101-120000.000_person_0.87_t12.jpg;
+// a full frame is named after its best-scoring detection. The settings are
+// read from the config for every frame.
+type Snapshots struct {
+	cfg   *config.Config
+	queue chan snapshotJob
+	stop  chan struct{}
+	done  chan struct{}
+
+	// When each class last triggered, only touched by HandleResults.
+	last map[string]time.Time
+
+	dropped atomic.Uint64
+}
+
+type snapshotJob struct {
+	cfg    config.SnapshotConfig
+	result processing.FrameResult
+}
+
+var _ processing.Sink = (*Snapshots)(nil)
+
+func NewSnapshots(cfg *config.Config) *Snapshots {
+	s := &Snapshots{
+		cfg:   cfg,
+		queue: make(chan snapshotJob, snapshotQueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		last:  make(map[string]time.Time),
+	}
+	go s.run()
+	return s
+}
+
+func (s *Snapshots) HandleResults(r processing.FrameResult) {
+	sc := s.cfg.GetSnapshot()
+	if !sc.Enabled || r.Frame == nil || (!sc.Full && !sc.Crops) {
+		return
+	}
+
+	cooldown := time.Duration(sc.CooldownMs) * time.Millisecond
+
+	// Every detection of a class that is off cooldown is kept, so one
+	// snapshot shows all the people that triggered it, not just the first.
+	var triggered []models.DetectionResult
+	fired := make(map[string]bool)
+	for _, d := range r.Detections {
+		if d.Score < sc.MinScore || (len(sc.Classes) > 0 && !slices.Contains(sc.Classes, d.Class)) {
+			continue
+		}
+		if !fired[d.Class] {
+			if last, ok := s.last[d.Class]; ok && r.At.Sub(last) < cooldown {
+				continue
+			}
+			fired[d.Class] = true
+		}
+		triggered = append(triggered, d)
+	}
+	if len(triggered) == 0 {
+		return
+	}
+
+	r.Detections = triggered
+	select {
+	case s.queue <- snapshotJob{cfg: sc, result: r}:
+	default:
+		// Not saved, so the classes stay off cooldown for the next frame.
+		s.dropped.Add(1)
+		return
+	}
+
+	for class := range fired {
+		s.last[class] = r.At
+	}
+}
+
+func (s *Snapshots) HandleAlert(processing.Alert) {}
+
+// Dropped returns how many snapshots were skipped because saving fell behind.
+func (s *Snapshots) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close saves the queued snapshots and stops.
+func (s *Snapshots) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Snapshots) run() {
+	defer close(s.done)
+
+	save := func(job snapshotJob) {
+		if _, err := saveSnapshot(job.cfg, job.result, "detection", false); err != nil {
+			log.Print("Snapshot error:", err)
+		}
+	}
+
+	for {
+		select {
+		case job := <-s.queue:
+			save(job)
+		case <-s.stop:
+			for {
+				select {
+				case job := <-s.queue:
+					save(job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// SaveAnnotated saves r's frame with its detections drawn in, on demand
+// rather than triggered by a class, and returns the path of the image.
+func SaveAnnotated(sc config.SnapshotConfig, r processing.FrameResult) (string, error) {
+	if r.Frame == nil {
+		return "", fmt.Errorf("snapshot: no frame to save")
+	}
+
+	sc.Full, sc.Crops = true, false
+	r.Frame = annotate.Frame(r.Frame, r.Detections)
+	return saveSnapshot(sc, r, "manual", true)
+}
+
+// saveSnapshot writes the images for r and their sidecar, and returns the
+// path of the full frame, or of the first crop when only crops are saved.
+func saveSnapshot(sc config.SnapshotConfig, r processing.FrameResult, trigger string, annotated bool) (string, error) {
+	if err := os.MkdirAll(sc.Dir, 0755); err != nil {
+		return "", err
+	}
+
+	quality := sc.Quality
+	if quality <= 0 || quality > 100 {
+		quality = 90
+	}
+
+	meta := SnapshotMeta{
+		Time:      r.At,
+		Frame:     r.ID,
+		Source:    r.Source,
+		Trigger:   trigger,
+		Annotated: annotated,
+	}
+
+	stamp := r.At.Format(snapshotTimeFormat)
+	var first string
+
+	if sc.Full {
+		base := stamp + "_" + trigger
+		if best, ok := bestDetection(r.Detections); ok && trigger != "manual" {
+			base = snapshotName(stamp, best)
+		}
+		base, err := writeJPEG(sc.Dir, base, r.Frame, quality)
+		if err != nil {
+			return "", err
+		}
+		meta.Image = base + ".jpg"
+		first = base
+	}
+
+	for _, d := range r.Detections {
+		obj := SnapshotObject{DetectionResult: d}
+
+		if sc.Crops {
+			rect := annotate.BoxRect(r.Frame.Bounds(), d)
+			if !rect.Empty() {
+				base, err := writeJPEG(sc.Dir, snapshotName(stamp, d)+"_crop", crop(r.Frame, rect), quality)
+				if err != nil {
+					return "", err
+				}
+				obj.Crop = base + ".jpg"
+				if first == "" {
+					first = base
+				}
+			}
+		}
+
+		meta.Detections = append(meta.Detections, obj)
+	}
+
+	if first == "" {
+		return "", nil
+	}
+	if meta.Detections == nil {
+		meta.Detections = []SnapshotObject{}
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(sc.Dir, first+".json"), data, 0644); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(sc.Dir, first+".jpg"), nil
+}
+
+func snapshotName(stamp string, d models.DetectionResult) string {
+	name := fmt.Sprintf("%s_%s_%.2f", stamp, fileSafe(d.Class), d.Score)
+	if d.TrackID != 0 {
+		name += fmt.Sprintf("_t%d", d.TrackID)
+	}
+	return name
+}
+
+func bestDetection(dets []models.DetectionResult) (models.DetectionResult, bool) {
+	if len(dets) == 0 {
+		return models.DetectionResult{}, false
+	}
+	return slices.MaxFunc(dets, func(a, b models.DetectionResult) int {
+		switch {
+		case a.Score < b.Score:
+			return -1
+		case a.Score > b.Score:
+			return 1
+		}
+		return 0
+	}), true
+}
+
+// fileSafe replaces characters that are awkward in file names.
+func fileSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == '_' || r == ' ' || r < 0x20 {
+			return '-'
+		}
+		return r
+	}, s)
+}
+
+func crop(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(out, out.Bounds(), img, r.Min, draw.Src)
+	return out
+}
+
+// writeJPEG saves img as base.jpg in dir, or as base_2.jpg and so on if that
+// is taken, and returns the base it used. Files are never overwritten, also
+// when another writer picks the same name at the same time.
+func writeJPEG(dir, base string, img image.Image, quality int) (string, error) {
+	name := base
+	f, err := os.OpenFile(filepath.Join(dir, name+".jpg"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	for i := 2; os.IsExist(err); i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+		f, err = os.OpenFile(filepath.Join(dir, name+".jpg"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: quality}); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return name, f.Close()
+}
@@ -0,0 +1,84 @@
+package sink
+
+import (
+	"image"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	"vision/internal/models"
+	processing "vision/processing/detector"
+)
+
+func snapshotResult(at time.Time) processing.FrameResult {
+	return processing.FrameResult{
+		At:    at,
+		Frame: image.NewRGBA(image.Rect(0, 0, 32, 32)),
+		Detections: []models.DetectionResult{
+			{Class: "person", Score: 0.9, Box: []float32{0.1, 0.1, 0.5, 0.5}, TrackID: 3},
+		},
+	}
+}
+
+func TestSnapshotNamesNeverCollide(t *testing.T) {
+	sc := config.SnapshotConfig{Dir: t.TempDir(), Full: true, Crops: true}
+	r := snapshotResult(time.Now())
+
+	// The same frame twice, as two sinks or a quick manual save would.
+	var paths []string
+	for range 2 {
+		path, err := saveSnapshot(sc, r, "detection", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	if paths[0] == paths[1] {
+		t.Fatalf("both snapshots saved as %s", paths[0])
+	}
+
+	entries, err := os.ReadDir(sc.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	// A frame, a crop and a sidecar each.
+	if len(names) != 6 {
+		t.Errorf("files = %v, want 6", names)
+	}
+	stamp := r.At.Format(snapshotTimeFormat)
+	if !slices.Contains(names, stamp+"_person_0.90_t3_2.jpg") {
+		t.Errorf("files = %v, want a suffixed second frame", names)
+	}
+}
+
+func TestSnapshotCooldownOnlyAfterQueued(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.SetSnapshot(config.SnapshotConfig{Enabled: true, Dir: t.TempDir(), Full: true, CooldownMs: 60_000})
+
+	// No room in the queue and nothing draining it.
+	s := &Snapshots{cfg: cfg, queue: make(chan snapshotJob), last: make(map[string]time.Time)}
+	now := time.Now()
+
+	s.HandleResults(snapshotResult(now))
+	if s.Dropped() != 1 {
+		t.Fatalf("dropped = %d, want 1", s.Dropped())
+	}
+	if _, ok := s.last["person"]; ok {
+		t.Fatal("cooldown started for a dropped snapshot")
+	}
+
+	s.queue = make(chan snapshotJob, 1)
+	s.HandleResults(snapshotResult(now.Add(time.Second)))
+	if len(s.queue) != 1 {
+		t.Fatal("snapshot not queued after a drop")
+	}
+	if last := s.last["person"]; !last.Equal(now.Add(time.Second)) {
+		t.Errorf("cooldown started at %v", last)
+	}
+}
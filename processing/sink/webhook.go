@@ -0,0 +1,332 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+)
+
+const (
+	webhookQueueSize     = 256
+	webhookRetryInterval = 30 * time.Second
+	webhookUserAgent     = "vision-webhook/1"
+)
+
+// Headers set on every webhook request. The signature is the hex HMAC-SHA256
+// of the timestamp, a dot and the body, keyed with the configured secret.
+const (
+	HeaderEvent     = "X-Vision-Event"
+	HeaderTimestamp = "X-Vision-Timestamp"
+	HeaderSignature = "X-Vision-Signature"
+)
+
+// WebhookStats counts deliveries. Queued is the number waiting on disk, and
+// Pruned those removed from there to make room for newer ones.
+type WebhookStats struct {
+	Sent    uint64
+	Failed  uint64
+	Queued  uint64
+	Pruned  uint64
+	Pending int
+}
+
+// Webhook POSTs events to the configured URLs. Deliveries are made one at a
+// time by a background goroutine, retried with exponential backoff and, when
+// that fails too, kept on disk and retried until the receiver accepts them.
+// Requests rejected as invalid (4xx other than 408 and 429) are not retried.
+type Webhook struct {
+	cfg    config.WebhookConfig
+	client *http.Client
+	queue  chan delivery
+	disk   *diskQueue
+	limit  *rateLimiter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu    sync.Mutex
+	stats WebhookStats
+}
+
+var _ processing.Sink = (*Webhook)(nil)
+
+func NewWebhook(cfg config.WebhookConfig) (*Webhook, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("webhook: no URLs configured")
+	}
+	if cfg.QueueDir == "" {
+		cfg.QueueDir = "webhook-queue"
+	}
+	if cfg.TimeoutMs <= 0 {
+		cfg.TimeoutMs = 5000
+	}
+	if cfg.RetryMinMs <= 0 {
+		cfg.RetryMinMs = 500
+	}
+	if cfg.RetryMaxMs < cfg.RetryMinMs {
+		cfg.RetryMaxMs = cfg.RetryMinMs
+	}
+	if cfg.QueueMax <= 0 {
+		cfg.QueueMax = 10000
+	}
+
+	// Entries left over from an earlier run are delivered first.
+	disk, err := newDiskQueue(cfg.QueueDir, cfg.QueueMax)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Webhook{
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond},
+		queue:  make(chan delivery, webhookQueueSize),
+		disk:   disk,
+		limit:  newRateLimiter(cfg.RatePerSec),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go w.run()
+	return w, nil
+}
+
+func (w *Webhook) HandleResults(r processing.FrameResult) {
+	if w.cfg.Detections {
+		w.enqueue(NewResultsEvent(r))
+	}
+}
+
+func (w *Webhook) HandleAlert(a processing.Alert) {
+	if w.cfg.Alerts {
+		w.enqueue(NewAlertEvent(a))
+	}
+}
+
+func (w *Webhook) Stats() WebhookStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := w.stats
+	s.Queued = uint64(w.disk.Len())
+	s.Pending = len(w.queue)
+	return s
+}
+
+// Close stops delivering and moves everything still queued in memory to the
+// disk queue, so it is sent after the next start.
+func (w *Webhook) Close() {
+	w.cancel()
+	<-w.done
+
+	for {
+		select {
+		case d := <-w.queue:
+			w.spill(d)
+		default:
+			return
+		}
+	}
+}
+
+// SignPayload returns the signature header value for body sent at ts.
+func SignPayload(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// enqueue never blocks: when the memory queue is full the delivery goes
+// straight to disk.
+func (w *Webhook) enqueue(e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Print("Webhook encode error:", err)
+		return
+	}
+
+	for _, url := range w.cfg.URLs {
+		d := delivery{URL: url, Event: e.Event, Body: body}
+		select {
+		case w.queue <- d:
+		default:
+			w.spill(d)
+		}
+	}
+}
+
+func (w *Webhook) spill(d delivery) {
+	pruned, err := w.disk.Push(d)
+	if pruned > 0 {
+		w.count(func(s *WebhookStats) { s.Pruned += uint64(pruned) })
+	}
+	if err != nil {
+		log.Print("Webhook queue error:", err)
+		w.count(func(s *WebhookStats) { s.Failed++ })
+	}
+}
+
+func (w *Webhook) count(f func(*WebhookStats)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f(&w.stats)
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+
+	retry := time.NewTicker(webhookRetryInterval)
+	defer retry.Stop()
+
+	w.drainDisk()
+
+	for {
+		select {
+		case d := <-w.queue:
+			w.deliver(d)
+		case <-retry.C:
+			w.drainDisk()
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Webhook) deliver(d delivery) {
+	for attempt := 0; ; attempt++ {
+		err := w.post(d)
+		if err == nil {
+			w.count(func(s *WebhookStats) { s.Sent++ })
+			return
+		}
+
+		var perm permanentError
+		if errors.As(err, &perm) {
+			log.Printf("Webhook %s rejected %s event: %v", d.URL, d.Event, err)
+			w.count(func(s *WebhookStats) { s.Failed++ })
+			return
+		}
+
+		if attempt >= w.cfg.MaxRetries || w.ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-time.After(w.retryDelay(attempt)):
+		case <-w.ctx.Done():
+		}
+	}
+
+	w.spill(d)
+}
+
+// drainDisk retries queued deliveries in order and stops at the first one
+// that still fails, as the receiver is most likely still down.
+func (w *Webhook) drainDisk() {
+	for _, path := range w.disk.List() {
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		d, err := w.disk.Load(path)
+		if os.IsNotExist(err) {
+			// Pruned since it was listed.
+			continue
+		}
+		if err != nil {
+			log.Printf("Webhook dropping unreadable queue entry %s: %v", path, err)
+			w.disk.Remove(path)
+			continue
+		}
+
+		err = w.post(d)
+
+		var perm permanentError
+		switch {
+		case err == nil:
+			w.count(func(s *WebhookStats) { s.Sent++ })
+		case errors.As(err, &perm):
+			log.Printf("Webhook %s rejected %s event: %v", d.URL, d.Event, err)
+			w.count(func(s *WebhookStats) { s.Failed++ })
+		default:
+			return
+		}
+		w.disk.Remove(path)
+	}
+}
+
+func (w *Webhook) post(d delivery) error {
+	if err := w.limit.Wait(w.ctx); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return permanentError{err}
+	}
+
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	if w.cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, SignPayload(w.cfg.Secret, ts, d.Body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("status %s", resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return permanentError{fmt.Errorf("status %s", resp.Status)}
+	default:
+		return fmt.Errorf("status %s", resp.Status)
+	}
+}
+
+// retryDelay grows exponentially from RetryMinMs up to RetryMaxMs, with
+// full jitter.
+func (w *Webhook) retryDelay(attempt int) time.Duration {
+	lo := time.Duration(w.cfg.RetryMinMs) * time.Millisecond
+	hi := time.Duration(w.cfg.RetryMaxMs) * time.Millisecond
+
+	ceil := lo << attempt
+	if ceil <= 0 || ceil > hi {
+		ceil = hi
+	}
+	return lo/2 + time.Duration(rand.Int63n(int64(ceil-lo/2)+1))
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
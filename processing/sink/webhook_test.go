@@ -0,0 +1,273 @@
+package sink
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"vision/internal/config"
+	processing "vision/processing/detector"
+)
+
+// receiver records the webhook requests it gets and answers them with the
+// next status from its script, then with 200.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(r)
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	r.requests = append(r.requests, receivedRequest{header: req.Header.Clone(), body: body, at: time.Now()})
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	r.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+func testWebhookConfig(t *testing.T, url string) config.WebhookConfig {
+	return config.WebhookConfig{
+		URLs:       []string{url},
+		Alerts:     true,
+		MaxRetries: 3,
+		RetryMinMs: 1,
+		RetryMaxMs: 5,
+		QueueDir:   t.TempDir(),
+	}
+}
+
+func newTestWebhook(t *testing.T, cfg config.WebhookConfig) *Webhook {
+	t.Helper()
+	w, err := NewWebhook(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Close)
+	return w
+}
+
+func testAlert(id uint64) processing.Alert {
+	return processing.Alert{Rule: "door", At: time.Now(), FrameID: id, Count: 1}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	recv := newReceiver(t)
+	cfg := testWebhookConfig(t, recv.URL)
+	cfg.Secret = "s3cret"
+	w := newTestWebhook(t, cfg)
+
+	w.HandleAlert(testAlert(1))
+	waitFor(t, "the request", func() bool { return len(recv.received()) == 1 })
+
+	req := recv.received()[0]
+	if got := req.header.Get(HeaderEvent); got != EventAlert {
+		t.Errorf("%s = %q, want %q", HeaderEvent, got, EventAlert)
+	}
+	ts, err := strconv.ParseInt(req.header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.header.Get(HeaderSignature), SignPayload(cfg.Secret, ts, req.body); got != want {
+		t.Errorf("%s = %q, want %q", HeaderSignature, got, want)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		attempts int
+		sent     uint64
+		failed   uint64
+	}{
+		{"server error", []int{500, 500, 200}, 3, 1, 0},
+		{"rate limited", []int{429, 200}, 2, 1, 0},
+		{"bad request", []int{400}, 1, 0, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recv := newReceiver(t, tc.statuses...)
+			w := newTestWebhook(t, testWebhookConfig(t, recv.URL))
+
+			w.HandleAlert(testAlert(1))
+			waitFor(t, "the delivery", func() bool {
+				s := w.Stats()
+				return s.Sent+s.Failed > 0
+			})
+
+			if n := len(recv.received()); n != tc.attempts {
+				t.Errorf("attempts = %d, want %d", n, tc.attempts)
+			}
+			if s := w.Stats(); s.Sent != tc.sent || s.Failed != tc.failed || s.Queued != 0 {
+				t.Errorf("stats = %+v", s)
+			}
+		})
+	}
+}
+
+func TestWebhookQueueReplay(t *testing.T) {
+	// Reserve an address nothing listens on yet.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := testWebhookConfig(t, "http://"+addr+"/hook")
+	cfg.MaxRetries = 1
+
+	w, err := NewWebhook(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.HandleAlert(testAlert(1))
+	w.HandleAlert(testAlert(2))
+	waitFor(t, "the deliveries to spill", func() bool { return w.Stats().Queued == 2 })
+	w.Close()
+
+	entries, err := os.ReadDir(cfg.QueueDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("queue holds %d entries, want 2", len(entries))
+	}
+
+	// Bring the receiver up and restart the webhook on the same queue.
+	recv := &receiver{}
+	recv.Server = httptest.NewUnstartedServer(recv)
+	recv.Listener.Close()
+	if recv.Listener, err = net.Listen("tcp", addr); err != nil {
+		t.Skipf("address %s taken meanwhile: %v", addr, err)
+	}
+	recv.Start()
+	defer recv.Close()
+
+	w = newTestWebhook(t, cfg)
+	waitFor(t, "the queue to drain", func() bool { return w.Stats().Sent == 2 })
+
+	if n := len(recv.received()); n != 2 {
+		t.Errorf("receiver got %d requests, want 2", n)
+	}
+	if s := w.Stats(); s.Queued != 0 {
+		t.Errorf("stats after replay = %+v", s)
+	}
+	if entries, _ := os.ReadDir(cfg.QueueDir); len(entries) != 0 {
+		t.Errorf("queue still holds %d entries", len(entries))
+	}
+}
+
+func TestWebhookQueuePrune(t *testing.T) {
+	// Nothing listens on the address, so every delivery spills.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := testWebhookConfig(t, "http://"+addr+"/hook")
+	cfg.MaxRetries = 0
+	cfg.QueueMax = 3
+
+	w := newTestWebhook(t, cfg)
+	for id := range uint64(5) {
+		w.HandleAlert(testAlert(id + 1))
+	}
+	waitFor(t, "the queue to fill", func() bool {
+		s := w.Stats()
+		return s.Queued+s.Pruned == 5
+	})
+	if s := w.Stats(); s.Queued != 3 || s.Pruned != 2 || s.Failed != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// The newest entries are kept, in order.
+	var ids []uint64
+	for _, path := range w.disk.List() {
+		d, err := w.disk.Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e Event
+		if err := json.Unmarshal(d.Body, &e); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.FrameID)
+	}
+	if !slices.Equal(ids, []uint64{3, 4, 5}) {
+		t.Errorf("queue holds frames %v, want 3, 4, 5", ids)
+	}
+	if entries, _ := os.ReadDir(cfg.QueueDir); len(entries) != 3 {
+		t.Errorf("queue directory holds %d files, want 3", len(entries))
+	}
+}
+
+func TestWebhookRateLimit(t *testing.T) {
+	const rate, events = 20, 25
+
+	recv := newReceiver(t)
+	cfg := testWebhookConfig(t, recv.URL)
+	cfg.RatePerSec = rate
+	w := newTestWebhook(t, cfg)
+
+	for i := range uint64(events) {
+		w.HandleAlert(testAlert(i))
+	}
+	waitFor(t, "every request", func() bool { return len(recv.received()) == events })
+
+	// A full bucket lets the first rate requests through at once, the rest
+	// follow at rate per second.
+	reqs := recv.received()
+	want := time.Duration(events-rate) * time.Second / rate
+	if got := reqs[len(reqs)-1].at.Sub(reqs[0].at); got < want*9/10 {
+		t.Errorf("%d requests took %v, want at least %v", events, got, want)
+	}
+}